@@ -19,9 +19,25 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Log_Append_FullMethodName = "/log.v1.Log/Append"
-	Log_Read_FullMethodName   = "/log.v1.Log/Read"
-	Log_Reset_FullMethodName  = "/log.v1.Log/Reset"
+	Log_Append_FullMethodName              = "/log.v1.Log/Append"
+	Log_Read_FullMethodName                = "/log.v1.Log/Read"
+	Log_Reset_FullMethodName               = "/log.v1.Log/Reset"
+	Log_ListArchives_FullMethodName        = "/log.v1.Log/ListArchives"
+	Log_RestoreArchive_FullMethodName      = "/log.v1.Log/RestoreArchive"
+	Log_Offsets_FullMethodName             = "/log.v1.Log/Offsets"
+	Log_ListKeys_FullMethodName            = "/log.v1.Log/ListKeys"
+	Log_InstallKey_FullMethodName          = "/log.v1.Log/InstallKey"
+	Log_UseKey_FullMethodName              = "/log.v1.Log/UseKey"
+	Log_RemoveKey_FullMethodName           = "/log.v1.Log/RemoveKey"
+	Log_ClusterQuery_FullMethodName        = "/log.v1.Log/ClusterQuery"
+	Log_DescribeTopic_FullMethodName       = "/log.v1.Log/DescribeTopic"
+	Log_ListPolicies_FullMethodName        = "/log.v1.Log/ListPolicies"
+	Log_AddPolicy_FullMethodName           = "/log.v1.Log/AddPolicy"
+	Log_RemovePolicy_FullMethodName        = "/log.v1.Log/RemovePolicy"
+	Log_AddRoleForSubject_FullMethodName   = "/log.v1.Log/AddRoleForSubject"
+	Log_VerifyAuditLog_FullMethodName      = "/log.v1.Log/VerifyAuditLog"
+	Log_GetMirrorCheckpoint_FullMethodName = "/log.v1.Log/GetMirrorCheckpoint"
+	Log_SetMirrorCheckpoint_FullMethodName = "/log.v1.Log/SetMirrorCheckpoint"
 )
 
 // LogClient is the client API for Log service.
@@ -32,8 +48,41 @@ type LogClient interface {
 	Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendResponse, error)
 	// 读取一条日志
 	Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (*ReadResponse, error)
-	// 删除所有日志
+	// 删除所有日志，需要先申请确认令牌再用令牌确认
+	// 被删除的日志保存在归档中
 	Reset(ctx context.Context, in *ResetRequest, opts ...grpc.CallOption) (*ResetResponse, error)
+	// 列出 Reset 产生的所有归档
+	ListArchives(ctx context.Context, in *ListArchivesRequest, opts ...grpc.CallOption) (*ListArchivesResponse, error)
+	// 用归档中的日志替换当前的日志，当前的日志也会被归档
+	RestoreArchive(ctx context.Context, in *RestoreArchiveRequest, opts ...grpc.CallOption) (*RestoreArchiveResponse, error)
+	// 获取第一条和最后一条日志的下标
+	Offsets(ctx context.Context, in *OffsetsRequest, opts ...grpc.CallOption) (*OffsetsResponse, error)
+	// 列出集群中所有节点的 gossip 加密密钥
+	ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	// 在集群中的所有节点上安装一个新密钥
+	InstallKey(ctx context.Context, in *KeyringRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	// 让集群中的所有节点使用指定的密钥加密消息
+	UseKey(ctx context.Context, in *KeyringRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	// 从集群中的所有节点上删除一个密钥
+	RemoveKey(ctx context.Context, in *KeyringRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	// 在集群中的所有节点上执行一个命令并收集每个节点的响应
+	ClusterQuery(ctx context.Context, in *ClusterQueryRequest, opts ...grpc.CallOption) (*ClusterQueryResponse, error)
+	// 查看主题的每个分区被分配给了哪些节点
+	DescribeTopic(ctx context.Context, in *DescribeTopicRequest, opts ...grpc.CallOption) (*DescribeTopicResponse, error)
+	// 列出所有访问控制策略和角色分配
+	ListPolicies(ctx context.Context, in *ListPoliciesRequest, opts ...grpc.CallOption) (*ListPoliciesResponse, error)
+	// 添加一条访问控制策略
+	AddPolicy(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyResponse, error)
+	// 删除一条访问控制策略
+	RemovePolicy(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyResponse, error)
+	// 为用户或角色分配一个角色
+	AddRoleForSubject(ctx context.Context, in *RoleRequest, opts ...grpc.CallOption) (*PolicyResponse, error)
+	// 验证审计日志的哈希链是否完整
+	VerifyAuditLog(ctx context.Context, in *VerifyAuditLogRequest, opts ...grpc.CallOption) (*VerifyAuditLogResponse, error)
+	// 读取和保存从其他集群镜像到本集群的进度
+	// 进度保存在服务器上，不写入日志
+	GetMirrorCheckpoint(ctx context.Context, in *GetMirrorCheckpointRequest, opts ...grpc.CallOption) (*GetMirrorCheckpointResponse, error)
+	SetMirrorCheckpoint(ctx context.Context, in *SetMirrorCheckpointRequest, opts ...grpc.CallOption) (*SetMirrorCheckpointResponse, error)
 }
 
 type logClient struct {
@@ -71,6 +120,150 @@ func (c *logClient) Reset(ctx context.Context, in *ResetRequest, opts ...grpc.Ca
 	return out, nil
 }
 
+func (c *logClient) ListArchives(ctx context.Context, in *ListArchivesRequest, opts ...grpc.CallOption) (*ListArchivesResponse, error) {
+	out := new(ListArchivesResponse)
+	err := c.cc.Invoke(ctx, Log_ListArchives_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) RestoreArchive(ctx context.Context, in *RestoreArchiveRequest, opts ...grpc.CallOption) (*RestoreArchiveResponse, error) {
+	out := new(RestoreArchiveResponse)
+	err := c.cc.Invoke(ctx, Log_RestoreArchive_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) Offsets(ctx context.Context, in *OffsetsRequest, opts ...grpc.CallOption) (*OffsetsResponse, error) {
+	out := new(OffsetsResponse)
+	err := c.cc.Invoke(ctx, Log_Offsets_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, Log_ListKeys_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) InstallKey(ctx context.Context, in *KeyringRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, Log_InstallKey_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) UseKey(ctx context.Context, in *KeyringRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, Log_UseKey_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) RemoveKey(ctx context.Context, in *KeyringRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, Log_RemoveKey_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) ClusterQuery(ctx context.Context, in *ClusterQueryRequest, opts ...grpc.CallOption) (*ClusterQueryResponse, error) {
+	out := new(ClusterQueryResponse)
+	err := c.cc.Invoke(ctx, Log_ClusterQuery_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) DescribeTopic(ctx context.Context, in *DescribeTopicRequest, opts ...grpc.CallOption) (*DescribeTopicResponse, error) {
+	out := new(DescribeTopicResponse)
+	err := c.cc.Invoke(ctx, Log_DescribeTopic_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) ListPolicies(ctx context.Context, in *ListPoliciesRequest, opts ...grpc.CallOption) (*ListPoliciesResponse, error) {
+	out := new(ListPoliciesResponse)
+	err := c.cc.Invoke(ctx, Log_ListPolicies_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) AddPolicy(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyResponse, error) {
+	out := new(PolicyResponse)
+	err := c.cc.Invoke(ctx, Log_AddPolicy_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) RemovePolicy(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyResponse, error) {
+	out := new(PolicyResponse)
+	err := c.cc.Invoke(ctx, Log_RemovePolicy_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) AddRoleForSubject(ctx context.Context, in *RoleRequest, opts ...grpc.CallOption) (*PolicyResponse, error) {
+	out := new(PolicyResponse)
+	err := c.cc.Invoke(ctx, Log_AddRoleForSubject_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) VerifyAuditLog(ctx context.Context, in *VerifyAuditLogRequest, opts ...grpc.CallOption) (*VerifyAuditLogResponse, error) {
+	out := new(VerifyAuditLogResponse)
+	err := c.cc.Invoke(ctx, Log_VerifyAuditLog_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) GetMirrorCheckpoint(ctx context.Context, in *GetMirrorCheckpointRequest, opts ...grpc.CallOption) (*GetMirrorCheckpointResponse, error) {
+	out := new(GetMirrorCheckpointResponse)
+	err := c.cc.Invoke(ctx, Log_GetMirrorCheckpoint_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) SetMirrorCheckpoint(ctx context.Context, in *SetMirrorCheckpointRequest, opts ...grpc.CallOption) (*SetMirrorCheckpointResponse, error) {
+	out := new(SetMirrorCheckpointResponse)
+	err := c.cc.Invoke(ctx, Log_SetMirrorCheckpoint_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LogServer is the server API for Log service.
 // All implementations must embed UnimplementedLogServer
 // for forward compatibility
@@ -79,8 +272,41 @@ type LogServer interface {
 	Append(context.Context, *AppendRequest) (*AppendResponse, error)
 	// 读取一条日志
 	Read(context.Context, *ReadRequest) (*ReadResponse, error)
-	// 删除所有日志
+	// 删除所有日志，需要先申请确认令牌再用令牌确认
+	// 被删除的日志保存在归档中
 	Reset(context.Context, *ResetRequest) (*ResetResponse, error)
+	// 列出 Reset 产生的所有归档
+	ListArchives(context.Context, *ListArchivesRequest) (*ListArchivesResponse, error)
+	// 用归档中的日志替换当前的日志，当前的日志也会被归档
+	RestoreArchive(context.Context, *RestoreArchiveRequest) (*RestoreArchiveResponse, error)
+	// 获取第一条和最后一条日志的下标
+	Offsets(context.Context, *OffsetsRequest) (*OffsetsResponse, error)
+	// 列出集群中所有节点的 gossip 加密密钥
+	ListKeys(context.Context, *ListKeysRequest) (*KeyringResponse, error)
+	// 在集群中的所有节点上安装一个新密钥
+	InstallKey(context.Context, *KeyringRequest) (*KeyringResponse, error)
+	// 让集群中的所有节点使用指定的密钥加密消息
+	UseKey(context.Context, *KeyringRequest) (*KeyringResponse, error)
+	// 从集群中的所有节点上删除一个密钥
+	RemoveKey(context.Context, *KeyringRequest) (*KeyringResponse, error)
+	// 在集群中的所有节点上执行一个命令并收集每个节点的响应
+	ClusterQuery(context.Context, *ClusterQueryRequest) (*ClusterQueryResponse, error)
+	// 查看主题的每个分区被分配给了哪些节点
+	DescribeTopic(context.Context, *DescribeTopicRequest) (*DescribeTopicResponse, error)
+	// 列出所有访问控制策略和角色分配
+	ListPolicies(context.Context, *ListPoliciesRequest) (*ListPoliciesResponse, error)
+	// 添加一条访问控制策略
+	AddPolicy(context.Context, *PolicyRequest) (*PolicyResponse, error)
+	// 删除一条访问控制策略
+	RemovePolicy(context.Context, *PolicyRequest) (*PolicyResponse, error)
+	// 为用户或角色分配一个角色
+	AddRoleForSubject(context.Context, *RoleRequest) (*PolicyResponse, error)
+	// 验证审计日志的哈希链是否完整
+	VerifyAuditLog(context.Context, *VerifyAuditLogRequest) (*VerifyAuditLogResponse, error)
+	// 读取和保存从其他集群镜像到本集群的进度
+	// 进度保存在服务器上，不写入日志
+	GetMirrorCheckpoint(context.Context, *GetMirrorCheckpointRequest) (*GetMirrorCheckpointResponse, error)
+	SetMirrorCheckpoint(context.Context, *SetMirrorCheckpointRequest) (*SetMirrorCheckpointResponse, error)
 	mustEmbedUnimplementedLogServer()
 }
 
@@ -97,6 +323,54 @@ func (UnimplementedLogServer) Read(context.Context, *ReadRequest) (*ReadResponse
 func (UnimplementedLogServer) Reset(context.Context, *ResetRequest) (*ResetResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Reset not implemented")
 }
+func (UnimplementedLogServer) ListArchives(context.Context, *ListArchivesRequest) (*ListArchivesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListArchives not implemented")
+}
+func (UnimplementedLogServer) RestoreArchive(context.Context, *RestoreArchiveRequest) (*RestoreArchiveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreArchive not implemented")
+}
+func (UnimplementedLogServer) Offsets(context.Context, *OffsetsRequest) (*OffsetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Offsets not implemented")
+}
+func (UnimplementedLogServer) ListKeys(context.Context, *ListKeysRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListKeys not implemented")
+}
+func (UnimplementedLogServer) InstallKey(context.Context, *KeyringRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InstallKey not implemented")
+}
+func (UnimplementedLogServer) UseKey(context.Context, *KeyringRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UseKey not implemented")
+}
+func (UnimplementedLogServer) RemoveKey(context.Context, *KeyringRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveKey not implemented")
+}
+func (UnimplementedLogServer) ClusterQuery(context.Context, *ClusterQueryRequest) (*ClusterQueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClusterQuery not implemented")
+}
+func (UnimplementedLogServer) DescribeTopic(context.Context, *DescribeTopicRequest) (*DescribeTopicResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeTopic not implemented")
+}
+func (UnimplementedLogServer) ListPolicies(context.Context, *ListPoliciesRequest) (*ListPoliciesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPolicies not implemented")
+}
+func (UnimplementedLogServer) AddPolicy(context.Context, *PolicyRequest) (*PolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddPolicy not implemented")
+}
+func (UnimplementedLogServer) RemovePolicy(context.Context, *PolicyRequest) (*PolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePolicy not implemented")
+}
+func (UnimplementedLogServer) AddRoleForSubject(context.Context, *RoleRequest) (*PolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddRoleForSubject not implemented")
+}
+func (UnimplementedLogServer) VerifyAuditLog(context.Context, *VerifyAuditLogRequest) (*VerifyAuditLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyAuditLog not implemented")
+}
+func (UnimplementedLogServer) GetMirrorCheckpoint(context.Context, *GetMirrorCheckpointRequest) (*GetMirrorCheckpointResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMirrorCheckpoint not implemented")
+}
+func (UnimplementedLogServer) SetMirrorCheckpoint(context.Context, *SetMirrorCheckpointRequest) (*SetMirrorCheckpointResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMirrorCheckpoint not implemented")
+}
 func (UnimplementedLogServer) mustEmbedUnimplementedLogServer() {}
 
 // UnsafeLogServer may be embedded to opt out of forward compatibility for this service.
@@ -164,6 +438,294 @@ func _Log_Reset_Handler(srv interface{}, ctx context.Context, dec func(interface
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Log_ListArchives_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListArchivesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).ListArchives(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_ListArchives_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).ListArchives(ctx, req.(*ListArchivesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_RestoreArchive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreArchiveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).RestoreArchive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_RestoreArchive_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).RestoreArchive(ctx, req.(*RestoreArchiveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_Offsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OffsetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).Offsets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_Offsets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).Offsets(ctx, req.(*OffsetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_ListKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).ListKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_ListKeys_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).ListKeys(ctx, req.(*ListKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_InstallKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyringRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).InstallKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_InstallKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).InstallKey(ctx, req.(*KeyringRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_UseKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyringRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).UseKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_UseKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).UseKey(ctx, req.(*KeyringRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_RemoveKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyringRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).RemoveKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_RemoveKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).RemoveKey(ctx, req.(*KeyringRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_ClusterQuery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).ClusterQuery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_ClusterQuery_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).ClusterQuery(ctx, req.(*ClusterQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_DescribeTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeTopicRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).DescribeTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_DescribeTopic_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).DescribeTopic(ctx, req.(*DescribeTopicRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_ListPolicies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPoliciesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).ListPolicies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_ListPolicies_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).ListPolicies(ctx, req.(*ListPoliciesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_AddPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).AddPolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_AddPolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).AddPolicy(ctx, req.(*PolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_RemovePolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).RemovePolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_RemovePolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).RemovePolicy(ctx, req.(*PolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_AddRoleForSubject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).AddRoleForSubject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_AddRoleForSubject_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).AddRoleForSubject(ctx, req.(*RoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_VerifyAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyAuditLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).VerifyAuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_VerifyAuditLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).VerifyAuditLog(ctx, req.(*VerifyAuditLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_GetMirrorCheckpoint_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMirrorCheckpointRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).GetMirrorCheckpoint(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_GetMirrorCheckpoint_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).GetMirrorCheckpoint(ctx, req.(*GetMirrorCheckpointRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_SetMirrorCheckpoint_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetMirrorCheckpointRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).SetMirrorCheckpoint(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_SetMirrorCheckpoint_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).SetMirrorCheckpoint(ctx, req.(*SetMirrorCheckpointRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Log_ServiceDesc is the grpc.ServiceDesc for Log service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -183,6 +745,70 @@ var Log_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Reset",
 			Handler:    _Log_Reset_Handler,
 		},
+		{
+			MethodName: "ListArchives",
+			Handler:    _Log_ListArchives_Handler,
+		},
+		{
+			MethodName: "RestoreArchive",
+			Handler:    _Log_RestoreArchive_Handler,
+		},
+		{
+			MethodName: "Offsets",
+			Handler:    _Log_Offsets_Handler,
+		},
+		{
+			MethodName: "ListKeys",
+			Handler:    _Log_ListKeys_Handler,
+		},
+		{
+			MethodName: "InstallKey",
+			Handler:    _Log_InstallKey_Handler,
+		},
+		{
+			MethodName: "UseKey",
+			Handler:    _Log_UseKey_Handler,
+		},
+		{
+			MethodName: "RemoveKey",
+			Handler:    _Log_RemoveKey_Handler,
+		},
+		{
+			MethodName: "ClusterQuery",
+			Handler:    _Log_ClusterQuery_Handler,
+		},
+		{
+			MethodName: "DescribeTopic",
+			Handler:    _Log_DescribeTopic_Handler,
+		},
+		{
+			MethodName: "ListPolicies",
+			Handler:    _Log_ListPolicies_Handler,
+		},
+		{
+			MethodName: "AddPolicy",
+			Handler:    _Log_AddPolicy_Handler,
+		},
+		{
+			MethodName: "RemovePolicy",
+			Handler:    _Log_RemovePolicy_Handler,
+		},
+		{
+			MethodName: "AddRoleForSubject",
+			Handler:    _Log_AddRoleForSubject_Handler,
+		},
+		{
+			MethodName: "VerifyAuditLog",
+			Handler:    _Log_VerifyAuditLog_Handler,
+		},
+		{
+			MethodName: "GetMirrorCheckpoint",
+			Handler:    _Log_GetMirrorCheckpoint_Handler,
+		},
+		{
+			MethodName: "SetMirrorCheckpoint",
+			Handler:    _Log_SetMirrorCheckpoint_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/v1/log.proto",
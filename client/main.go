@@ -3,20 +3,35 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	api "github.com/youngfr/dcls/api/v1"
 	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/sign"
+	"github.com/youngfr/dcls/internal/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
-var addr = flag.String("addr", "127.0.0.1:8080", "the address to connect to")
+var (
+	addr = flag.String("addr", "127.0.0.1:8080", "the address to connect to")
+
+	// 追加的记录使用这个私钥签名，配置目录中有 trusted-keys.pem 时验证读到的记录的签名
+	signingKey = flag.String("signing-key", "", "the Ed25519 private key (PEM) used to sign appended records")
+	// 应该和服务器的 -signed-topics 相同，这些主题上没有签名的记录也验证失败
+	signedTopics = flag.String("signed-topics", "", "comma separated topic patterns (e.g. audit.*) whose records must be signed")
+
+	// 追踪每个请求，span 以 JSON 格式追加到这个文件中
+	traceFile = flag.String("trace-file", "", "the file spans of every request are appended to (empty disables tracing)")
+)
 
 func main() {
 	flag.Parse()
@@ -35,6 +50,36 @@ func main() {
 	clientOptions := []grpc.DialOption{
 		grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)),
 	}
+	if *traceFile != "" {
+		exporter, err := trace.NewFileExporter(*traceFile)
+		if err != nil {
+			log.Fatalf("failed to open trace file: %v\n", err)
+		}
+		defer exporter.Close()
+		clientOptions = append(clientOptions, grpc.WithChainUnaryInterceptor(trace.UnaryClientInterceptor(trace.NewTracer(exporter))))
+	}
+	var signer *sign.Signer
+	if *signingKey != "" {
+		if signer, err = sign.LoadSigner(*signingKey); err != nil {
+			log.Fatalf("failed to load signing key: %v\n", err)
+		}
+	}
+	var policy *sign.Policy
+	if _, err := os.Stat(auth.TrustedKeysFile); err == nil {
+		registry, err := sign.NewRegistry(auth.TrustedKeysFile)
+		if err != nil {
+			log.Fatalf("failed to load trusted keys: %v\n", err)
+		}
+		policy = &sign.Policy{Registry: registry}
+		if *signedTopics != "" {
+			policy.Topics = strings.Split(*signedTopics, ",")
+		}
+	} else if *signedTopics != "" {
+		log.Fatalf("-signed-topics requires %s\n", auth.TrustedKeysFile)
+	}
+	if signer != nil || policy != nil {
+		clientOptions = append(clientOptions, grpc.WithChainUnaryInterceptor(sign.UnaryClientInterceptor(signer, policy)))
+	}
 
 	conn, err := grpc.Dial(*addr, clientOptions...)
 	if err != nil {
@@ -47,8 +92,10 @@ func main() {
 	sc := bufio.NewScanner(os.Stdin)
 	for {
 		fmt.Print("> ")
-		if sc.Scan() {
-			args := strings.Fields(sc.Text())
+		if !sc.Scan() {
+			return
+		}
+		if args := strings.Fields(sc.Text()); len(args) > 0 {
 			if len(args) <= 2 {
 				switch strings.ToLower(args[0]) {
 				case "append":
@@ -75,11 +122,109 @@ func main() {
 						}
 					}
 				case "reset":
-					if resetRsp, err := client.Reset(ctx, &api.ResetRequest{}); err != nil {
+					// 不带参数时申请确认令牌，再用 reset <token> 确认
+					resetReq := &api.ResetRequest{}
+					if len(args) == 2 {
+						resetReq.ConfirmationToken = args[1]
+					}
+					if resetRsp, err := client.Reset(ctx, resetReq); err != nil {
 						fmt.Printf("reset failed: %v\n", err)
+					} else if resetRsp.ConfirmationToken != "" {
+						fmt.Printf("all logs will be archived, confirm before %s with:\nreset %s\n",
+							time.UnixMilli(resetRsp.ExpiresAtMs).Format(time.TimeOnly), resetRsp.ConfirmationToken)
+					} else if resetRsp.Archive != "" {
+						fmt.Printf("%s, archived as %s\n", resetRsp.Reply, resetRsp.Archive)
 					} else {
 						fmt.Printf("%s\n", resetRsp.Reply)
 					}
+				case "archives":
+					if listRsp, err := client.ListArchives(ctx, &api.ListArchivesRequest{}); err != nil {
+						fmt.Printf("archives failed: %v\n", err)
+					} else {
+						for _, a := range listRsp.Archives {
+							fmt.Printf("%s: offsets %d-%d, %d segments, %d bytes\n",
+								a.Name, a.LowestOffset, a.HighestOffset, a.Segments, a.Bytes)
+						}
+					}
+				case "restore":
+					if !hasArg(args, "restore <archive>") {
+						break
+					}
+					if restoreRsp, err := client.RestoreArchive(ctx, &api.RestoreArchiveRequest{Name: args[1]}); err != nil {
+						fmt.Printf("restore failed: %v\n", err)
+					} else if restoreRsp.Archive != "" {
+						fmt.Printf("restored %s, previous logs archived as %s\n", args[1], restoreRsp.Archive)
+					} else {
+						fmt.Printf("restored %s\n", args[1])
+					}
+				case "keys":
+					keyringRsp, err := client.ListKeys(ctx, &api.ListKeysRequest{})
+					printKeyring(keyringRsp, err)
+				case "install-key":
+					if hasArg(args, "install-key <base64 key>") {
+						keyringRsp, err := client.InstallKey(ctx, &api.KeyringRequest{Key: args[1]})
+						printKeyring(keyringRsp, err)
+					}
+				case "use-key":
+					if hasArg(args, "use-key <base64 key>") {
+						keyringRsp, err := client.UseKey(ctx, &api.KeyringRequest{Key: args[1]})
+						printKeyring(keyringRsp, err)
+					}
+				case "remove-key":
+					if hasArg(args, "remove-key <base64 key>") {
+						keyringRsp, err := client.RemoveKey(ctx, &api.KeyringRequest{Key: args[1]})
+						printKeyring(keyringRsp, err)
+					}
+				case "query":
+					// 在集群中的所有节点上执行命令，比如 offsets 和 reload-acl
+					if !hasArg(args, "query <command>") {
+						break
+					}
+					queryReq := &api.ClusterQueryRequest{Name: args[1], TimeoutMs: 5000}
+					if queryRsp, err := client.ClusterQuery(ctx, queryReq); err != nil {
+						fmt.Printf("query failed: %v\n", err)
+					} else {
+						for _, r := range queryRsp.Responses {
+							if r.Error != "" {
+								fmt.Printf("%s: error: %s\n", r.Node, r.Error)
+							} else {
+								fmt.Printf("%s: %s\n", r.Node, r.Payload)
+							}
+						}
+					}
+				case "describe":
+					if !hasArg(args, "describe <topic>") {
+						break
+					}
+					if describeRsp, err := client.DescribeTopic(ctx, &api.DescribeTopicRequest{Topic: args[1]}); err != nil {
+						fmt.Printf("describe failed: %v\n", err)
+					} else {
+						fmt.Printf("topic: %s, replicas: %d\n", describeRsp.Topic, describeRsp.ReplicationFactor)
+						if describeRsp.Advisory {
+							fmt.Println("  (advisory: the assignment is computed but not enforced)")
+						}
+						for _, p := range describeRsp.Partitions {
+							fmt.Printf("  partition %d: leader %s, replicas %v, syncing %v, retiring %v\n",
+								p.Id, p.Leader, p.Replicas, p.Syncing, p.Retiring)
+						}
+					}
+				case "keygen":
+					// 生成一个可以用于 gossip 加密的 AES-256 密钥
+					key := make([]byte, 32)
+					if _, err := rand.Read(key); err != nil {
+						fmt.Printf("keygen failed: %v\n", err)
+					} else {
+						fmt.Printf("%s\n", base64.StdEncoding.EncodeToString(key))
+					}
+				case "verify-audit":
+					// 验证服务器的审计日志是否被篡改
+					if verifyRsp, err := client.VerifyAuditLog(ctx, &api.VerifyAuditLogRequest{}); err != nil {
+						fmt.Printf("verify-audit failed: %v\n", err)
+					} else if verifyRsp.Valid {
+						fmt.Printf("audit log ok: %d entries, head %s\n", verifyRsp.Entries, verifyRsp.HeadHash)
+					} else {
+						fmt.Printf("audit log TAMPERED after %d entries: %s\n", verifyRsp.Entries, verifyRsp.Error)
+					}
 				case "q", "quit":
 					return
 				default:
@@ -91,3 +236,30 @@ func main() {
 		}
 	}
 }
+
+// 需要一个参数的命令缺少参数时打印用法
+func hasArg(args []string, usage string) bool {
+	if len(args) == 2 {
+		return true
+	}
+	fmt.Printf("usage: %s\n", usage)
+	return false
+}
+
+func printKeyring(rsp *api.KeyringResponse, err error) {
+	if err != nil {
+		fmt.Printf("keyring operation failed: %v\n", err)
+		return
+	}
+	fmt.Printf("nodes: %d, responses: %d, errors: %d\n", rsp.NumNodes, rsp.NumResp, rsp.NumErr)
+	for node, msg := range rsp.Messages {
+		fmt.Printf("  %s: %s\n", node, msg)
+	}
+	for key, n := range rsp.Keys {
+		primary := ""
+		if rsp.PrimaryKeys[key] > 0 {
+			primary = fmt.Sprintf(" (primary on %d)", rsp.PrimaryKeys[key])
+		}
+		fmt.Printf("  %s [%d/%d]%s\n", key, n, rsp.NumNodes, primary)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/certs"
+)
+
+const certsUsage = `usage: dcls certs <subcommand> [flags]
+
+subcommands:
+  init     create the certificate authority
+  server   issue a server certificate
+  client   issue a client certificate for a CN or a role
+  list     list issued certificates
+  revoke   revoke a certificate by file name or serial number
+
+All files are written to the config directory ($CONFIG_DIR or ~/.dcls).
+`
+
+var errCertsUsage = errors.New("invalid arguments, run dcls certs -h for usage")
+
+func runCerts(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, certsUsage)
+		return nil
+	}
+	dir := auth.ConfigDir()
+	switch args[0] {
+	case "init":
+		return certsInit(dir, args[1:])
+	case "server":
+		return certsServer(dir, args[1:])
+	case "client":
+		return certsClient(dir, args[1:])
+	case "list":
+		return certsList(dir, args[1:])
+	case "revoke":
+		return certsRevoke(dir, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, certsUsage)
+		return errCertsUsage
+	}
+}
+
+func certsInit(dir string, args []string) error {
+	fs := flag.NewFlagSet("certs init", flag.ExitOnError)
+	cn := fs.String("cn", "YoungFr CA", "the common name of the certificate authority")
+	validity := fs.Duration("validity", certs.DefaultCAValidity, "how long the root certificate is valid")
+	fs.Parse(args)
+
+	ca, err := certs.Init(dir, *cn, *validity)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created certificate authority %q in %s (serial %s)\n",
+		ca.Certificate().Subject.CommonName, dir, ca.Certificate().SerialNumber.Text(16))
+	return nil
+}
+
+func certsServer(dir string, args []string) error {
+	fs := flag.NewFlagSet("certs server", flag.ExitOnError)
+	name := fs.String("name", "server", "write the certificate to <name>.pem and the key to <name>-key.pem")
+	cn := fs.String("cn", "log server", "the common name of the certificate")
+	hosts := fs.String("hosts", "localhost,127.0.0.1", "comma separated DNS names, IP addresses, URIs or emails")
+	validity := fs.Duration("validity", certs.DefaultValidity, "how long the certificate is valid")
+	fs.Parse(args)
+
+	return issue(dir, certs.Request{
+		Name:       *name,
+		CommonName: *cn,
+		Hosts:      strings.Split(*hosts, ","),
+		Server:     true,
+		Validity:   *validity,
+	})
+}
+
+func certsClient(dir string, args []string) error {
+	fs := flag.NewFlagSet("certs client", flag.ExitOnError)
+	name := fs.String("name", "", "write the certificate to <name>.pem and the key to <name>-key.pem (defaults to <cn>-client)")
+	cn := fs.String("cn", "", "the identity of the client, e.g. \"root user\"")
+	role := fs.String("role", "", "issue a certificate whose identity is a role in policy.csv, e.g. writer")
+	hosts := fs.String("sans", "", "comma separated DNS names, URIs (SPIFFE IDs) or emails")
+	validity := fs.Duration("validity", certs.DefaultValidity, "how long the certificate is valid")
+	fs.Parse(args)
+
+	// 角色也可以直接作为访问控制的主体
+	if (*cn == "") == (*role == "") {
+		return errors.New("exactly one of -cn and -role is required")
+	}
+	if *cn == "" {
+		*cn = *role
+	}
+	if *name == "" {
+		*name = strings.ReplaceAll(*cn, " ", "-") + "-client"
+	}
+	return issue(dir, certs.Request{
+		Name:       *name,
+		CommonName: *cn,
+		Hosts:      strings.Split(*hosts, ","),
+		Validity:   *validity,
+	})
+}
+
+func issue(dir string, req certs.Request) error {
+	ca, err := certs.Load(dir)
+	if err != nil {
+		return err
+	}
+	cert, err := ca.Issue(req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("issued %s.pem for %q (serial %s, expires %s)\n",
+		req.Name, cert.Subject.CommonName, cert.SerialNumber.Text(16), cert.NotAfter.Format(time.DateOnly))
+	return nil
+}
+
+func certsList(dir string, args []string) error {
+	fs := flag.NewFlagSet("certs list", flag.ExitOnError)
+	fs.Parse(args)
+
+	ca, err := certs.Load(dir)
+	if err != nil {
+		return err
+	}
+	issued, err := ca.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCN\tSERIAL\tEXPIRES\tSTATUS")
+	for _, i := range issued {
+		status := "valid"
+		switch {
+		case !i.RevokedAt.IsZero():
+			status = "revoked " + i.RevokedAt.Format(time.DateOnly)
+		case time.Now().After(i.Cert.NotAfter):
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			i.Name, i.Cert.Subject.CommonName, i.Cert.SerialNumber.Text(16), i.Cert.NotAfter.Format(time.DateOnly), status)
+	}
+	return w.Flush()
+}
+
+func certsRevoke(dir string, args []string) error {
+	fs := flag.NewFlagSet("certs revoke", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: dcls certs revoke <name|serial>")
+	}
+
+	ca, err := certs.Load(dir)
+	if err != nil {
+		return err
+	}
+	revoked, err := ca.Revoke(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// 服务器定期检查 CRL 文件，文件变化或者收到 SIGHUP 时重新加载，不需要重启
+	fmt.Printf("revoked %s.pem for %q (serial %s)\n",
+		revoked.Name, revoked.Cert.Subject.CommonName, revoked.Cert.SerialNumber.Text(16))
+	fmt.Printf("servers reject it after reloading %s (copy it to each server's config directory)\n", certs.CRLFile)
+	return nil
+}
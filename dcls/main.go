@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/youngfr/dcls/internal/cli"
+)
+
+// 管理 dcls 的命令行工具
+//
+//	dcls <command> [arguments]
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "certs", usage: "manage the certificate authority and issued certificates", run: runCerts},
+	{name: "signing-key", usage: "generate a key for signing records and trust its public key", run: runSigningKey},
+	{name: "audit", usage: "verify and search an audit log directory", run: runAudit},
+	{name: "append", usage: "append a record from an argument, a file or stdin", run: recordCommand(cli.Append)},
+	{name: "read", usage: "read the record at an offset", run: recordCommand(cli.Read)},
+	{name: "read-range", usage: "read the records between two offsets", run: recordCommand(cli.ReadRange)},
+	{name: "tail", usage: "print the last records, -f to follow new ones", run: recordCommand(cli.Tail)},
+	{name: "offsets", usage: "print the lowest and highest offsets", run: recordCommand(cli.Offsets)},
+	{name: "reset", usage: "archive all logs after confirmation", run: recordCommand(cli.Reset)},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			err := c.run(os.Args[2:])
+			if errors.Is(err, flag.ErrHelp) {
+				// 用法已经输出了
+				os.Exit(2)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "dcls %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "dcls: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: dcls <command> [arguments]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
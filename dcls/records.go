@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/youngfr/dcls/internal/cli"
+)
+
+// 读写日志记录的命令在 internal/cli 中实现
+// 收到中断信号时取消命令，tail -f 因此可以正常退出
+func recordCommand(run func(ctx context.Context, stdio cli.IO, args []string) error) func(args []string) error {
+	return func(args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		return run(ctx, cli.Stdio, args)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/sign"
+)
+
+// 生成生产者签名记录使用的 Ed25519 密钥
+// 私钥写入配置目录中的 <name>-signing-key.pem，公钥追加到 trusted-keys.pem
+func runSigningKey(args []string) error {
+	fs := flag.NewFlagSet("signing-key", flag.ExitOnError)
+	name := fs.String("name", "", "the key id, the private key is written to <name>-signing-key.pem")
+	fs.Parse(args)
+
+	if *name == "" || strings.ContainsAny(*name, ": \t\r\n/") {
+		return errors.New("-name is required and must not contain colons, spaces or slashes")
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privPEM, pubPEM, err := sign.EncodeKeyPair(*name, key)
+	if err != nil {
+		return err
+	}
+
+	dir := auth.ConfigDir()
+	keyFile := filepath.Join(dir, *name+"-signing-key.pem")
+	f, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(privPEM); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	trusted, err := os.OpenFile(auth.TrustedKeysFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := trusted.Write(pubPEM); err != nil {
+		trusted.Close()
+		return err
+	}
+	if err := trusted.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and added key %q to %s\n", keyFile, *name, auth.TrustedKeysFile)
+	return nil
+}
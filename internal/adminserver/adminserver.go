@@ -0,0 +1,111 @@
+package adminserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 管理用的 HTTP 服务器
+//
+// 和 gRPC 服务器使用不同的端口
+// 设置了 TLS 和 Authorize 时和 gRPC 服务器一样认证用户并进行访问控制
+// 否则不经过任何检查，这时应该只监听本机地址
+
+// 访问管理接口需要的权限，对象和操作的名字和访问控制策略中的一致
+// /metrics 需要对 metrics 的 read 权限，其他接口需要对 debug 的 admin 权限
+const (
+	metricsObject = "metrics"
+	debugObject   = "debug"
+	readAction    = "read"
+	adminAction   = "admin"
+)
+
+// Metrics 为空时不提供 /metrics
+// TLS 为空时使用 HTTP，需要客户端证书时在 TLS 中设置 ClientAuth
+// Authorize 为空时不检查权限，它返回的 gRPC 状态码决定响应的 HTTP 状态码
+type Config struct {
+	Addr      string
+	Metrics   http.Handler
+	TLS       *tls.Config
+	Authorize func(r *http.Request, object, action string) error
+}
+
+type Server struct {
+	lis       net.Listener
+	mux       *http.ServeMux
+	server    *http.Server
+	authorize func(r *http.Request, object, action string) error
+}
+
+func New(c Config) (*Server, error) {
+	lis, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.TLS != nil {
+		lis = tls.NewListener(lis, c.TLS)
+	}
+	mux := http.NewServeMux()
+	s := &Server{
+		lis:       lis,
+		mux:       mux,
+		server:    &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		authorize: c.Authorize,
+	}
+	if c.Metrics != nil {
+		mux.Handle("/metrics", s.protect(metricsObject, readAction, c.Metrics))
+	}
+	return s, nil
+}
+
+// 添加其他的管理接口，需要在 Serve 之前调用
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, s.protect(debugObject, adminAction, h))
+}
+
+func (s *Server) protect(object, action string, h http.Handler) http.Handler {
+	if s.authorize == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorize(r, object, action); err != nil {
+			http.Error(w, status.Convert(err).Message(), httpStatus(err))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// 实际监听的地址，Config.Addr 的端口为零时由系统分配
+func (s *Server) Addr() string {
+	return s.lis.Addr().String()
+}
+
+// 阻塞直到服务器关闭，关闭后返回 nil
+func (s *Server) Serve() error {
+	if err := s.server.Serve(s.lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,186 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"flag"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/youngfr/dcls/internal/discovery"
+	"github.com/youngfr/dcls/internal/log"
+)
+
+// 调试用的管理接口，都是只读的
+
+// 返回所有 segment 的状态
+type SegmentLister interface {
+	Segments() []log.SegmentInfo
+}
+
+// 在 log 包中的 *log.Log 实现了 SegmentLister 接口
+var _ SegmentLister = (*log.Log)(nil)
+
+// 返回集群的所有成员
+type MemberLister interface {
+	Members() []serf.Member
+}
+
+// 在 discovery 包中的 *discovery.Membership 实现了 MemberLister 接口
+var _ MemberLister = (*discovery.Membership)(nil)
+
+// 添加 net/http/pprof 的所有接口
+func (s *Server) HandlePprof() {
+	s.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	s.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	s.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	s.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	s.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// 以 JSON 格式返回所有命令行参数的实际值
+// redacted 中的参数（比如密钥）只返回是否设置了
+func ConfigHandler(fs *flag.FlagSet, redacted ...string) http.Handler {
+	hidden := make(map[string]bool, len(redacted))
+	for _, name := range redacted {
+		hidden[name] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := make(map[string]string)
+		fs.VisitAll(func(f *flag.Flag) {
+			value := f.Value.String()
+			if hidden[f.Name] && value != "" {
+				value = "<redacted>"
+			}
+			config[f.Name] = value
+		})
+		writeJSON(w, config)
+	})
+}
+
+func SegmentsHandler(l SegmentLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, l.Segments())
+	})
+}
+
+type member struct {
+	Name    string            `json:"name"`
+	Addr    string            `json:"addr"`
+	RPCAddr string            `json:"rpc_addr"`
+	Status  string            `json:"status"`
+	Tags    map[string]string `json:"tags"`
+}
+
+func members(m MemberLister) []member {
+	all := m.Members()
+	res := make([]member, 0, len(all))
+	for _, mem := range all {
+		res = append(res, member{
+			Name:    mem.Name,
+			Addr:    net.JoinHostPort(mem.Addr.String(), strconv.Itoa(int(mem.Port))),
+			RPCAddr: mem.Tags["rpc_addr"],
+			Status:  mem.Status.String(),
+			Tags:    mem.Tags,
+		})
+	}
+	return res
+}
+
+func MembersHandler(m MemberLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, members(m))
+	})
+}
+
+// 状态页面显示的内容
+// Members 为空（没有加入集群）时不显示成员表格
+type Status struct {
+	NodeName string
+	RPCAddr  string
+	Started  time.Time
+	Segments SegmentLister
+	Members  MemberLister
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>dcls {{.NodeName}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>dcls {{.NodeName}}</h1>
+<table>
+<tr><th>RPC address</th><td>{{.RPCAddr}}</td></tr>
+<tr><th>Started</th><td>{{.Started.Format "2006-01-02 15:04:05 MST"}} (up {{.Uptime}})</td></tr>
+<tr><th>Go</th><td>{{.GoVersion}}, {{.Goroutines}} goroutines</td></tr>
+</table>
+<h2>Segments</h2>
+<table>
+<tr><th>Base offset</th><th>Next offset</th><th>Store bytes</th><th>Index bytes</th><th>Active</th><th>Encrypted</th></tr>
+{{range .Segments}}<tr><td>{{.BaseOffset}}</td><td>{{.NextOffset}}</td><td>{{.StoreBytes}}</td><td>{{.IndexBytes}}</td><td>{{.Active}}</td><td>{{.Encrypted}}</td></tr>
+{{end}}</table>
+{{if .Clustered}}<h2>Members</h2>
+<table>
+<tr><th>Name</th><th>Address</th><th>RPC address</th><th>Status</th></tr>
+{{range .Members}}<tr><td>{{.Name}}</td><td>{{.Addr}}</td><td>{{.RPCAddr}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>
+{{end}}<p>
+<a href="/config">config</a> ·
+<a href="/segments">segments</a> ·
+{{if .Clustered}}<a href="/members">members</a> · {{end}}
+<a href="/metrics">metrics</a> ·
+<a href="/debug/pprof/">pprof</a>
+</p>
+</body>
+</html>
+`))
+
+// 只显示信息的 HTML 状态页面
+func StatusHandler(s Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		data := struct {
+			Status
+			Uptime     time.Duration
+			GoVersion  string
+			Goroutines int
+			Segments   []log.SegmentInfo
+			Clustered  bool
+			Members    []member
+		}{
+			Status:     s,
+			Uptime:     time.Since(s.Started).Round(time.Second),
+			GoVersion:  runtime.Version(),
+			Goroutines: runtime.NumGoroutine(),
+			Segments:   s.Segments.Segments(),
+			Clustered:  s.Members != nil,
+		}
+		if s.Members != nil {
+			data.Members = members(s.Members)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusTemplate.Execute(w, data)
+	})
+}
@@ -0,0 +1,213 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	api "github.com/youngfr/dcls/api/v1"
+	dclslog "github.com/youngfr/dcls/internal/log"
+)
+
+// 一次请求的审计记录
+// 无论请求被允许还是被拒绝都会记录
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	// 认证得到的用户身份，认证失败时为空
+	Subject string `json:"subject,omitempty"`
+
+	// 客户端的网络地址
+	Peer string `json:"peer,omitempty"`
+
+	// gRPC 方法的全名，比如 /log.v1.Log/Reset
+	Method string `json:"method"`
+
+	// 请求 ID，和请求日志中的以及返回给客户端的 x-request-id 相同
+	RequestID string `json:"request_id,omitempty"`
+
+	// 访问控制检查的操作和对象，没有进行访问控制检查时为空
+	Action string `json:"action,omitempty"`
+	Object string `json:"object,omitempty"`
+
+	// 请求读写或删除的日志下标范围
+	Offsets *OffsetRange `json:"offsets,omitempty"`
+
+	// gRPC 状态码，比如 OK 和 PermissionDenied
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+
+	// 前一条审计记录的哈希值，第一条记录为空
+	PrevHash string `json:"prev_hash,omitempty"`
+}
+
+type OffsetRange struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+// 审计记录的哈希值是记录值的 SHA-256
+// 记录值中包含前一条记录的哈希值，所以修改或删除任意一条记录
+// 都会使之后那条记录中保存的 prev_hash 对不上
+func hash(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+var ErrTampered = errors.New("audit log has been tampered with")
+
+// 审计日志的 segment 大小
+// 默认的 segment 只有几 KB，每十几个请求就要新建一个 segment
+const (
+	maxStoreBytes = 64 << 20
+	maxIndexBytes = 1 << 20
+)
+
+// 客户端可以控制的字段（比如主题名和错误信息）超过这个长度时被截断，
+// 只保留开头和整个字段的哈希值，这样每条审计记录都能写入 segment
+const maxFieldBytes = 1024
+
+// 截断后的字段形如 <开头>...(sha256:<哈希值>, <长度> bytes)
+func truncate(field string) string {
+	if len(field) <= maxFieldBytes {
+		return field
+	}
+	prefix := field[:maxFieldBytes]
+	// 不要把一个 UTF-8 字符截成两半
+	for len(prefix) > 0 && !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return fmt.Sprintf("%s...(sha256:%s, %d bytes)", prefix, hash([]byte(field)), len(field))
+}
+
+func (e *Entry) truncate() {
+	for _, field := range []*string{&e.Subject, &e.Peer, &e.Method, &e.RequestID, &e.Action, &e.Object, &e.Error} {
+		*field = truncate(*field)
+	}
+}
+
+// 审计日志
+// 审计记录以 JSON 格式保存在一个独立的 dcls 日志中，不受 Reset 的影响
+type Log struct {
+	mu   sync.Mutex
+	log  *dclslog.Log
+	head string
+}
+
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var c dclslog.Config
+	c.Segment.MaxStoreBytes = maxStoreBytes
+	c.Segment.MaxIndexBytes = maxIndexBytes
+	log, err := dclslog.NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{log: log}
+
+	// 重启后从最后一条记录继续哈希链
+	if next, err := log.NextOffset(); err == nil && next > 0 {
+		if record, err := log.Read(next - 1); err == nil {
+			l.head = hash(record.Value)
+		}
+	}
+	return l, nil
+}
+
+// 追加一条审计记录，调用者不需要设置 PrevHash
+// 过长的字段会被截断，参见 maxFieldBytes
+func (l *Log) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.truncate()
+	e.PrevHash = l.head
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.log.Append(&api.Record{Value: value}); err != nil {
+		return err
+	}
+	l.head = hash(value)
+	return nil
+}
+
+// 验证结果
+// Head 是最后一条记录的哈希值，把它保存在审计日志之外
+// 可以发现对日志末尾的截断或者重写整个哈希链
+type VerifyResult struct {
+	Entries uint64
+	Head    string
+}
+
+// 验证整个哈希链，返回的错误包含第一条被篡改的记录的下标
+func (l *Log) Verify() (VerifyResult, error) {
+	return l.Walk(nil)
+}
+
+// 从前往后遍历并验证所有审计记录
+// 遇到被篡改的记录时停止遍历，fn 返回错误时也停止遍历
+func (l *Log) Walk(fn func(offset uint64, e Entry) error) (VerifyResult, error) {
+	var result VerifyResult
+	for offset := uint64(0); ; offset++ {
+		record, err := l.read(offset)
+		if err != nil {
+			return result, err
+		}
+		if record == nil {
+			return result, nil
+		}
+		var e Entry
+		if err := json.Unmarshal(record.Value, &e); err != nil {
+			return result, fmt.Errorf("%w: offset %d: %v", ErrTampered, offset, err)
+		}
+		// 第一条记录的 prev_hash 为空，result.Head 的初始值也为空
+		if e.PrevHash != result.Head {
+			return result, fmt.Errorf("%w: offset %d: prev_hash does not match offset %d", ErrTampered, offset, offset-1)
+		}
+		if fn != nil {
+			if err := fn(offset, e); err != nil {
+				return result, err
+			}
+		}
+		result.Entries++
+		result.Head = hash(record.Value)
+	}
+}
+
+// 审计日志从不删除记录，所以下标总是从零开始连续的
+// 超出最后一条记录时返回空记录
+func (l *Log) read(offset uint64) (*api.Record, error) {
+	next, err := l.log.NextOffset()
+	if err != nil {
+		return nil, err
+	}
+	lowest, err := l.log.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	if lowest > 0 {
+		return nil, fmt.Errorf("%w: records before offset %d are missing", ErrTampered, lowest)
+	}
+	if offset >= next {
+		return nil, nil
+	}
+	record, err := l.log.Read(offset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: offset %d: %v", ErrTampered, offset, err)
+	}
+	return record, nil
+}
+
+func (l *Log) Close() error {
+	return l.log.Close()
+}
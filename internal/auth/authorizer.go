@@ -2,13 +2,22 @@ package auth
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/casbin/casbin"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type Authorizer struct {
+	model  string
+	policy string
+	logger *zap.Logger
+
+	// casbin 的 Enforcer 不是并发安全的
+	// 重新加载策略时需要和正在进行的授权互斥
+	mu       sync.RWMutex
 	enforcer *casbin.Enforcer
 }
 
@@ -17,15 +26,72 @@ type Authorizer struct {
 func NewAuthorizer(model, policy string) *Authorizer {
 	enforcer := casbin.NewEnforcer(model, policy)
 	return &Authorizer{
+		model:    model,
+		policy:   policy,
+		logger:   zap.L().Named("auth"),
 		enforcer: enforcer,
 	}
 }
 
 // 如果 subject 可以对 object 执行 action 操作则返回空
 func (a *Authorizer) Authorize(subject, object, action string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	if !a.enforcer.Enforce(subject, object, action) {
 		msg := fmt.Sprintf("%s is not permitted to %s to %s", subject, action, object)
 		return status.New(codes.PermissionDenied, msg).Err()
 	}
 	return nil
 }
+
+// 如果 subject 可以对至少一个对象执行 action 操作则返回空
+// 用于还不知道具体对象时的粗粒度检查，比如读取记录之前还不知道记录属于哪个主题
+func (a *Authorizer) AuthorizeAny(subject, action string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, p := range a.enforcer.GetImplicitPermissionsForUser(subject) {
+		if len(p) == 3 && p[2] == action {
+			return nil
+		}
+	}
+	msg := fmt.Sprintf("%s is not permitted to %s to any object", subject, action)
+	return status.New(codes.PermissionDenied, msg).Err()
+}
+
+// 返回用户直接拥有和继承的所有角色，直接拥有的角色在前
+func (a *Authorizer) RolesForSubject(subject string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.enforcer.GetImplicitRolesForUser(subject)
+}
+
+// 重新从策略文件中加载所有策略
+// 修改策略文件后不需要重启服务器
+//
+// Enforcer 的 LoadPolicy 会先清空已有的策略，文件有错误时所有请求都会被拒绝
+// 所以先用新的文件创建一个 Enforcer，加载成功之后再替换原来的
+// 创建 Enforcer 时加载策略的错误会被忽略，需要再调用一次 LoadPolicy
+func (a *Authorizer) Reload() error {
+	enforcer, err := casbin.NewEnforcerSafe(a.model, a.policy)
+	if err != nil {
+		return fmt.Errorf("failed to load model %q: %w", a.model, err)
+	}
+	if err := enforcer.LoadPolicy(); err != nil {
+		return fmt.Errorf("failed to load policy %q: %w", a.policy, err)
+	}
+	a.mu.Lock()
+	a.enforcer = enforcer
+	a.mu.Unlock()
+	a.logger.Info(
+		"reloaded policy",
+		zap.String("policy", a.policy),
+		zap.Int("rules", len(enforcer.GetPolicy())),
+		zap.Int("roles", len(enforcer.GetGroupingPolicy())),
+	)
+	return nil
+}
+
+// 访问控制模型和策略文件发生变化时都需要重新加载
+func (a *Authorizer) Files() []string {
+	return []string{a.model, a.policy}
+}
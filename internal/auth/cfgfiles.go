@@ -27,18 +27,33 @@ var (
 	ReadOnlyClientCertFile = configFile("readonly-client.pem")
 	ReadOnlyClientKeyFile  = configFile("readonly-client-key.pem")
 
+	// 证书吊销列表，文件不存在时不检查证书是否被吊销
+	CRLFile = configFile("crl.pem")
+
 	// 授权时使用的配置和策略文件
 	ACLModelFile  = configFile("model.conf")
 	ACLPolicyFile = configFile("policy.csv")
+
+	// 信任的记录签名公钥，参见 sign 包
+	TrustedKeysFile = configFile("trusted-keys.pem")
+
+	// 每个用户的请求限额，文件不存在时不限制
+	QuotaFile = configFile("quota.json")
 )
 
 func configFile(filename string) string {
+	return filepath.Join(ConfigDir(), filename)
+}
+
+// 存放证书、私钥和访问控制策略的目录
+// 默认是 ~/.dcls，可以通过环境变量 CONFIG_DIR 修改
+func ConfigDir() string {
 	if dir := os.Getenv("CONFIG_DIR"); dir != "" {
-		return filepath.Join(dir, filename)
+		return dir
 	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
 	}
-	return filepath.Join(homeDir, ".dcls", filename)
+	return filepath.Join(homeDir, ".dcls")
 }
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 证书吊销列表（CRL）
+// 丢失的客户端证书在过期之前仍然可以通过根证书的验证，把它加入 CRL 之后就会被拒绝
+//
+// CRL 文件可以是 PEM 或 DER 编码，PEM 编码的文件中可以有多个 CRL（比如每个根证书一个）
+// 每个 CRL 都必须由 caFile 中的某个根证书签名
+type RevocationList struct {
+	crlFile string
+	caFile  string
+	logger  *zap.Logger
+
+	mu sync.RWMutex
+	// 签发者和序列号到吊销时间的映射
+	revoked map[revokedCert]time.Time
+	// 是否已经从文件中加载过 CRL
+	loaded bool
+}
+
+type revokedCert struct {
+	issuer string
+	serial string
+}
+
+var (
+	errNoCRL        = errors.New("no certificate revocation list found")
+	errCRLNotSigned = errors.New("certificate revocation list not signed by a trusted root certificate")
+	errRevokedCert  = errors.New("certificate has been revoked")
+)
+
+// CRL 文件不存在时不拒绝任何证书，文件出现后由 Watcher 自动加载
+// 这样第一次吊销证书时不需要重启服务器
+func NewRevocationList(crlFile, caFile string) (*RevocationList, error) {
+	l := &RevocationList{
+		crlFile: crlFile,
+		caFile:  caFile,
+		logger:  zap.L().Named("auth"),
+	}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// 重新读取 CRL 文件，签名无效时继续使用原来的 CRL
+// 加载过的 CRL 文件被删除时也继续使用原来的 CRL，不会因此接受已经被吊销的证书
+func (l *RevocationList) Reload() error {
+	b, err := os.ReadFile(l.crlFile)
+	if os.IsNotExist(err) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.loaded {
+			return err
+		}
+		l.revoked = make(map[revokedCert]time.Time)
+		l.logger.Info("no certificate revocation list yet", zap.String("file", l.crlFile))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cas, err := loadCACertificates(l.caFile)
+	if err != nil {
+		return err
+	}
+
+	ders := make([][]byte, 0, 1)
+	for rest := b; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 && len(b) > 0 && b[0] != '-' {
+		// 不是 PEM 编码时当作 DER 编码
+		ders = append(ders, b)
+	}
+	if len(ders) == 0 {
+		return fmt.Errorf("%w: %q", errNoCRL, l.crlFile)
+	}
+
+	revoked := make(map[revokedCert]time.Time)
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate revocation list %q: %w", l.crlFile, err)
+		}
+		if !signedByAny(crl, cas) {
+			return fmt.Errorf("%w: %q", errCRLNotSigned, l.crlFile)
+		}
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			l.logger.Warn(
+				"certificate revocation list is out of date",
+				zap.String("issuer", crl.Issuer.String()),
+				zap.Time("next_update", crl.NextUpdate),
+			)
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			key := revokedCert{issuer: string(crl.RawIssuer), serial: entry.SerialNumber.String()}
+			revoked[key] = entry.RevocationTime
+		}
+	}
+
+	l.mu.Lock()
+	l.revoked, l.loaded = revoked, true
+	l.mu.Unlock()
+	l.logger.Info("reloaded certificate revocation list", zap.String("file", l.crlFile), zap.Int("revoked", len(revoked)))
+	return nil
+}
+
+// CRL 和用来验证它的根证书发生变化时都需要重新加载
+func (l *RevocationList) Files() []string {
+	return []string{l.crlFile, l.caFile}
+}
+
+var _ Reloadable = (*RevocationList)(nil)
+
+// 作为 tls.Config 的 VerifyPeerCertificate 使用
+// 检查对端提供的证书链中的每个证书是否已经被吊销
+//
+// 客户端使用 CertReloader 时会关闭默认的验证，这时 verifiedChains 为空
+// 所以这里只依赖 rawCerts
+// 允许客户端不提供证书时 rawCerts 可能为空，这时由认证来拒绝请求
+func (l *RevocationList) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		key := revokedCert{issuer: string(cert.RawIssuer), serial: cert.SerialNumber.String()}
+		if revokedAt, ok := l.revoked[key]; ok {
+			l.logger.Warn(
+				"rejected revoked certificate",
+				zap.String("subject", cert.Subject.String()),
+				zap.String("serial", cert.SerialNumber.Text(16)),
+				zap.Time("revoked_at", revokedAt),
+			)
+			return fmt.Errorf("%w: serial %s", errRevokedCert, cert.SerialNumber.Text(16))
+		}
+	}
+	return nil
+}
+
+func signedByAny(crl *x509.RevocationList, cas []*x509.Certificate) bool {
+	for _, ca := range cas {
+		if crl.CheckSignatureFrom(ca) == nil {
+			return true
+		}
+	}
+	return false
+}
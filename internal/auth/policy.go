@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/casbin/casbin"
+)
+
+// 主体 Subject 可以对客体 Object 执行 Action 操作
+type Policy struct {
+	Subject string `json:"subject"`
+	Object  string `json:"object"`
+	Action  string `json:"action"`
+}
+
+// 主体 Subject 拥有角色 Role 的所有权限
+type RoleAssignment struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+}
+
+var (
+	ErrInvalidPolicy    = errors.New("invalid policy")
+	errIncompletePolicy = fmt.Errorf("%w: subject, object and action must not be empty", ErrInvalidPolicy)
+	errIncompleteRole   = fmt.Errorf("%w: subject and role must not be empty", ErrInvalidPolicy)
+	errInvalidField     = fmt.Errorf("%w: fields must not contain commas or line breaks", ErrInvalidPolicy)
+)
+
+func (p Policy) validate() error {
+	if p.Subject == "" || p.Object == "" || p.Action == "" {
+		return errIncompletePolicy
+	}
+	return validateFields(p.Subject, p.Object, p.Action)
+}
+
+func (r RoleAssignment) validate() error {
+	if r.Subject == "" || r.Role == "" {
+		return errIncompleteRole
+	}
+	return validateFields(r.Subject, r.Role)
+}
+
+// 策略文件使用逗号分隔字段，每行一条策略
+func validateFields(fields ...string) error {
+	for _, f := range fields {
+		if strings.ContainsAny(f, ",\r\n") {
+			return errInvalidField
+		}
+	}
+	return nil
+}
+
+// 返回所有策略和角色分配
+func (a *Authorizer) Policies() ([]Policy, []RoleAssignment) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	policies := make([]Policy, 0)
+	for _, rule := range a.enforcer.GetPolicy() {
+		policies = append(policies, Policy{Subject: rule[0], Object: rule[1], Action: rule[2]})
+	}
+	roles := make([]RoleAssignment, 0)
+	for _, rule := range a.enforcer.GetGroupingPolicy() {
+		roles = append(roles, RoleAssignment{Subject: rule[0], Role: rule[1]})
+	}
+	return policies, roles
+}
+
+// 以下方法修改策略并写回策略文件
+// 策略已经存在（添加时）或者不存在（删除时）时返回假，这时不会写文件
+
+func (a *Authorizer) AddPolicy(p Policy) (bool, error) {
+	if err := p.validate(); err != nil {
+		return false, err
+	}
+	return a.update(
+		func(e *casbin.Enforcer) bool { return e.AddPolicy(p.Subject, p.Object, p.Action) },
+		func(e *casbin.Enforcer) { e.RemovePolicy(p.Subject, p.Object, p.Action) },
+	)
+}
+
+func (a *Authorizer) RemovePolicy(p Policy) (bool, error) {
+	if err := p.validate(); err != nil {
+		return false, err
+	}
+	return a.update(
+		func(e *casbin.Enforcer) bool { return e.RemovePolicy(p.Subject, p.Object, p.Action) },
+		func(e *casbin.Enforcer) { e.AddPolicy(p.Subject, p.Object, p.Action) },
+	)
+}
+
+func (a *Authorizer) AddRoleForSubject(r RoleAssignment) (bool, error) {
+	if err := r.validate(); err != nil {
+		return false, err
+	}
+	return a.update(
+		func(e *casbin.Enforcer) bool { return e.AddGroupingPolicy(r.Subject, r.Role) },
+		func(e *casbin.Enforcer) { e.RemoveGroupingPolicy(r.Subject, r.Role) },
+	)
+}
+
+// 写文件失败时调用 undo 撤销内存中的修改，保证内存中的策略和文件一致
+func (a *Authorizer) update(do func(*casbin.Enforcer) bool, undo func(*casbin.Enforcer)) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !do(a.enforcer) {
+		return false, nil
+	}
+	if err := a.save(); err != nil {
+		undo(a.enforcer)
+		return false, err
+	}
+	return true, nil
+}
+
+// 先写入同一目录下的临时文件再重命名
+// 这样其他进程（或者 Watcher）读到的策略文件总是完整的
+//
+// casbin 的文件适配器直接覆盖原来的文件并丢掉注释，所以这里自己写文件
+// 原来文件中的注释、空行和仍然有效的规则保持原样，被删除的规则所在的行被去掉，
+// 新的规则追加在同类规则（p 或 g）的最后一行之后
+func (a *Authorizer) save() error {
+	old, err := os.ReadFile(a.policy)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b := rewritePolicy(string(old), a.enforcer.GetPolicy(), a.enforcer.GetGroupingPolicy())
+	f, err := os.CreateTemp(filepath.Dir(a.policy), ".policy-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), a.policy)
+}
+
+func rewritePolicy(old string, policies, groupings [][]string) string {
+	wanted := make(map[string]bool)
+	for _, rule := range policies {
+		wanted[policyLine("p", rule)] = true
+	}
+	for _, rule := range groupings {
+		wanted[policyLine("g", rule)] = true
+	}
+
+	// 保留的行，以及 p 和 g 规则最后一次出现的位置
+	lines := make([]string, 0)
+	last := map[string]int{"p": -1, "g": -1}
+	for _, line := range strings.Split(strings.TrimRight(old, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			lines = append(lines, line)
+			continue
+		}
+		fields := strings.Split(trimmed, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		key := policyLine(fields[0], fields[1:])
+		if !wanted[key] {
+			continue
+		}
+		delete(wanted, key)
+		lines = append(lines, line)
+		last[fields[0]] = len(lines) - 1
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		lines = lines[:0]
+	}
+
+	// 按照 casbin 中的顺序追加新的规则
+	insert := func(ptype string, rules [][]string) {
+		added := make([]string, 0)
+		for _, rule := range rules {
+			if line := policyLine(ptype, rule); wanted[line] {
+				added = append(added, line)
+			}
+		}
+		at := last[ptype] + 1
+		if last[ptype] < 0 {
+			at = len(lines)
+		}
+		lines = append(lines[:at], append(added, lines[at:]...)...)
+		if ptype == "p" && last["g"] >= at {
+			last["g"] += len(added)
+		}
+	}
+	insert("p", policies)
+	insert("g", groupings)
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func policyLine(ptype string, rule []string) string {
+	return ptype + ", " + strings.Join(rule, ", ")
+}
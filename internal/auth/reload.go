@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 可以在不重启程序的情况下重新加载的证书、私钥和根证书
+//
+// 证书会定期轮换，而 SetupTLSConfig 只在启动时读取一次文件
+// 通过 Config 得到的 TLS 配置在每次握手时都使用最近一次加载的文件
+type CertReloader struct {
+	cfg    TLSConfig
+	base   *tls.Config
+	logger *zap.Logger
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	ca   *x509.CertPool
+}
+
+var errNoPeerCertificate = errors.New("no certificate provided by peer")
+
+// 参数 cfg 的要求和 SetupTLSConfig 相同
+func NewCertReloader(cfg TLSConfig) (*CertReloader, error) {
+	base, err := SetupTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &CertReloader{
+		cfg:    cfg,
+		base:   base,
+		logger: zap.L().Named("auth"),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 返回使用最新证书的 TLS 配置
+//
+// 服务端通过 GetCertificate 获取自己的证书
+// 通过 GetConfigForClient 为每个连接使用最新的根证书验证客户端证书
+//
+// 客户端通过 GetClientCertificate 获取自己的证书
+// tls.Config 的 RootCAs 在握手时不能替换，所以关闭默认的验证
+// 改为在 VerifyConnection 中使用最新的根证书验证服务端证书
+func (r *CertReloader) Config() *tls.Config {
+	c := r.base.Clone()
+	c.Certificates = nil
+
+	if r.cfg.IsServerConfig {
+		c.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		}
+		if r.cfg.EnableMutualTLS {
+			c.ClientCAs = nil
+			c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				cc := c.Clone()
+				cc.GetConfigForClient = nil
+				cc.ClientCAs = r.pool()
+				return cc, nil
+			}
+		}
+		return c
+	}
+
+	if r.cfg.EnableMutualTLS {
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		}
+	}
+	c.RootCAs = nil
+	c.InsecureSkipVerify = true
+	c.VerifyConnection = r.verifyServer
+	return c
+}
+
+// 重新读取证书、私钥和根证书
+// 任何一个文件有错误时都不会替换正在使用的证书
+func (r *CertReloader) Reload() error {
+	var cert *tls.Certificate
+	if r.cfg.IsServerConfig || r.cfg.EnableMutualTLS {
+		pair, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	var cas []*x509.Certificate
+	if !r.cfg.IsServerConfig || r.cfg.EnableMutualTLS {
+		var err error
+		if cas, err = loadCACertificates(r.cfg.CAFile); err != nil {
+			return err
+		}
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range cas {
+		pool.AddCert(ca)
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.ca = pool
+	r.mu.Unlock()
+
+	fields := make([]zap.Field, 0, 3)
+	if cert != nil {
+		fields = append(fields,
+			zap.String("serial", cert.Leaf.SerialNumber.Text(16)),
+			zap.Time("not_after", cert.Leaf.NotAfter),
+		)
+	}
+	if len(cas) > 0 {
+		serials := make([]string, 0, len(cas))
+		for _, ca := range cas {
+			serials = append(serials, ca.SerialNumber.Text(16))
+		}
+		fields = append(fields, zap.Strings("ca_serials", serials))
+	}
+	r.logger.Info("reloaded certificates", fields...)
+	return nil
+}
+
+// 证书、私钥和根证书中任何一个发生变化时都需要重新加载
+func (r *CertReloader) Files() []string {
+	files := make([]string, 0, 3)
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (r *CertReloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+func (r *CertReloader) pool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ca
+}
+
+// 和 crypto/tls 默认的验证相同，只是使用最新的根证书
+func (r *CertReloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errNoPeerCertificate
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.pool(),
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// 和 loadCA 相同，但是返回解析后的证书以便记录它们的序列号
+func loadCACertificates(CAFile string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(CAFile)
+	if err != nil {
+		return nil, err
+	}
+	cas := make([]*x509.Certificate, 0, 1)
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse root certificate: %q: %w", CAFile, err)
+		}
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("failed to parse root certificate: %q", CAFile)
+	}
+	return cas, nil
+}
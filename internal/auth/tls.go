@@ -16,10 +16,17 @@ type TLSConfig struct {
 	// 是否启用双向 TLS 认证
 	EnableMutualTLS bool
 
+	// 启用了双向 TLS 认证的服务端是否允许客户端不提供证书
+	// 客户端提供的证书仍然会被验证，没有证书的客户端需要使用其他方式（比如令牌）认证
+	ClientCertOptional bool
+
 	CertFile   string
 	KeyFile    string
 	CAFile     string
 	ServerName string
+
+	// 不为空时拒绝对端提供的已经被吊销的证书
+	CRL *RevocationList
 }
 
 var (
@@ -45,6 +52,9 @@ func SetupTLSConfig(cfg TLSConfig) (tlsConfig *tls.Config, err error) {
 	// 客户端需要设置: 客户端证书, 客户端私钥, 根证书
 
 	tlsConfig = &tls.Config{}
+	if cfg.CRL != nil {
+		tlsConfig.VerifyPeerCertificate = cfg.CRL.VerifyPeerCertificate
+	}
 
 	if cfg.IsServerConfig {
 		// 服务端 TLS 配置
@@ -77,6 +87,9 @@ func SetupTLSConfig(cfg TLSConfig) (tlsConfig *tls.Config, err error) {
 			} else {
 				tlsConfig.ClientCAs = ca
 				tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+				if cfg.ClientCertOptional {
+					tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+				}
 			}
 		}
 
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 令牌使用的签名算法
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+// 允许服务器和签发令牌的机器之间存在的时钟误差
+const tokenLeeway = 30 * time.Second
+
+// HMAC 密钥至少需要和哈希值一样长
+const minHMACKeySize = sha256.Size
+
+// 令牌（JWT）中使用到的声明
+type TokenClaims struct {
+	// 用户身份，和证书的 CN 一样作为访问控制的主体
+	Subject string `json:"sub"`
+
+	// 令牌的接收方，必须包含服务器配置的 audience
+	Audience Audience `json:"aud"`
+
+	// 过期时间、生效时间和签发时间，都是 Unix 时间戳（秒）
+	// 过期时间是必须的
+	ExpiresAt int64 `json:"exp"`
+	NotBefore int64 `json:"nbf,omitempty"`
+	IssuedAt  int64 `json:"iat,omitempty"`
+}
+
+// JWT 的 aud 声明可以是一个字符串也可以是一个字符串数组
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+var (
+	errNoAudience        = errors.New("no audience provided for token authentication")
+	errMalformedToken    = errors.New("malformed token")
+	errUnexpectedAlg     = errors.New("unexpected token signing algorithm")
+	errBadSignature      = errors.New("invalid token signature")
+	errTokenExpired      = errors.New("token expired")
+	errTokenNotYetValid  = errors.New("token not valid yet")
+	errNoExpiry          = errors.New("token has no expiry")
+	errWrongAudience     = errors.New("token not issued for this server")
+	errNoTokenSubject    = errors.New("token has no subject")
+	errUnsupportedKey    = errors.New("unsupported token key type")
+	errHMACKeyTooShort   = fmt.Errorf("HMAC key must be at least %d bytes", minHMACKeySize)
+	errUnsupportedSigner = errors.New("token signing key must be []byte or ed25519.PrivateKey")
+)
+
+// 使用 Authorization: Bearer <token> 元数据中的签名令牌认证用户
+// 适用于无法提供客户端证书的客户端，比如位于终止 TLS 的代理之后的客户端
+//
+// 密钥文件决定了签名算法：
+// PEM 编码的 Ed25519 公钥使用 EdDSA，其他内容作为 HMAC 密钥使用 HS256
+// 令牌头部声明的算法必须和密钥的算法一致，防止攻击者把公钥当作 HMAC 密钥伪造令牌
+type TokenAuthenticator struct {
+	keyFile  string
+	audience string
+	logger   *zap.Logger
+
+	mu  sync.RWMutex
+	alg string
+	key any
+}
+
+func NewTokenAuthenticator(keyFile, audience string) (*TokenAuthenticator, error) {
+	if audience == "" {
+		return nil, errNoAudience
+	}
+	a := &TokenAuthenticator{
+		keyFile:  keyFile,
+		audience: audience,
+		logger:   zap.L().Named("auth"),
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// 验证令牌并返回其中的用户身份
+func (a *TokenAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+	if err != nil {
+		return "", err
+	}
+	a.mu.RLock()
+	alg, key := a.alg, a.key
+	a.mu.RUnlock()
+
+	claims, err := verifyToken(token, alg, key, a.audience, time.Now())
+	if err != nil {
+		return "", status.New(codes.Unauthenticated, err.Error()).Err()
+	}
+	return claims.Subject, nil
+}
+
+// 请求带有 authorization metadata，不管是不是有效的 bearer 令牌
+func (a *TokenAuthenticator) HasCredentials(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get("authorization")) > 0
+}
+
+// 重新读取密钥文件，密钥轮换时不需要重启服务器
+func (a *TokenAuthenticator) Reload() error {
+	alg, key, err := loadTokenKey(a.keyFile)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.alg, a.key = alg, key
+	a.mu.Unlock()
+	a.logger.Info("reloaded token key", zap.String("file", a.keyFile), zap.String("alg", alg))
+	return nil
+}
+
+func (a *TokenAuthenticator) Files() []string {
+	return []string{a.keyFile}
+}
+
+var _ Reloadable = (*TokenAuthenticator)(nil)
+
+func loadTokenKey(keyFile string) (string, any, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", nil, err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return "", nil, fmt.Errorf("%w: %s", errUnsupportedKey, block.Type)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return "", nil, err
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %T", errUnsupportedKey, pub)
+		}
+		return EdDSA, key, nil
+	}
+	secret := []byte(strings.TrimSpace(string(b)))
+	if len(secret) < minHMACKeySize {
+		return "", nil, errHMACKeyTooShort
+	}
+	return HS256, secret, nil
+}
+
+func verifyToken(token, alg string, key any, audience string, now time.Time) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Algorithm != alg {
+		return nil, fmt.Errorf("%w: %q", errUnexpectedAlg, header.Algorithm)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errBadSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, signed, sig) {
+			return nil, errBadSignature
+		}
+	default:
+		return nil, errUnsupportedKey
+	}
+
+	var claims TokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	switch {
+	case claims.ExpiresAt == 0:
+		return nil, errNoExpiry
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(tokenLeeway)):
+		return nil, errTokenExpired
+	case claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-tokenLeeway)):
+		return nil, errTokenNotYetValid
+	case !slices.Contains(claims.Audience, audience):
+		return nil, errWrongAudience
+	case claims.Subject == "":
+		return nil, errNoTokenSubject
+	}
+	return &claims, nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errMalformedToken
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errMalformedToken
+	}
+	return nil
+}
+
+// 签发令牌
+// 参数 key 为 []byte 时使用 HS256，为 ed25519.PrivateKey 时使用 EdDSA
+func SignToken(key any, claims TokenClaims) (string, error) {
+	var alg string
+	switch key.(type) {
+	case []byte:
+		alg = HS256
+	case ed25519.PrivateKey:
+		alg = EdDSA
+	default:
+		return "", errUnsupportedSigner
+	}
+	header, err := json.Marshal(tokenHeader{Algorithm: alg, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// 客户端在每个请求的元数据中携带令牌
+// 令牌只能通过加密的连接发送
+type tokenCredentials string
+
+func TokenCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials(token)
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
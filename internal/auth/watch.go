@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 可以在运行时重新加载的配置
+// *CertReloader 和 *Authorizer 都实现了这个接口
+type Reloadable interface {
+	Reload() error
+
+	// 配置依赖的文件，任何一个文件发生变化时都需要重新加载
+	Files() []string
+}
+
+var (
+	_ Reloadable = (*CertReloader)(nil)
+	_ Reloadable = (*Authorizer)(nil)
+)
+
+// 定期检查文件的修改时间和大小，发生变化时重新加载对应的配置
+// 证书轮换时通常会整个替换文件，检查修改时间就足够了，不需要依赖 fsnotify
+type Watcher struct {
+	interval time.Duration
+	logger   *zap.Logger
+
+	// 定期检查和 ReloadAll 可能同时发生
+	mu      sync.Mutex
+	targets []*watched
+}
+
+type watched struct {
+	Reloadable
+
+	// 上一次成功加载时各个文件的状态
+	// 加载失败时不更新，这样下一次检查时会重试
+	states map[string]fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewWatcher(interval time.Duration, targets ...Reloadable) *Watcher {
+	w := &Watcher{
+		interval: interval,
+		logger:   zap.L().Named("auth"),
+	}
+	for _, r := range targets {
+		w.targets = append(w.targets, &watched{Reloadable: r, states: stat(r.Files())})
+	}
+	return w
+}
+
+// 一直检查文件直到 ctx 被取消
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// 不论文件是否变化都重新加载所有配置，比如收到 SIGHUP 信号时
+func (w *Watcher) ReloadAll() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	for _, t := range w.targets {
+		if err := w.reload(t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *Watcher) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, t := range w.targets {
+		if !changed(t.states, stat(t.Files())) {
+			continue
+		}
+		w.reload(t)
+	}
+}
+
+func (w *Watcher) reload(t *watched) error {
+	// 先记录文件的状态再加载，加载期间文件再次变化时下一次检查仍然会发现
+	states := stat(t.Files())
+	if err := t.Reload(); err != nil {
+		w.logger.Error("failed to reload", zap.Error(err), zap.Strings("files", t.Files()))
+		return err
+	}
+	t.states = states
+	return nil
+}
+
+// 暂时无法访问的文件（比如正在被替换）不记录状态
+func stat(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			states[f] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return states
+}
+
+func changed(old, cur map[string]fileState) bool {
+	for f, s := range cur {
+		if o, ok := old[f]; !ok || !o.modTime.Equal(s.modTime) || o.size != s.size {
+			return true
+		}
+	}
+	return false
+}
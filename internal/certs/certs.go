@@ -0,0 +1,454 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 配置目录中的文件名，和 auth 包读取的文件名一致
+const (
+	CAFile    = "ca.pem"
+	CAKeyFile = "ca-key.pem"
+	CRLFile   = "crl.pem"
+)
+
+const (
+	// 根证书的默认有效期
+	DefaultCAValidity = 5 * 365 * 24 * time.Hour
+
+	// 服务端和客户端证书的默认有效期
+	DefaultValidity = 365 * 24 * time.Hour
+
+	// 每次吊销证书时重新签发 CRL，CRL 在这段时间之后过期
+	crlValidity = 30 * 24 * time.Hour
+)
+
+// 所有证书主题中除了 CN 之外的字段
+var subjectNames = pkix.Name{
+	Country:            []string{"CN"},
+	Province:           []string{"Shanghai"},
+	Locality:           []string{"Shanghai"},
+	Organization:       []string{"YoungFr Company"},
+	OrganizationalUnit: []string{"Distributed Log Services"},
+}
+
+var (
+	ErrCAExists        = errors.New("certificate authority already exists")
+	errNoCertName      = errors.New("no file name provided for certificate")
+	errNoCommonName    = errors.New("no common name provided for certificate")
+	errReservedName    = errors.New("file name is reserved for the certificate authority")
+	errInvalidCertName = errors.New("certificate file name must not contain path separators")
+	errNotIssued       = errors.New("certificate not issued by this certificate authority")
+)
+
+// 签发证书的根证书及其私钥
+type Authority struct {
+	dir  string
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// 在 dir 中生成新的根证书和私钥
+// 已经存在根证书时返回 ErrCAExists，避免覆盖之后所有已经签发的证书失效
+func Init(dir, commonName string, validity time.Duration) (*Authority, error) {
+	if _, err := os.Stat(filepath.Join(dir, CAFile)); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCAExists, filepath.Join(dir, CAFile))
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	subject := subjectNames
+	subject.CommonName = commonName
+	subject.OrganizationalUnit = []string{"CA Services"}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	// 先写私钥，这样只有根证书而没有私钥的情况不会出现
+	if err := writeKey(filepath.Join(dir, CAKeyFile), key); err != nil {
+		return nil, err
+	}
+	if err := writeCert(filepath.Join(dir, CAFile), der); err != nil {
+		return nil, err
+	}
+	a := &Authority{dir: dir, cert: cert, key: key}
+	// 同时写入空的 CRL，服务器从一开始就会监视它，之后吊销证书不需要重启服务器
+	if err := a.writeCRL(nil, 1); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// 读取 dir 中的根证书和私钥
+func Load(dir string) (*Authority, error) {
+	cert, err := readCert(filepath.Join(dir, CAFile))
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", filepath.Join(dir, CAKeyFile))
+	}
+	key, err := parseKey(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{dir: dir, cert: cert, key: key}, nil
+}
+
+func (a *Authority) Certificate() *x509.Certificate {
+	return a.cert
+}
+
+// 签发证书的请求
+type Request struct {
+	// 证书和私钥的文件名分别是 Name.pem 和 Name-key.pem
+	Name string
+
+	// 客户端证书的 CN 就是用户的身份（或者角色），作为访问控制的主体
+	CommonName string
+
+	// 主题备用名称（SAN），根据格式自动识别为 IP 地址、URI（比如 SPIFFE ID）、电子邮件地址或者域名
+	Hosts []string
+
+	// 服务端证书同时可以用于客户端认证，这样节点之间可以互相认证
+	Server bool
+
+	// 为零时使用 DefaultValidity
+	Validity time.Duration
+}
+
+// 签发证书并写入 Name.pem 和 Name-key.pem
+func (a *Authority) Issue(req Request) (*x509.Certificate, error) {
+	if err := validateName(req.Name); err != nil {
+		return nil, err
+	}
+	if req.CommonName == "" {
+		return nil, errNoCommonName
+	}
+	if req.Validity == 0 {
+		req.Validity = DefaultValidity
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	subject := subjectNames
+	subject.CommonName = req.CommonName
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(req.Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if req.Server {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if err := addHosts(template, req.Hosts); err != nil {
+		return nil, err
+	}
+	// 证书不能比根证书活得更久
+	if template.NotAfter.After(a.cert.NotAfter) {
+		template.NotAfter = a.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, key.Public(), a.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKey(filepath.Join(a.dir, req.Name+"-key.pem"), key); err != nil {
+		return nil, err
+	}
+	if err := writeCert(filepath.Join(a.dir, req.Name+".pem"), der); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// 一个由本 CA 签发的证书
+type Issued struct {
+	// 证书的文件名去掉 .pem 后缀
+	Name string
+	Cert *x509.Certificate
+
+	// 没有被吊销时为零
+	RevokedAt time.Time
+}
+
+// 列出配置目录中所有由本 CA 签发的证书，按照文件名排序
+func (a *Authority) List() ([]Issued, error) {
+	revoked, _, err := a.revoked()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(a.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	issued := make([]Issued, 0, len(files))
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".pem")
+		if name+".pem" == CAFile || name+".pem" == CRLFile || strings.HasSuffix(name, "-key") {
+			continue
+		}
+		cert, err := readCert(f)
+		if err != nil || cert.CheckSignatureFrom(a.cert) != nil {
+			continue
+		}
+		i := Issued{Name: name, Cert: cert}
+		if entry, ok := revoked[cert.SerialNumber.String()]; ok {
+			i.RevokedAt = entry.RevocationTime
+		}
+		issued = append(issued, i)
+	}
+	return issued, nil
+}
+
+// 把证书加入 CRL 并重新签发 CRL
+// 参数 nameOrSerial 可以是证书的文件名（去掉 .pem 后缀）或者十六进制的序列号
+func (a *Authority) Revoke(nameOrSerial string) (*Issued, error) {
+	issued, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+	var target *Issued
+	for i := range issued {
+		if issued[i].Name == nameOrSerial || issued[i].Cert.SerialNumber.Text(16) == strings.ToLower(nameOrSerial) {
+			target = &issued[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("%w: %s", errNotIssued, nameOrSerial)
+	}
+	if !target.RevokedAt.IsZero() {
+		return target, nil
+	}
+
+	revoked, number, err := a.revoked()
+	if err != nil {
+		return nil, err
+	}
+	target.RevokedAt = time.Now()
+	revoked[target.Cert.SerialNumber.String()] = x509.RevocationListEntry{
+		SerialNumber:   target.Cert.SerialNumber,
+		RevocationTime: target.RevokedAt,
+	}
+	if err := a.writeCRL(revoked, number+1); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// 读取已有的 CRL，不存在时返回空的列表
+func (a *Authority) revoked() (map[string]x509.RevocationListEntry, int64, error) {
+	entries := make(map[string]x509.RevocationListEntry)
+	b, err := os.ReadFile(filepath.Join(a.dir, CRLFile))
+	if os.IsNotExist(err) {
+		return entries, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, 0, fmt.Errorf("no PEM data in %s", filepath.Join(a.dir, CRLFile))
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := crl.CheckSignatureFrom(a.cert); err != nil {
+		return nil, 0, fmt.Errorf("%s not signed by this certificate authority: %w", CRLFile, err)
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		entries[entry.SerialNumber.String()] = entry
+	}
+	var number int64
+	if crl.Number != nil {
+		number = crl.Number.Int64()
+	}
+	return entries, number, nil
+}
+
+func (a *Authority) writeCRL(revoked map[string]x509.RevocationListEntry, number int64) error {
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, entry := range revoked {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(x, y x509.RevocationListEntry) int {
+		return x.SerialNumber.Cmp(y.SerialNumber)
+	})
+	now := time.Now()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: entries,
+	}, a.cert, a.key)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(a.dir, CRLFile), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644)
+}
+
+func validateName(name string) error {
+	switch {
+	case name == "":
+		return errNoCertName
+	case strings.ContainsRune(name, filepath.Separator) || strings.Contains(name, "/"):
+		return errInvalidCertName
+	case name+".pem" == CAFile || name+".pem" == CRLFile || name+"-key.pem" == CAKeyFile:
+		return errReservedName
+	}
+	return nil
+}
+
+func addHosts(template *x509.Certificate, hosts []string) error {
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		switch {
+		case h == "":
+		case net.ParseIP(h) != nil:
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(h))
+		case strings.Contains(h, "://"):
+			u, err := url.Parse(h)
+			if err != nil {
+				return err
+			}
+			template.URIs = append(template.URIs, u)
+		case strings.Contains(h, "@"):
+			addr, err := mail.ParseAddress(h)
+			if err != nil {
+				return err
+			}
+			template.EmailAddresses = append(template.EmailAddresses, addr.Address)
+		default:
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	return nil
+}
+
+// 128 位的随机序列号
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func readCert(file string) (*x509.Certificate, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate in %s", file)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(block *pem.Block) (crypto.Signer, error) {
+	var key any
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+func writeCert(file string, der []byte) error {
+	return writeFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func writeKey(file string, key crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// 先写临时文件再重命名，正在运行的服务器不会读到写了一半的证书
+func writeFile(file string, b []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
@@ -0,0 +1,155 @@
+// 读写日志记录的命令行工具的实现，dcls 的 append、read、read-range、tail、offsets 和 reset 命令
+// 输入输出通过 IO 传入，这样可以在测试中直接运行命令
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/sign"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// 命令的标准输入、标准输出和标准错误
+type IO struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+}
+
+// 进程的标准输入输出
+var Stdio = IO{In: os.Stdin, Out: os.Stdout, Err: os.Stderr}
+
+// 连接服务器的命令共用的参数
+// 默认使用配置目录中的 root 用户证书，可以用 -cert 和 -key 选择其他身份
+type clientFlags struct {
+	addr string
+	cert string
+	key  string
+	ca   string
+
+	// 只有 append 设置
+	signingKey string
+
+	// 验证读到的记录的签名使用的公钥，文件不存在时不验证
+	trustedKeys string
+	// 逗号分隔的主题模式，匹配的主题上没有签名的记录也验证失败，应该和服务器的 -signed-topics 相同
+	signedTopics string
+}
+
+func addClientFlags(fs *flag.FlagSet) *clientFlags {
+	c := &clientFlags{}
+	fs.StringVar(&c.addr, "addr", "127.0.0.1:8080", "the address of the server")
+	fs.StringVar(&c.cert, "cert", auth.RootClientCertFile, "the client certificate identifying the user")
+	fs.StringVar(&c.key, "key", auth.RootClientKeyFile, "the private key of the client certificate")
+	fs.StringVar(&c.ca, "ca", auth.CAFile, "the root certificate used to verify the server")
+	fs.StringVar(&c.trustedKeys, "trusted-keys", auth.TrustedKeysFile, "the Ed25519 public keys (PEM) used to verify the signatures of records read")
+	fs.StringVar(&c.signedTopics, "signed-topics", "", "comma separated topic patterns (e.g. audit.*) whose records must be signed")
+	return c
+}
+
+var errNoTrustedKeys = errors.New("-signed-topics requires the trusted keys file")
+
+// 返回的 close 用于关闭连接
+// 信任的公钥文件存在时验证读到的记录的签名
+func (c *clientFlags) dial() (api.LogClient, func() error, error) {
+	tlsConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		EnableMutualTLS: true,
+		CertFile:        c.cert,
+		KeyFile:         c.key,
+		CAFile:          c.ca,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+
+	var signer *sign.Signer
+	if c.signingKey != "" {
+		if signer, err = sign.LoadSigner(c.signingKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	var topics []string
+	if c.signedTopics != "" {
+		topics = strings.Split(c.signedTopics, ",")
+	}
+	var policy *sign.Policy
+	if _, err := os.Stat(c.trustedKeys); err == nil {
+		registry, err := sign.NewRegistry(c.trustedKeys)
+		if err != nil {
+			return nil, nil, err
+		}
+		policy = &sign.Policy{Registry: registry, Topics: topics}
+	} else if len(topics) > 0 {
+		// 否则没有签名的记录不会被拒绝
+		return nil, nil, errNoTrustedKeys
+	}
+	if signer != nil || policy != nil {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(sign.UnaryClientInterceptor(signer, policy)))
+	}
+
+	conn, err := grpc.Dial(c.addr, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return api.NewLogClient(conn), conn.Close, nil
+}
+
+// 读取记录的命令的输出格式，每条记录一行（raw 格式的值本身可能包含换行）
+type format func(w io.Writer, record *api.Record) error
+
+var formats = map[string]format{
+	"raw": func(w io.Writer, record *api.Record) error {
+		_, err := fmt.Fprintf(w, "%s\n", record.Value)
+		return err
+	},
+	"json": func(w io.Writer, record *api.Record) error {
+		b, err := protojson.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	},
+	"hex": func(w io.Writer, record *api.Record) error {
+		_, err := fmt.Fprintln(w, hex.EncodeToString(record.Value))
+		return err
+	},
+	"base64": func(w io.Writer, record *api.Record) error {
+		_, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(record.Value))
+		return err
+	},
+}
+
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func addFormatFlag(fs *flag.FlagSet) *string {
+	return fs.String("o", "raw", "the output format: "+formatNames())
+}
+
+func parseFormat(name string) (format, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q, expected one of %s", name, formatNames())
+	}
+	return f, nil
+}
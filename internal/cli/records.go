@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 读写日志记录的命令
+// 每个命令连接一次服务器，执行完就退出，方便在脚本中使用
+
+var (
+	errAppendArgs = errors.New("give the value as an argument, with -file, or on stdin")
+	errOffsetArgs = errors.New("expected offsets as arguments")
+)
+
+// 每个命令的参数 args 不包括命令名，参数错误时返回错误而不是退出进程
+// 命令在 ctx 被取消时停止，tail -f 在被取消时正常返回
+
+// dcls append [flags] [value]
+// 没有参数和 -file 时从标准输入读取值，-lines 时每一行是一条记录
+func Append(ctx context.Context, stdio IO, args []string) error {
+	fs := newFlagSet("append", stdio)
+	c := addClientFlags(fs)
+	file := fs.String("file", "", "read the value from this file (- for stdin)")
+	lines := fs.Bool("lines", false, "append every line of the input as a separate record")
+	topic := fs.String("topic", "", "the topic of the records")
+	key := fs.String("record-key", "", "the key of the records")
+	fs.StringVar(&c.signingKey, "signing-key", "", "the Ed25519 private key (PEM) used to sign the records")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var in io.Reader
+	switch {
+	case fs.NArg() > 1 || (fs.NArg() == 1 && *file != ""):
+		return errAppendArgs
+	case fs.NArg() == 1:
+		in = strings.NewReader(fs.Arg(0))
+	case *file == "" || *file == "-":
+		in = stdio.In
+	default:
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	client, closeConn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	appendValue := func(value []byte) error {
+		record := &api.Record{Value: value, Topic: *topic}
+		if *key != "" {
+			record.Key = []byte(*key)
+		}
+		rsp, err := client.Append(ctx, &api.AppendRequest{Record: record})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdio.Out, rsp.Offset)
+		return err
+	}
+
+	if !*lines {
+		value, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		return appendValue(value)
+	}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		// 复制一份，Scanner 会复用缓冲区
+		if err := appendValue(append([]byte(nil), sc.Bytes()...)); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// dcls read [flags] <offset>
+func Read(ctx context.Context, stdio IO, args []string) error {
+	fs := newFlagSet("read", stdio)
+	c := addClientFlags(fs)
+	output := addFormatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := parseFormat(*output)
+	if err != nil {
+		return err
+	}
+	offsets, err := parseOffsets(fs.Args(), 1)
+	if err != nil {
+		return err
+	}
+	client, closeConn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	rsp, err := client.Read(ctx, &api.ReadRequest{Offset: offsets[0]})
+	if err != nil {
+		return err
+	}
+	return f(stdio.Out, rsp.Record)
+}
+
+// dcls read-range [flags] <from> <to>，包含 from 和 to
+func ReadRange(ctx context.Context, stdio IO, args []string) error {
+	fs := newFlagSet("read-range", stdio)
+	c := addClientFlags(fs)
+	output := addFormatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := parseFormat(*output)
+	if err != nil {
+		return err
+	}
+	offsets, err := parseOffsets(fs.Args(), 2)
+	if err != nil {
+		return err
+	}
+	if offsets[0] > offsets[1] {
+		return fmt.Errorf("from %d is greater than to %d", offsets[0], offsets[1])
+	}
+	client, closeConn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	for offset := offsets[0]; offset <= offsets[1]; offset++ {
+		rsp, err := client.Read(ctx, &api.ReadRequest{Offset: offset})
+		if err != nil {
+			return err
+		}
+		if err := f(stdio.Out, rsp.Record); err != nil {
+			return err
+		}
+		if offset == offsets[1] {
+			// 避免 to 是 uint64 的最大值时溢出
+			break
+		}
+	}
+	return nil
+}
+
+// dcls tail [flags]
+// 打印最后 n 条记录，-f 时轮询服务器并打印新追加的记录，直到 ctx 被取消
+func Tail(ctx context.Context, stdio IO, args []string) error {
+	fs := newFlagSet("tail", stdio)
+	c := addClientFlags(fs)
+	output := addFormatFlag(fs)
+	n := fs.Uint64("n", 10, "the number of records to print first")
+	follow := fs.Bool("f", false, "keep printing records as they are appended")
+	interval := fs.Duration("interval", time.Second, "how often to poll for new records with -f")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := parseFormat(*output)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	client, closeConn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	offsets, err := client.Offsets(ctx, &api.OffsetsRequest{})
+	if err != nil {
+		return err
+	}
+	next := offsets.Lowest
+	if offsets.NextOffset-offsets.Lowest > *n {
+		next = offsets.NextOffset - *n
+	}
+	// 最后打印的记录，用来发现日志是否被重置
+	var last *api.Record
+	for {
+		for ; next < offsets.NextOffset; next++ {
+			rsp, err := client.Read(ctx, &api.ReadRequest{Offset: next})
+			if status.Code(err) == codes.OutOfRange {
+				// 记录还没有提交，下次轮询时再读
+				break
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			if err := f(stdio.Out, rsp.Record); err != nil {
+				return err
+			}
+			last = rsp.Record
+		}
+		if !*follow {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+		offsets, err = client.Offsets(ctx, &api.OffsetsRequest{})
+		var reset bool
+		if err == nil {
+			reset, err = wasReset(ctx, client, offsets, next, last)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		// 日志被重置后从头开始
+		if reset {
+			fmt.Fprintf(stdio.Err, "dcls tail: log was reset, continuing from offset %d\n", offsets.Lowest)
+			next = offsets.Lowest
+			last = nil
+		}
+	}
+}
+
+// 下标范围不再包含 next 时日志被重置了
+// 重置后新追加的记录可能恰好让下标范围回到原来的位置，所以还要确认最后打印的记录没有变化
+func wasReset(ctx context.Context, client api.LogClient, offsets *api.OffsetsResponse, next uint64, last *api.Record) (bool, error) {
+	if next < offsets.Lowest || next > offsets.NextOffset {
+		return true, nil
+	}
+	if last == nil {
+		return false, nil
+	}
+	rsp, err := client.Read(ctx, &api.ReadRequest{Offset: last.Offset})
+	if status.Code(err) == codes.InvalidArgument {
+		// 记录已经不存在了
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !proto.Equal(rsp.Record, last), nil
+}
+
+// dcls offsets [flags]
+func Offsets(ctx context.Context, stdio IO, args []string) error {
+	fs := newFlagSet("offsets", stdio)
+	c := addClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, closeConn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	rsp, err := client.Offsets(ctx, &api.OffsetsRequest{})
+	if err != nil {
+		return err
+	}
+	if rsp.NextOffset == rsp.Lowest {
+		_, err = fmt.Fprintf(stdio.Out, "lowest: %d, highest: -, log is empty\n", rsp.Lowest)
+		return err
+	}
+	_, err = fmt.Fprintf(stdio.Out, "lowest: %d, highest: %d\n", rsp.Lowest, rsp.Highest)
+	return err
+}
+
+// dcls reset [flags]
+// 删除所有日志分为两步，不带 -token 时只申请确认令牌，-yes 时直接用申请到的令牌确认
+func Reset(ctx context.Context, stdio IO, args []string) error {
+	fs := newFlagSet("reset", stdio)
+	c := addClientFlags(fs)
+	token := fs.String("token", "", "the confirmation token returned by a previous dcls reset")
+	yes := fs.Bool("yes", false, "request a token and confirm the reset in one step")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, closeConn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	if *token == "" {
+		rsp, err := client.Reset(ctx, &api.ResetRequest{})
+		if err != nil {
+			return err
+		}
+		if !*yes {
+			_, err = fmt.Fprintf(stdio.Out, "all logs will be archived, confirm before %s with:\ndcls reset -token %s\n",
+				time.UnixMilli(rsp.ExpiresAtMs).Format(time.TimeOnly), rsp.ConfirmationToken)
+			return err
+		}
+		*token = rsp.ConfirmationToken
+	}
+	rsp, err := client.Reset(ctx, &api.ResetRequest{ConfirmationToken: *token})
+	if err != nil {
+		return err
+	}
+	if rsp.Archive != "" {
+		_, err = fmt.Fprintf(stdio.Out, "%s, archived as %s\n", rsp.Reply, rsp.Archive)
+	} else {
+		_, err = fmt.Fprintln(stdio.Out, rsp.Reply)
+	}
+	return err
+}
+
+// 参数错误时返回错误，用法输出到标准错误
+func newFlagSet(name string, stdio IO) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stdio.Err)
+	return fs
+}
+
+func parseOffsets(args []string, n int) ([]uint64, error) {
+	if len(args) != n {
+		return nil, errOffsetArgs
+	}
+	offsets := make([]uint64, n)
+	for i, arg := range args {
+		offset, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset %q", arg)
+		}
+		offsets[i] = offset
+	}
+	return offsets, nil
+}
@@ -0,0 +1,370 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/youngfr/dcls/internal/discovery"
+	"go.uber.org/zap"
+)
+
+type Topic struct {
+	Name              string
+	ReplicationFactor int
+	Partitions        []Partition
+
+	// 没有 Mover 时分配结果只是建议，没有数据真正按照它移动
+	// 分配结果只保存在内存中，控制器重新创建后根据集群成员重新计算
+	Advisory bool
+}
+
+type Partition struct {
+	ID int
+
+	// 处理这个分区的读写的节点
+	Leader string
+
+	// 分区的目标副本，按照优先级排序
+	Replicas []string
+
+	// 目标副本中还在从其他节点复制数据的副本
+	// 它们在复制完成之前不能成为 leader
+	Syncing []string
+
+	// 已经不属于目标副本但是还持有数据的节点
+	// 在所有副本都复制完成之前它们继续提供服务，这样迁移数据时不需要暂停写入
+	Retiring []string
+}
+
+// 获取集群成员及其标签
+type MemberSource interface {
+	Members() []serf.Member
+}
+
+var _ MemberSource = (*discovery.Membership)(nil)
+
+// 在节点之间移动分区的数据
+type Mover interface {
+	// 把分区的数据从 from 节点复制到 to 节点
+	// 复制期间 from 节点仍然正常处理写入，返回时 to 节点应该已经追上了 from 节点
+	Move(ctx context.Context, topic string, partition int, from, to string) error
+}
+
+var (
+	ErrTopicNotFound     = errors.New("topic not found")
+	ErrTopicExists       = errors.New("topic already exists")
+	errInvalidPartitions = errors.New("number of partitions must be positive")
+	errInvalidReplicas   = errors.New("replication factor must be positive")
+)
+
+// 移动数据失败后重试的间隔
+const retryInterval = 5 * time.Second
+
+// 根据集群成员为每个分区分配 leader 和副本
+// 节点加入、离开或者失败时重新分配并在后台移动数据
+//
+// Controller 实现了 discovery.Handler 及其所有可选接口
+// 所以可以直接接收 Membership 的事件
+type Controller struct {
+	members MemberSource
+	mover   Mover
+
+	mu     sync.Mutex
+	topics map[string]*Topic
+	moving map[move]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger *zap.Logger
+}
+
+type move struct {
+	topic     string
+	partition int
+	to        string
+}
+
+// 参数 mover 为空时认为数据的移动总是立即完成，这时分配结果只是建议，参见 Topic
+func New(members MemberSource, mover Mover) *Controller {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Controller{
+		members: members,
+		mover:   mover,
+		topics:  make(map[string]*Topic),
+		moving:  make(map[move]bool),
+		ctx:     ctx,
+		cancel:  cancel,
+		logger:  zap.L().Named("controller"),
+	}
+}
+
+// 创建一个有 partitions 个分区、每个分区有 rf 个副本的主题
+func (c *Controller) CreateTopic(name string, partitions, rf int) error {
+	if partitions <= 0 {
+		return errInvalidPartitions
+	}
+	if rf <= 0 {
+		return errInvalidReplicas
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.topics[name]; ok {
+		return fmt.Errorf("%w: %s", ErrTopicExists, name)
+	}
+	t := &Topic{
+		Name:              name,
+		ReplicationFactor: rf,
+		Partitions:        make([]Partition, partitions),
+	}
+	for i := range t.Partitions {
+		t.Partitions[i].ID = i
+	}
+	c.topics[name] = t
+	c.rebalance()
+	return nil
+}
+
+// 返回主题当前分配结果的副本
+func (c *Controller) DescribeTopic(name string) (*Topic, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.topics[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTopicNotFound, name)
+	}
+	res := &Topic{
+		Name:              t.Name,
+		ReplicationFactor: t.ReplicationFactor,
+		Partitions:        make([]Partition, len(t.Partitions)),
+		Advisory:          c.mover == nil,
+	}
+	for i, p := range t.Partitions {
+		res.Partitions[i] = Partition{
+			ID:       p.ID,
+			Leader:   p.Leader,
+			Replicas: slices.Clone(p.Replicas),
+			Syncing:  slices.Clone(p.Syncing),
+			Retiring: slices.Clone(p.Retiring),
+		}
+	}
+	return res, nil
+}
+
+func (c *Controller) Topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make([]string, 0, len(c.topics))
+	for name := range c.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 根据当前的集群成员重新分配所有分区
+func (c *Controller) Rebalance() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebalance()
+}
+
+// 停止所有正在进行的数据移动
+func (c *Controller) Close() {
+	c.cancel()
+}
+
+func (c *Controller) rebalance() {
+	nodes := aliveNodes(c.members.Members())
+	alive := make(map[string]bool)
+	for _, n := range nodes {
+		alive[n.name] = true
+	}
+
+	for _, t := range c.topics {
+		for i := range t.Partitions {
+			p := &t.Partitions[i]
+			c.reassign(t.Name, p, place(nodes, t.Name, p.ID, t.ReplicationFactor), alive)
+		}
+	}
+}
+
+func (c *Controller) reassign(topic string, p *Partition, target []string, alive map[string]bool) {
+	// 还存活并且已经持有完整数据的节点
+	holders := make([]string, 0)
+	for _, r := range append(slices.Clone(p.Replicas), p.Retiring...) {
+		if alive[r] && !slices.Contains(p.Syncing, r) && !slices.Contains(holders, r) {
+			holders = append(holders, r)
+		}
+	}
+
+	first := len(p.Replicas) == 0 && len(p.Retiring) == 0
+	p.Replicas = target
+	p.Syncing = nil
+	p.Retiring = nil
+
+	switch {
+	case first:
+		// 新分区还没有任何数据，不需要移动
+	case len(holders) == 0:
+		if len(target) > 0 {
+			c.logger.Error(
+				"all replicas holding data are gone",
+				zap.String("topic", topic),
+				zap.Int("partition", p.ID),
+			)
+		}
+	default:
+		for _, r := range target {
+			if !slices.Contains(holders, r) {
+				p.Syncing = append(p.Syncing, r)
+			}
+		}
+		if len(p.Syncing) > 0 {
+			for _, h := range holders {
+				if !slices.Contains(target, h) {
+					p.Retiring = append(p.Retiring, h)
+				}
+			}
+		}
+	}
+
+	p.Leader = chooseLeader(p, alive)
+
+	for _, to := range p.Syncing {
+		c.startMove(topic, p, to, holders)
+	}
+}
+
+// 尽量保持原来的 leader 不变以减少 leader 切换
+// 否则选择优先级最高的已经持有数据的副本
+func chooseLeader(p *Partition, alive map[string]bool) string {
+	candidates := make([]string, 0)
+	for _, r := range p.Replicas {
+		if !slices.Contains(p.Syncing, r) {
+			candidates = append(candidates, r)
+		}
+	}
+	candidates = append(candidates, p.Retiring...)
+	if slices.Contains(candidates, p.Leader) && alive[p.Leader] {
+		return p.Leader
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+	return ""
+}
+
+func (c *Controller) startMove(topic string, p *Partition, to string, holders []string) {
+	key := move{topic: topic, partition: p.ID, to: to}
+	if c.moving[key] {
+		return
+	}
+	// 优先从 leader 复制数据
+	from := holders[0]
+	if slices.Contains(holders, p.Leader) {
+		from = p.Leader
+	}
+	c.moving[key] = true
+
+	c.logger.Info(
+		"moving partition",
+		zap.String("topic", topic),
+		zap.Int("partition", p.ID),
+		zap.String("from", from),
+		zap.String("to", to),
+	)
+	go func() {
+		var err error
+		if c.mover != nil {
+			err = c.mover.Move(c.ctx, topic, p.ID, from, to)
+		}
+		c.finishMove(key, err)
+	}()
+}
+
+func (c *Controller) finishMove(key move, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.moving, key)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.logger.Error(
+			"failed to move partition",
+			zap.Error(err),
+			zap.String("topic", key.topic),
+			zap.Int("partition", key.partition),
+			zap.String("to", key.to),
+		)
+		time.AfterFunc(retryInterval, c.Rebalance)
+		return
+	}
+
+	t, ok := c.topics[key.topic]
+	if !ok || key.partition >= len(t.Partitions) {
+		return
+	}
+	p := &t.Partitions[key.partition]
+	i := slices.Index(p.Syncing, key.to)
+	if i < 0 {
+		return
+	}
+	p.Syncing = slices.Delete(p.Syncing, i, i+1)
+	if len(p.Syncing) == 0 {
+		// 所有副本都已经追上，可以移除旧的副本
+		p.Retiring = nil
+		members := c.members.Members()
+		alive := make(map[string]bool)
+		for _, n := range aliveNodes(members) {
+			alive[n.name] = true
+		}
+		p.Leader = chooseLeader(p, alive)
+	}
+}
+
+// 以下方法使 Controller 可以作为 discovery.Handler 使用
+// 成员发生任何变化时都重新分配所有分区
+
+var (
+	_ discovery.Handler       = (*Controller)(nil)
+	_ discovery.FailHandler   = (*Controller)(nil)
+	_ discovery.UpdateHandler = (*Controller)(nil)
+	_ discovery.ReapHandler   = (*Controller)(nil)
+)
+
+func (c *Controller) Join(name, addr string) error {
+	c.Rebalance()
+	return nil
+}
+
+func (c *Controller) Leave(name string) error {
+	c.Rebalance()
+	return nil
+}
+
+func (c *Controller) Fail(name string) error {
+	c.Rebalance()
+	return nil
+}
+
+func (c *Controller) Update(name, addr string) error {
+	c.Rebalance()
+	return nil
+}
+
+func (c *Controller) Reap(name string) error {
+	c.Rebalance()
+	return nil
+}
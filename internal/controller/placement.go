@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+// 节点的标签
+const (
+	// 节点所在的机架，同一个分区的副本尽量分布在不同的机架上
+	RackTag = "rack"
+
+	// 节点的容量，容量越大分配到的副本越多
+	// 没有设置时为 1，为 0 时不分配任何副本（可以用来下线节点）
+	CapacityTag = "capacity"
+)
+
+type node struct {
+	name     string
+	rack     string
+	capacity float64
+}
+
+// 只有存活并且容量大于零的节点才能分配副本
+func aliveNodes(members []serf.Member) []node {
+	nodes := make([]node, 0, len(members))
+	for _, m := range members {
+		if m.Status != serf.StatusAlive {
+			continue
+		}
+		capacity := 1.0
+		if s, ok := m.Tags[CapacityTag]; ok {
+			c, err := strconv.ParseFloat(s, 64)
+			if err != nil || c < 0 {
+				continue
+			}
+			capacity = c
+		}
+		if capacity == 0 {
+			continue
+		}
+		nodes = append(nodes, node{name: m.Name, rack: m.Tags[RackTag], capacity: capacity})
+	}
+	return nodes
+}
+
+// 使用带权重的最高随机权重哈希（rendezvous hashing）为分区选出 rf 个副本
+//
+// 每个节点对每个分区都有一个只由节点名、分区和容量决定的分数
+// 分数最高的 rf 个节点就是这个分区的副本，分数最高的节点优先作为 leader
+// 这样做有两个好处：
+// 1. 只要所有节点看到的成员相同，它们计算出的分配结果就相同，不需要额外的协调
+// 2. 节点加入或离开时只有与它有关的分区会被重新分配，数据的移动量最小
+//
+// 选择副本时先保证每个机架最多一个副本，机架不够时再按分数补足
+func place(nodes []node, topic string, partition int, rf int) []string {
+	type scored struct {
+		node
+		score float64
+	}
+	candidates := make([]scored, 0, len(nodes))
+	for _, n := range nodes {
+		candidates = append(candidates, scored{node: n, score: score(n, topic, partition)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	replicas := make([]string, 0, rf)
+	chosen := make(map[string]bool)
+	racks := make(map[string]bool)
+	for _, c := range candidates {
+		if len(replicas) == rf {
+			break
+		}
+		if c.rack != "" && racks[c.rack] {
+			continue
+		}
+		replicas = append(replicas, c.name)
+		chosen[c.name] = true
+		racks[c.rack] = true
+	}
+	for _, c := range candidates {
+		if len(replicas) == rf {
+			break
+		}
+		if !chosen[c.name] {
+			replicas = append(replicas, c.name)
+			chosen[c.name] = true
+		}
+	}
+	return replicas
+}
+
+func score(n node, topic string, partition int) float64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%d/%s", topic, partition, n.name)
+	// 把哈希值映射到 (0, 1) 区间
+	u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+	return -n.capacity / math.Log(u)
+}
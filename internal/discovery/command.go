@@ -0,0 +1,223 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"go.uber.org/zap"
+)
+
+// 在集群中所有节点上执行的命令
+// 比如 “报告本节点的日志下标” 和 “重新加载访问控制策略”
+//
+// 命令通过三种方式下发：
+// Query 使用 serf 查询，会等待并收集每个节点的响应
+// QueryAll 也使用 serf 查询，并且返回没有成功执行命令的存活节点
+// Broadcast 使用 serf 用户事件，尽力通知所有节点，不收集响应，也不保证多个事件的顺序
+//
+// 参数 payload 是命令的请求数据
+// 返回值是本节点的响应数据，通过 Broadcast 下发时会被丢弃
+type CommandHandler func(payload []byte) ([]byte, error)
+
+// 避免与 serf 内部使用的查询（以 _serf_ 开头）和其他程序的查询重名
+const commandPrefix = "dcls:"
+
+// 响应数据的第一个字节表示命令是否执行成功
+const (
+	commandOK byte = iota
+	commandFailed
+)
+
+var (
+	errEmptyCommandName = errors.New("command name is empty")
+	errUnknownCommand   = errors.New("unknown command")
+	errNoResponse       = errors.New("no response before timeout")
+)
+
+// 注册一个命令
+// 所有节点都需要注册相同的命令才能正确响应
+func (m *Membership) RegisterCommand(name string, h CommandHandler) {
+	m.commandsMu.Lock()
+	defer m.commandsMu.Unlock()
+	m.commands[name] = h
+}
+
+func (m *Membership) command(name string) (CommandHandler, bool) {
+	m.commandsMu.RLock()
+	defer m.commandsMu.RUnlock()
+	h, ok := m.commands[name]
+	return h, ok
+}
+
+// 一个节点对命令的响应
+type CommandResponse struct {
+	Node    string
+	Payload []byte
+	Err     error
+}
+
+// 在所有节点（包括本节点）上执行命令
+// 在 timeout 时间内收集各个节点的响应，超时之后才响应的节点会被忽略
+// timeout 为零时使用 serf 根据集群规模计算出的默认超时时间
+func (m *Membership) Query(name string, payload []byte, timeout time.Duration) ([]CommandResponse, error) {
+	if name == "" {
+		return nil, errEmptyCommandName
+	}
+	rsp, err := m.serf.Query(commandPrefix+name, payload, &serf.QueryParam{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]CommandResponse, 0)
+	for r := range rsp.ResponseCh() {
+		responses = append(responses, decodeCommandResponse(r.From, r.Payload))
+	}
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].Node < responses[j].Node
+	})
+	return responses, nil
+}
+
+// 在所有存活的节点（包括本节点）上执行命令
+// 返回执行失败或者在 timeout 时间内没有响应的节点及其错误，都成功时返回空的映射
+// 发出查询之后才加入集群的节点不会执行命令，也不会出现在结果中
+func (m *Membership) QueryAll(name string, payload []byte, timeout time.Duration) (map[string]error, error) {
+	expected := make(map[string]bool)
+	for _, member := range m.Members() {
+		if member.Status == serf.StatusAlive {
+			expected[member.Name] = true
+		}
+	}
+	responses, err := m.Query(name, payload, timeout)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]error)
+	for _, r := range responses {
+		if r.Err != nil {
+			failed[r.Node] = r.Err
+		}
+		delete(expected, r.Node)
+	}
+	for node := range expected {
+		failed[node] = errNoResponse
+	}
+	return failed, nil
+}
+
+// 通知所有节点（包括本节点）执行命令但不等待响应
+// 用户事件的名字和数据加起来不能超过 serf 的 UserEventSizeLimit 即 512 字节
+func (m *Membership) Broadcast(name string, payload []byte) error {
+	if name == "" {
+		return errEmptyCommandName
+	}
+	return m.serf.UserEvent(commandPrefix+name, payload, false)
+}
+
+func (m *Membership) handleQuery(q *serf.Query) {
+	name, ok := strings.CutPrefix(q.Name, commandPrefix)
+	if !ok {
+		return
+	}
+	var rsp []byte
+	if h, ok := m.command(name); !ok {
+		rsp = encodeCommandResponse(nil, errUnknownCommand)
+	} else {
+		rsp = encodeCommandResponse(h(q.Payload))
+	}
+	if err := q.Respond(rsp); err != nil {
+		m.logger.Error(
+			"failed to respond to query",
+			zap.Error(err),
+			zap.String("query", name),
+			zap.String("source", q.SourceNode()),
+		)
+	}
+}
+
+func (m *Membership) handleUserEvent(e serf.UserEvent) {
+	name, ok := strings.CutPrefix(e.Name, commandPrefix)
+	if !ok {
+		return
+	}
+	h, ok := m.command(name)
+	if !ok {
+		m.logger.Warn("unknown command in user event", zap.String("event", name))
+		return
+	}
+	if _, err := h(e.Payload); err != nil {
+		m.logger.Error("failed to handle user event", zap.Error(err), zap.String("event", name))
+	}
+}
+
+func encodeCommandResponse(payload []byte, err error) []byte {
+	if err != nil {
+		return append([]byte{commandFailed}, err.Error()...)
+	}
+	return append([]byte{commandOK}, payload...)
+}
+
+func decodeCommandResponse(node string, b []byte) CommandResponse {
+	r := CommandResponse{Node: node}
+	switch {
+	case len(b) == 0:
+		r.Err = errors.New("empty response")
+	case b[0] == commandOK:
+		r.Payload = b[1:]
+	default:
+		r.Err = errors.New(string(b[1:]))
+	}
+	return r
+}
+
+// 以下是使用 JSON 编码请求和响应数据的辅助函数
+// 这样命令的实现和调用方都可以直接使用结构体
+
+// 把参数和返回值都是结构体的函数包装为 CommandHandler
+func JSONCommand[Req, Rsp any](f func(req Req) (Rsp, error)) CommandHandler {
+	return func(payload []byte) ([]byte, error) {
+		var req Req
+		if len(payload) != 0 {
+			if err := json.Unmarshal(payload, &req); err != nil {
+				return nil, fmt.Errorf("failed to decode request: %w", err)
+			}
+		}
+		rsp, err := f(req)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(rsp)
+	}
+}
+
+// 执行使用 JSON 编码的命令并解码每个节点的响应
+// 返回节点名到响应的映射以及节点名到错误的映射
+func QueryJSON[Req, Rsp any](m *Membership, name string, req Req, timeout time.Duration) (map[string]Rsp, map[string]error, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	responses, err := m.Query(name, payload, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	results := make(map[string]Rsp)
+	errs := make(map[string]error)
+	for _, r := range responses {
+		if r.Err != nil {
+			errs[r.Node] = r.Err
+			continue
+		}
+		var rsp Rsp
+		if err := json.Unmarshal(r.Payload, &rsp); err != nil {
+			errs[r.Node] = fmt.Errorf("failed to decode response: %w", err)
+			continue
+		}
+		results[r.Node] = rsp
+	}
+	return results, errs, nil
+}
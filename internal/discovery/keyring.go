@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+	"go.uber.org/zap"
+)
+
+// 密钥环文件的格式与 serf 写回的格式相同
+// 是由 base64 编码的密钥组成的 JSON 数组
+// 其中第一个密钥是主密钥，用于加密发出的消息
+// 其他密钥只用于解密收到的消息
+//
+// 非空的密钥环文件优先于配置中的主密钥：轮换密钥后文件中是新的密钥，
+// 而命令行参数通常还是最初的密钥，以参数为准会让重启的节点无法和其他节点通信
+// 配置中的主密钥只用于在第一次启动时（文件不存在或者为空）写入初始的密钥
+func loadKeyring(c Config, logger *zap.Logger) (*memberlist.Keyring, error) {
+	keys, err := readKeyring(c.KeyringFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 {
+		if c.EncryptKey != nil && !bytes.Equal(c.EncryptKey, keys[0]) {
+			logger.Warn(
+				"gossip key differs from the primary key in keyring file, using keyring file",
+				zap.String("file", c.KeyringFile),
+			)
+		}
+		return memberlist.NewKeyring(keys, keys[0])
+	}
+	if c.EncryptKey == nil {
+		return nil, nil
+	}
+
+	keyring, err := memberlist.NewKeyring(nil, c.EncryptKey)
+	if err != nil {
+		return nil, err
+	}
+	// 第一次使用密钥环文件时把初始的密钥写进去
+	// 之后对密钥环的修改由 serf 负责写回
+	if c.KeyringFile != "" {
+		if err := writeKeyring(c.KeyringFile, keyring); err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+// 文件名为空或者文件不存在时返回空的密钥列表
+func readKeyring(file string) ([][]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil
+	}
+	var encoded []string
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file %q: %w", file, err)
+	}
+	keys := make([][]byte, 0, len(encoded))
+	for _, s := range encoded {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key in keyring file %q: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func writeKeyring(file string, keyring *memberlist.Keyring) error {
+	encoded := make([]string, 0)
+	for _, key := range keyring.GetKeys() {
+		encoded = append(encoded, base64.StdEncoding.EncodeToString(key))
+	}
+	b, err := json.MarshalIndent(encoded, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0600)
+}
+
+var errEncryptionDisabled = errors.New("gossip encryption is not enabled")
+
+// gossip 消息是否加密
+// 没有加密时任何能访问 serf 端口的人都可以发送查询，不能通过查询执行修改状态的命令
+func (m *Membership) EncryptionEnabled() bool {
+	return m.serf.EncryptionEnabled()
+}
+
+// 以下操作会通过 serf 的查询广播到集群中的所有节点
+// 参数 key 是 base64 编码的密钥
+//
+// 轮换密钥的步骤：
+// 1. InstallKey 在所有节点上安装新密钥
+// 2. UseKey 让所有节点使用新密钥加密消息
+// 3. RemoveKey 从所有节点上删除旧密钥
+// 整个过程都不需要重启节点
+
+func (m *Membership) InstallKey(key string) (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errEncryptionDisabled
+	}
+	return m.serf.KeyManager().InstallKey(key)
+}
+
+func (m *Membership) UseKey(key string) (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errEncryptionDisabled
+	}
+	return m.serf.KeyManager().UseKey(key)
+}
+
+func (m *Membership) RemoveKey(key string) (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errEncryptionDisabled
+	}
+	return m.serf.KeyManager().RemoveKey(key)
+}
+
+func (m *Membership) ListKeys() (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errEncryptionDisabled
+	}
+	return m.serf.KeyManager().ListKeys()
+}
@@ -1,7 +1,9 @@
 package discovery
 
 import (
+	"maps"
 	"net"
+	"sync"
 
 	"github.com/hashicorp/serf/serf"
 	"go.uber.org/zap"
@@ -12,6 +14,20 @@ type Config struct {
 	BindAddr       string
 	Tags           map[string]string
 	StartJoinAddrs []string
+
+	// 用于加密 gossip 消息的主密钥
+	// 长度必须是 16、24 或 32 字节，分别对应 AES-128、AES-192 和 AES-256
+	// 所有节点必须使用相同的密钥才能加入同一个集群
+	// 密钥环文件不为空时使用文件中的密钥，这个密钥只用于初始化密钥环文件
+	EncryptKey []byte
+
+	// 保存密钥环的文件，参见 keyring.go
+	// 设置后通过 InstallKey、UseKey 和 RemoveKey 对密钥环的修改会被写回这个文件
+	// 这样节点重启后仍然使用轮换后的密钥
+	KeyringFile string
+
+	// 为空时不记录指标，参见 metrics.go
+	Metrics Metrics
 }
 
 type Handler interface {
@@ -19,19 +35,45 @@ type Handler interface {
 	Leave(name string) error
 }
 
+// 以下是 Handler 可以选择实现的接口
+// Membership 在收到对应的事件时会检查 Handler 是否实现了它们
+
+// 节点崩溃或者网络不可达时调用
+type FailHandler interface {
+	Fail(name string) error
+}
+
+// 节点的标签发生变化（比如有了新的 rpc_addr）时调用
+type UpdateHandler interface {
+	Update(name string, addr string) error
+}
+
+// 已经离开或者失败的节点被彻底移出集群时调用
+type ReapHandler interface {
+	Reap(name string) error
+}
+
 type Membership struct {
 	Config
 	handler Handler
 	events  chan serf.Event
 	serf    *serf.Serf
 	logger  *zap.Logger
+
+	// 已经注册的命令，参见 command.go
+	commandsMu sync.RWMutex
+	commands   map[string]CommandHandler
+
+	// 保护 SetTags 修改的 Config.Tags，创建之后通过 LocalTags 读取
+	tagsMu sync.RWMutex
 }
 
 func NewMembership(h Handler, c Config) (*Membership, error) {
 	m := &Membership{
-		Config:  c,
-		handler: h,
-		logger:  zap.L().Named("membership"),
+		Config:   c,
+		handler:  h,
+		logger:   zap.L().Named("membership"),
+		commands: make(map[string]CommandHandler),
 	}
 	if err := m.setupSerf(); err != nil {
 		return nil, err
@@ -54,12 +96,19 @@ func (m *Membership) setupSerf() error {
 		c.EventCh = m.events
 		c.Tags = m.Tags
 		c.NodeName = m.NodeName
+		// 配置了密钥时对 gossip 消息进行加密
+		// 没有正确密钥的节点无法加入集群
+		if c.MemberlistConfig.Keyring, err = loadKeyring(m.Config, m.logger); err != nil {
+			return err
+		}
+		c.KeyringFile = m.KeyringFile
 	}
 	// 新建一个 Serf 实例
 	m.serf, err = serf.Create(c)
 	if err != nil {
 		return err
 	}
+	m.updateMetrics()
 	// 启动事件处理线程
 	go m.eventHandler()
 	// 加入已经存在的（如果有的话）集群
@@ -73,18 +122,44 @@ func (m *Membership) setupSerf() error {
 
 func (m *Membership) eventHandler() {
 	for event := range m.events {
-		members := event.(serf.MemberEvent).Members
-		for _, member := range members {
-			if !m.isLocal(member) {
-				switch event.EventType() {
-				case serf.EventMemberJoin:
-					m.handleJoin(member)
-				case serf.EventMemberLeave:
-					m.handleLeave(member)
-				}
-			}
+		switch e := event.(type) {
+		case serf.MemberEvent:
+			m.handleMemberEvent(e)
+		case serf.UserEvent:
+			// 命令可能执行较长时间
+			// 不能阻塞后续成员变化事件的处理
+			go m.handleUserEvent(e)
+		case *serf.Query:
+			go m.handleQuery(e)
+		default:
+			m.logger.Debug(
+				"ignored event",
+				zap.String("type", event.EventType().String()),
+				zap.String("event", event.String()),
+			)
+		}
+	}
+}
+
+func (m *Membership) handleMemberEvent(e serf.MemberEvent) {
+	for _, member := range e.Members {
+		if m.isLocal(member) {
+			continue
+		}
+		switch e.Type {
+		case serf.EventMemberJoin:
+			m.handleJoin(member)
+		case serf.EventMemberLeave:
+			m.handleLeave(member)
+		case serf.EventMemberFailed:
+			m.handleFail(member)
+		case serf.EventMemberUpdate:
+			m.handleUpdate(member)
+		case serf.EventMemberReap:
+			m.handleReap(member)
 		}
 	}
+	m.updateMetrics()
 }
 
 func (m *Membership) isLocal(member serf.Member) bool {
@@ -103,6 +178,36 @@ func (m *Membership) handleLeave(member serf.Member) {
 	}
 }
 
+func (m *Membership) handleFail(member serf.Member) {
+	h, ok := m.handler.(FailHandler)
+	if !ok {
+		return
+	}
+	if err := h.Fail(member.Name); err != nil {
+		m.logError("failed to handle failure", err, member)
+	}
+}
+
+func (m *Membership) handleUpdate(member serf.Member) {
+	h, ok := m.handler.(UpdateHandler)
+	if !ok {
+		return
+	}
+	if err := h.Update(member.Name, member.Tags["rpc_addr"]); err != nil {
+		m.logError("failed to update", err, member)
+	}
+}
+
+func (m *Membership) handleReap(member serf.Member) {
+	h, ok := m.handler.(ReapHandler)
+	if !ok {
+		return
+	}
+	if err := h.Reap(member.Name); err != nil {
+		m.logError("failed to reap", err, member)
+	}
+}
+
 func (m *Membership) logError(msg string, err error, member serf.Member) {
 	m.logger.Error(
 		msg,
@@ -116,6 +221,33 @@ func (m *Membership) Members() []serf.Member {
 	return m.serf.Members()
 }
 
+// 更新本节点的标签
+// 其他节点会收到 EventMemberUpdate 事件
+// 保存的是 tags 的副本，调用者之后修改 tags 不会影响本节点的标签
+func (m *Membership) SetTags(tags map[string]string) error {
+	tags = maps.Clone(tags)
+	m.tagsMu.Lock()
+	defer m.tagsMu.Unlock()
+	if err := m.serf.SetTags(tags); err != nil {
+		return err
+	}
+	m.Tags = tags
+	return nil
+}
+
+// 返回本节点当前标签的副本，可以和 SetTags 并发调用
+func (m *Membership) LocalTags() map[string]string {
+	m.tagsMu.RLock()
+	defer m.tagsMu.RUnlock()
+	return maps.Clone(m.Tags)
+}
+
 func (m *Membership) Leave() error {
 	return m.serf.Leave()
 }
+
+// 不通知其他节点直接停止服务
+// 对于其他节点来说就相当于本节点崩溃了
+func (m *Membership) Shutdown() error {
+	return m.serf.Shutdown()
+}
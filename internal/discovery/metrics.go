@@ -0,0 +1,37 @@
+package discovery
+
+import (
+	"github.com/hashicorp/serf/serf"
+	"github.com/youngfr/dcls/internal/metrics"
+)
+
+// 集群成员的指标
+type Metrics interface {
+	// 每种状态（alive、leaving、left、failed）的成员数量，包括本节点
+	Members(counts map[string]int)
+}
+
+// 在 metrics 包中的 *metrics.MembershipMetrics 实现了 Metrics 接口
+var _ Metrics = (*metrics.MembershipMetrics)(nil)
+
+var memberStatuses = []serf.MemberStatus{
+	serf.StatusAlive,
+	serf.StatusLeaving,
+	serf.StatusLeft,
+	serf.StatusFailed,
+}
+
+// 每次成员变化后重新统计，没有成员的状态也报告为零
+func (m *Membership) updateMetrics() {
+	if m.Metrics == nil {
+		return
+	}
+	counts := make(map[string]int, len(memberStatuses))
+	for _, status := range memberStatuses {
+		counts[status.String()] = 0
+	}
+	for _, member := range m.serf.Members() {
+		counts[member.Status.String()]++
+	}
+	m.Metrics.Members(counts)
+}
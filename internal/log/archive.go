@@ -0,0 +1,273 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 归档
+//
+// Reset 不再删除日志文件，而是把数据目录中的所有文件移动到归档目录下以时间命名的子目录中
+// 归档可以通过 RestoreArchive 恢复，超出保留策略的归档在每次归档和启动时被删除
+
+// 归档目录的名字是创建时的 UTC 时间，按名字排序就是按时间排序
+const archiveTimeFormat = "20060102T150405.000000000Z"
+
+type Archive struct {
+	Name      string
+	CreatedAt time.Time
+
+	// 归档中第一条和最后一条记录的绝对下标
+	LowestOffset  uint64
+	HighestOffset uint64
+
+	Segments int
+	Bytes    uint64
+}
+
+// 没有设置 Config.Archive.Dir 时归档目录和数据目录相邻
+func (l *Log) archiveDir() string {
+	if l.Config.Archive.Dir != "" {
+		return l.Config.Archive.Dir
+	}
+	return filepath.Clean(l.Dir) + ".archive"
+}
+
+// 把所有记录移动到一个新的归档中，之后日志从 InitialOffset 重新开始
+// 返回归档的名字，日志为空时不创建归档并返回空字符串
+func (l *Log) Archive() (string, error) {
+	defer l.startMaintenance()()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	name, err := l.archive()
+	if serr := l.setup(); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return name, err
+	}
+	if name != "" {
+		l.logger.Info("archived log", zap.String("archive", name))
+	}
+	return name, l.purgeArchives()
+}
+
+// 删除所有记录，被删除的记录保存在归档中
+func (l *Log) Reset() error {
+	_, err := l.Archive()
+	return err
+}
+
+// 返回所有归档，旧的在前
+func (l *Log) ListArchives() ([]Archive, error) {
+	entries, err := os.ReadDir(l.archiveDir())
+	if os.IsNotExist(err) {
+		return []Archive{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]Archive, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		a, err := readArchive(filepath.Join(l.archiveDir(), e.Name()))
+		if err != nil {
+			// 不是由 Archive 创建的目录
+			continue
+		}
+		archives = append(archives, a)
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Name < archives[j].Name })
+	return archives, nil
+}
+
+// 用归档中的记录替换当前的所有记录
+// 当前的记录会先被归档，所以恢复操作本身也可以撤销
+// 返回保存当前记录的归档的名字
+func (l *Log) RestoreArchive(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("invalid archive name: %q", name))
+	}
+	dir := filepath.Join(l.archiveDir(), name)
+	if _, err := readArchive(dir); err != nil {
+		return "", status.Error(codes.NotFound, fmt.Sprintf("archive not found: %q", name))
+	}
+
+	defer l.startMaintenance()()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	replaced, err := l.archive()
+	if err == nil {
+		err = moveFiles(dir, l.Dir)
+	}
+	if err == nil {
+		err = os.Remove(dir)
+	}
+	if serr := l.setup(); err == nil {
+		err = serr
+	}
+	if err == nil {
+		l.logger.Info("restored archive", zap.String("archive", name), zap.String("replaced", replaced))
+	}
+	return replaced, err
+}
+
+// 调用者需要持有写锁，并且在返回后调用 setup 重新打开数据目录
+func (l *Log) archive() (string, error) {
+	for _, segment := range l.segments {
+		if err := segment.Close(); err != nil {
+			return "", err
+		}
+	}
+	l.segments = make([]*segment, 0)
+	l.activeSegment = nil
+
+	files, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return "", err
+	}
+	empty := true
+	for _, file := range files {
+		if info, err := file.Info(); err == nil && strings.HasSuffix(file.Name(), ".store") && info.Size() > 0 {
+			empty = false
+			break
+		}
+	}
+	// 只有空的 segment 时直接删除
+	if empty {
+		for _, file := range files {
+			if err := os.Remove(filepath.Join(l.Dir, file.Name())); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+
+	root := l.archiveDir()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format(archiveTimeFormat)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath.Join(root, name)); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", name[:len(archiveTimeFormat)], i)
+	}
+	dir := filepath.Join(root, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	return name, moveFiles(l.Dir, dir)
+}
+
+func moveFiles(from, to string) error {
+	files, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Rename(filepath.Join(from, file.Name()), filepath.Join(to, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除超过 MaxAge 的归档，只保留最新的 MaxCount 个归档，零表示不限制
+func (l *Log) purgeArchives() error {
+	c := l.Config.Archive
+	if c.MaxAge == 0 && c.MaxCount == 0 {
+		return nil
+	}
+	archives, err := l.ListArchives()
+	if err != nil {
+		return err
+	}
+	for i, a := range archives {
+		tooMany := c.MaxCount > 0 && i < len(archives)-c.MaxCount
+		tooOld := c.MaxAge > 0 && time.Since(a.CreatedAt) > c.MaxAge
+		if tooMany || tooOld {
+			if err := os.RemoveAll(filepath.Join(l.archiveDir(), a.Name)); err != nil {
+				return err
+			}
+			l.logger.Info(
+				"purged archive",
+				zap.String("archive", a.Name),
+				zap.Time("created_at", a.CreatedAt),
+				zap.Bool("too_many", tooMany),
+				zap.Bool("too_old", tooOld),
+			)
+		}
+	}
+	return nil
+}
+
+// 从文件名和文件大小得到归档的信息，不需要打开 segment
+// 关闭后的索引文件的大小就是索引项的总大小
+func readArchive(dir string) (Archive, error) {
+	name := filepath.Base(dir)
+	if len(name) < len(archiveTimeFormat) {
+		return Archive{}, fmt.Errorf("not an archive: %q", dir)
+	}
+	createdAt, err := time.Parse(archiveTimeFormat, name[:len(archiveTimeFormat)])
+	if err != nil {
+		return Archive{}, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	a := Archive{Name: name, CreatedAt: createdAt}
+	var highestBase uint64
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".store") {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ".store"), 10, 0)
+		if err != nil {
+			return Archive{}, err
+		}
+		info, err := file.Info()
+		if err != nil {
+			return Archive{}, err
+		}
+		if a.Segments == 0 || base < a.LowestOffset {
+			a.LowestOffset = base
+		}
+		if a.Segments == 0 || base > highestBase {
+			highestBase = base
+		}
+		a.Segments++
+		a.Bytes += uint64(info.Size())
+	}
+	if a.Segments == 0 {
+		return Archive{}, fmt.Errorf("empty archive: %q", dir)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%d%s", highestBase, ".index")))
+	if err != nil {
+		return Archive{}, err
+	}
+	// 最后一个 segment 可能是空的
+	a.HighestOffset = highestBase + uint64(info.Size())/entrySize
+	if a.HighestOffset > 0 {
+		a.HighestOffset--
+	}
+	return a, nil
+}
@@ -1,9 +1,32 @@
 package log
 
+import "time"
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64 // N * (averageRecordLength + 8)
 		MaxIndexBytes uint64 // N * 12
 		InitialOffset uint64
 	}
+
+	// Reset 时归档的位置和保留策略，参见 archive.go
+	// Dir 为空时使用和数据目录相邻的 <数据目录>.archive
+	// MaxAge 和 MaxCount 为零时不删除归档
+	Archive struct {
+		Dir      string
+		MaxAge   time.Duration
+		MaxCount int
+	}
+
+	// 每次追加后把存储文件和索引文件写到磁盘，默认只在读取和关闭时写入
+	// 同步之后记录的内容不会因为操作系统崩溃而丢失，但是索引文件在关闭时才截断为真实的长度，
+	// 没有正常关闭的日志重新打开时仍然无法恢复，所以这只是持久化的一部分
+	// 同步失败的追加返回错误，记录不会被读到，也不会在复制时发送给 follower
+	SyncOnAppend bool
+
+	// 为空时存储文件不加密，参见 crypt.go
+	MasterKeys *MasterKeys
+
+	// 为空时不记录指标，参见 metrics.go
+	Metrics Metrics
 }
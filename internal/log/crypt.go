@@ -0,0 +1,260 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 静态加密（信封加密）
+//
+// 每个 segment 都有自己随机生成的数据密钥，存储文件中的每条记录都用数据密钥以 AES-GCM 加密
+// 数据密钥再用主密钥加密（包装）后保存在和存储文件同名的 .key 文件中
+//
+// 主密钥保存在本地的密钥文件中，每行一个 <id>:<base64 编码的 32 字节密钥>
+// 最后一行是当前的主密钥，新建的 segment 使用它来包装数据密钥
+// 轮换主密钥时在文件末尾追加一行新的密钥，旧的密钥需要保留到使用它的 segment 都被删除为止
+//
+// 加密只改变存储文件中每条记录的内容，记录的长度前缀和索引中保存的位置不受影响
+
+// 主密钥和数据密钥的长度（AES-256）
+const dataKeySize = 32
+
+var (
+	errNoMasterKey      = errors.New("no master key found")
+	errInvalidMasterKey = fmt.Errorf("master key must be %d bytes encoded in base64", dataKeySize)
+	errUnknownMasterKey = errors.New("unknown master key")
+	errEncryptedSegment = errors.New("segment is encrypted but no master key file is configured")
+)
+
+type MasterKeys struct {
+	file string
+
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+func LoadMasterKeys(file string) (*MasterKeys, error) {
+	k := &MasterKeys{file: file}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// 重新读取密钥文件，轮换主密钥时不需要重启服务器
+func (k *MasterKeys) Reload() error {
+	f, err := os.Open(k.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys := make(map[string][]byte)
+	current := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok || id == "" {
+			return fmt.Errorf("%w: %q", errInvalidMasterKey, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != dataKeySize {
+			return fmt.Errorf("%w: key %q", errInvalidMasterKey, id)
+		}
+		keys[id] = key
+		current = id
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if current == "" {
+		return fmt.Errorf("%w in %q", errNoMasterKey, k.file)
+	}
+
+	k.mu.Lock()
+	k.keys, k.current = keys, current
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *MasterKeys) Files() []string {
+	return []string{k.file}
+}
+
+// 包装后的数据密钥，以 JSON 格式保存在 .key 文件中
+type wrappedKey struct {
+	MasterKeyID string `json:"master_key_id"`
+	Nonce       []byte `json:"nonce"`
+	Key         []byte `json:"key"`
+}
+
+// 为新的 segment 生成数据密钥并用当前的主密钥包装
+func (k *MasterKeys) newDataKey(baseAbsOffset uint64) ([]byte, *wrappedKey, error) {
+	k.mu.RLock()
+	id, master := k.current, k.keys[k.current]
+	k.mu.RUnlock()
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	w := &wrappedKey{
+		MasterKeyID: id,
+		Nonce:       nonce,
+		Key:         aead.Seal(nil, nonce, dataKey, wrapAAD(id, baseAbsOffset)),
+	}
+	return dataKey, w, nil
+}
+
+// 用包装时使用的主密钥解开数据密钥
+func (k *MasterKeys) unwrap(w *wrappedKey, baseAbsOffset uint64) ([]byte, error) {
+	k.mu.RLock()
+	master, ok := k.keys[w.MasterKeyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUnknownMasterKey, w.MasterKeyID)
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, w.Nonce, w.Key, wrapAAD(w.MasterKeyID, baseAbsOffset))
+	if err != nil {
+		return nil, status.Error(
+			codes.DataLoss,
+			fmt.Sprintf("failed to unwrap data key of segment %d: %v", baseAbsOffset, err),
+		)
+	}
+	return dataKey, nil
+}
+
+// 包装后的数据密钥只能用于原来的 segment
+func wrapAAD(id string, baseAbsOffset uint64) []byte {
+	return []byte(fmt.Sprintf("dcls segment %d key %s", baseAbsOffset, id))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 打开 segment 的数据密钥
+// 已有 .key 文件时解开其中的数据密钥，空的 segment 生成新的数据密钥
+// 启用加密之前写入的 segment 没有 .key 文件，它们继续以明文读取
+// 返回空的 AEAD 表示 segment 不加密
+func segmentCipher(dir string, baseAbsOffset uint64, storeSize uint64, keys *MasterKeys) (cipher.AEAD, error) {
+	keyFile := filepath.Join(dir, fmt.Sprintf("%d%s", baseAbsOffset, ".key"))
+	b, err := os.ReadFile(keyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	exists := err == nil
+
+	switch {
+	case keys == nil && exists:
+		return nil, fmt.Errorf("%w: %q", errEncryptedSegment, keyFile)
+	case keys == nil, !exists && storeSize > 0:
+		return nil, nil
+	}
+
+	var dataKey []byte
+	if exists {
+		var w wrappedKey
+		if err := json.Unmarshal(b, &w); err != nil {
+			return nil, status.Error(codes.DataLoss, fmt.Sprintf("corrupted key file %q: %v", keyFile, err))
+		}
+		if dataKey, err = keys.unwrap(&w, baseAbsOffset); err != nil {
+			return nil, err
+		}
+	} else {
+		var w *wrappedKey
+		if dataKey, w, err = keys.newDataKey(baseAbsOffset); err != nil {
+			return nil, err
+		}
+		if err := writeKeyFile(keyFile, w); err != nil {
+			return nil, err
+		}
+	}
+	return newAEAD(dataKey)
+}
+
+// 先写临时文件再重命名，避免留下不完整的 .key 文件
+func writeKeyFile(keyFile string, w *wrappedKey) error {
+	b, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	tmp := keyFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, keyFile)
+}
+
+// 加密后的记录是 nonce || 密文 || 认证标签
+// 记录的绝对下标作为附加数据，所以记录不能被移动到其他位置
+func encryptRecord(aead cipher.AEAD, absOff uint64, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, offsetAAD(absOff)), nil
+}
+
+// 密文被篡改时返回 DataLoss 错误
+func decryptRecord(aead cipher.AEAD, absOff uint64, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, status.Error(codes.DataLoss, fmt.Sprintf("encrypted record %d is truncated", absOff))
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, offsetAAD(absOff))
+	if err != nil {
+		return nil, status.Error(codes.DataLoss, fmt.Sprintf("failed to decrypt record %d: %v", absOff, err))
+	}
+	return plaintext, nil
+}
+
+func offsetAAD(absOff uint64) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, order, absOff)
+	return b.Bytes()
+}
+
+// 加密后每条记录增加的字节数
+func encryptionOverhead(aead cipher.AEAD) uint64 {
+	if aead == nil {
+		return 0
+	}
+	return uint64(aead.NonceSize() + aead.Overhead())
+}
@@ -0,0 +1,74 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// 健康检查时写入的探测文件，setup 只打开 .store 文件，所以不会把它当作 segment
+const healthProbeFile = ".health"
+
+var errMaintenance = errors.New("log is being opened, archived or restored")
+
+// 检查存储是否可以写入
+// 打开数据目录、归档（重置）和恢复归档期间直接返回错误，不等待这些操作释放锁
+// 否则在数据目录中写入并 fsync 一个探测文件，这样没有写入时也能发现磁盘的问题
+// 最近一次追加失败（比如磁盘已满）时还要把活跃的 segment 写回磁盘，成功后才认为存储已经恢复
+func (l *Log) Check() error {
+	if l.maintenance.Load() > 0 {
+		return errMaintenance
+	}
+	if err := l.probe(); err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	failed := l.appendErr != nil
+	l.mu.RUnlock()
+	if !failed {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.appendErr == nil {
+		return nil
+	}
+	if l.activeSegment == nil {
+		return fmt.Errorf("last append failed: %w", l.appendErr)
+	}
+	if err := l.activeSegment.Sync(); err != nil {
+		return fmt.Errorf("last append failed: %w", l.appendErr)
+	}
+	l.logger.Info("storage recovered", zap.NamedError("last_append_error", l.appendErr))
+	l.appendErr = nil
+	return nil
+}
+
+func (l *Log) probe() error {
+	name := filepath.Join(l.Dir, healthProbeFile)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(name)
+	if _, err := f.Write([]byte("ok")); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("fsync failed: %w", err)
+	}
+	return f.Close()
+}
+
+// 返回的函数在操作结束时调用
+func (l *Log) startMaintenance() func() {
+	l.maintenance.Add(1)
+	return func() { l.maintenance.Add(-1) }
+}
@@ -122,6 +122,12 @@ func (i *index) Write(relOff uint32, pos uint64) error {
 	return nil
 }
 
+// 将内存映射中修改过的内容写回磁盘
+// 文件的长度在关闭时才截断为真实写入的字节数，这里不修改文件的长度
+func (i *index) Sync() error {
+	return i.mmap.Sync(gommap.MS_SYNC)
+}
+
 func (i *index) Close() error {
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return err
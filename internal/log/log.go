@@ -1,15 +1,19 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/trace"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -30,6 +34,15 @@ type Log struct {
 	// 它总是 segments 的最后一个元素
 	// 当它写满时，我们新建一个 segment 并添加在 segments 的最后边
 	activeSegment *segment
+
+	// 最近一次追加的错误，参见 health.go
+	appendErr error
+
+	// 正在打开数据目录、归档或者恢复归档的操作数，健康检查不等待锁就报告错误
+	maintenance atomic.Int32
+
+	metrics Metrics
+	logger  *zap.Logger
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -43,8 +56,19 @@ func NewLog(dir string, c Config) (*Log, error) {
 		Dir:      dir,
 		Config:   c,
 		segments: make([]*segment, 0),
+		metrics:  c.Metrics,
+		logger:   zap.L().Named("log").With(zap.String("dir", dir)),
+	}
+	if l.metrics == nil {
+		l.metrics = nopMetrics{}
 	}
-	return l, l.setup()
+	done := l.startMaintenance()
+	err := l.setup()
+	done()
+	if err != nil {
+		return l, err
+	}
+	return l, l.purgeArchives()
 }
 
 func (l *Log) setup() error {
@@ -84,6 +108,15 @@ func (l *Log) setup() error {
 			return err
 		}
 	}
+	if len(baseAbsOffsets) > 0 {
+		l.metrics.Recovered(len(baseAbsOffsets))
+		l.logger.Info(
+			"recovered segments",
+			zap.Int("segments", len(baseAbsOffsets)),
+			zap.Uint64("lowest_offset", l.segments[0].baseAbsOffset),
+			zap.Uint64("next_offset", l.activeSegment.nextAbsOffset),
+		)
+	}
 
 	// 还没有 segment 则根据配置的 InitialOffset 值创建一个新的 segment 对象
 	if len(l.segments) == 0 {
@@ -102,28 +135,83 @@ func (l *Log) newSegment(baseAbsOffset uint64) error {
 	}
 	l.segments = append(l.segments, s)
 	l.activeSegment = s
+	l.metrics.Segments(len(l.segments))
+	l.metrics.ActiveSegmentFill(s.fill())
 	return nil
 }
 
+// activeSegment 写满后新建一个 segment
+func (l *Log) roll(baseAbsOffset uint64) error {
+	previous := l.activeSegment.baseAbsOffset
+	if err := l.newSegment(baseAbsOffset); err != nil {
+		return err
+	}
+	l.logger.Info("rolled segment", zap.Uint64("previous", previous), zap.Uint64("segment", baseAbsOffset))
+	return nil
+}
+
+// 向 activeSegment 追加记录并记录写入的字节数
+func (l *Log) appendActive(ctx context.Context, record *api.Record) (uint64, error) {
+	s := l.activeSegment
+	size := s.store.size
+	trace.FromContext(ctx).SetAttribute("segment", s.baseAbsOffset)
+	absOff, err := s.Append(ctx, record)
+	if err == nil {
+		l.metrics.Appended(s.store.size - size)
+		l.metrics.ActiveSegmentFill(s.fill())
+	}
+	return absOff, err
+}
+
 func (l *Log) Append(record *api.Record) (absOff uint64, err error) {
+	return l.AppendContext(context.Background(), record)
+}
+
+// 和 Append 相同，ctx 中有 span 时追加过程的 span 成为它的子 span
+func (l *Log) AppendContext(ctx context.Context, record *api.Record) (absOff uint64, err error) {
+	ctx, span := trace.Start(ctx, "log.Append")
+	defer func() {
+		span.SetAttribute("offset", absOff)
+		span.SetError(err)
+		span.End()
+	}()
+
+	start := time.Now()
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	// 记录太大不是存储的问题，不影响健康检查
+	defer func() {
+		if err != errRecordTooLarge {
+			l.appendErr = err
+		}
+	}()
+	wait := time.Since(start)
+	l.metrics.LockWait(wait)
+	span.SetAttribute("lock_wait_us", wait.Microseconds())
 
-	absOff, err = l.activeSegment.Append(record)
+	absOff, err = l.appendActive(ctx, record)
 	if err != nil {
 		if err != errNotEnoughSegmentSpace {
 			// 非空且非空间不足错误表明追加失败
 			return 0, err
+		} else if l.activeSegment.nextAbsOffset == l.activeSegment.baseAbsOffset {
+			// 空的 segment 也放不下的记录永远无法写入，新建 segment 只会留下更多空的 segment
+			return 0, errRecordTooLarge
 		} else {
 			// 日志或索引文件空间不足需要新建一个 segment 来进行写入
-			err = l.newSegment(absOff + 1)
+			err = l.roll(absOff + 1)
 			if err != nil {
 				return 0, err
 			}
 
-			absOff, err = l.activeSegment.Append(record)
+			span.SetAttribute("rolled", true)
+			absOff, err = l.appendActive(ctx, record)
 
 			// 新建 segment 后如果又发生非空错误表明追加失败
+			// 新的 segment 是空的，下次追加时继续使用它
+			if err == errNotEnoughSegmentSpace {
+				return 0, errRecordTooLarge
+			}
 			if err != nil {
 				return 0, err
 			}
@@ -134,7 +222,7 @@ func (l *Log) Append(record *api.Record) (absOff uint64, err error) {
 		// 注意：在这里无论新建 segment 是否成功都需要返回 absOff 而不是零
 		// 因为我们在旧的 segment 中已经成功添加了记录和索引
 		if l.activeSegment.IsMaxed() {
-			err = l.newSegment(absOff + 1)
+			err = l.roll(absOff + 1)
 			return absOff, err
 		}
 	}
@@ -143,8 +231,10 @@ func (l *Log) Append(record *api.Record) (absOff uint64, err error) {
 }
 
 func (l *Log) Read(absOff uint64) (record *api.Record, err error) {
+	start := time.Now()
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	l.metrics.LockWait(time.Since(start))
 
 	var s *segment
 	for _, segment := range l.segments {
@@ -161,22 +251,72 @@ func (l *Log) Read(absOff uint64) (record *api.Record, err error) {
 		)
 	}
 
-	return s.Read(absOff)
+	if record, err = s.Read(absOff); err == nil {
+		l.metrics.Read()
+	}
+	return record, err
 }
 
-func (l *Log) Close() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// 返回日志中第一条记录的绝对下标
+func (l *Log) LowestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-	for _, segment := range l.segments {
-		if err := segment.Close(); err != nil {
-			return err
-		}
+	return l.segments[0].baseAbsOffset, nil
+}
+
+// 返回日志中最后一条记录的绝对下标
+// 日志为空时返回的值比 LowestOffset 小一（但不会小于零）
+func (l *Log) HighestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	off := l.activeSegment.nextAbsOffset
+	if off == 0 {
+		return 0, nil
 	}
-	return nil
+	return off - 1, nil
+}
+
+// 返回下一条追加的记录的绝对下标
+// 它等于 LowestOffset 时日志为空
+func (l *Log) NextOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.activeSegment.nextAbsOffset, nil
+}
+
+// segment 的状态，StoreBytes 包括还在写缓冲区中的数据，IndexBytes 是索引项的总大小
+type SegmentInfo struct {
+	BaseOffset uint64 `json:"base_offset"`
+	NextOffset uint64 `json:"next_offset"`
+	StoreBytes uint64 `json:"store_bytes"`
+	IndexBytes uint64 `json:"index_bytes"`
+	Active     bool   `json:"active"`
+	Encrypted  bool   `json:"encrypted"`
+}
+
+// 返回所有 segment 的状态，旧的在前
+func (l *Log) Segments() []SegmentInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	infos := make([]SegmentInfo, 0, len(l.segments))
+	for _, s := range l.segments {
+		infos = append(infos, SegmentInfo{
+			BaseOffset: s.baseAbsOffset,
+			NextOffset: s.nextAbsOffset,
+			StoreBytes: s.store.size,
+			IndexBytes: s.index.size,
+			Active:     s == l.activeSegment,
+			Encrypted:  s.aead != nil,
+		})
+	}
+	return infos
 }
 
-func (l *Log) Reset() error {
+func (l *Log) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -185,21 +325,5 @@ func (l *Log) Reset() error {
 			return err
 		}
 	}
-
-	files, err := os.ReadDir(l.Dir)
-	if err != nil {
-		return err
-	}
-
-	// 删除日志存储目录中下所有文件
-	for _, file := range files {
-		if err := os.Remove(filepath.Join(l.Dir, file.Name())); err != nil {
-			return err
-		}
-	}
-
-	l.segments = make([]*segment, 0)
-	l.activeSegment = nil
-
-	return l.setup()
+	return nil
 }
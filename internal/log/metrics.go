@@ -0,0 +1,41 @@
+package log
+
+import (
+	"time"
+
+	"github.com/youngfr/dcls/internal/metrics"
+)
+
+// 存储层的指标
+type Metrics interface {
+	// 成功追加一条记录，bytes 是写入存储文件的字节数
+	Appended(bytes uint64)
+
+	// 成功读取一条记录
+	Read()
+
+	// 等待日志的锁的时间
+	LockWait(d time.Duration)
+
+	// 当前 segment 的数量
+	Segments(n int)
+
+	// activeSegment 的存储文件和索引文件中较满的那个的使用比例
+	ActiveSegmentFill(ratio float64)
+
+	// 打开数据目录时恢复了已有的 segment
+	Recovered(segments int)
+}
+
+// 在 metrics 包中的 *metrics.LogMetrics 实现了 Metrics 接口
+var _ Metrics = (*metrics.LogMetrics)(nil)
+
+// Config.Metrics 为空时不记录指标
+type nopMetrics struct{}
+
+func (nopMetrics) Appended(uint64)           {}
+func (nopMetrics) Read()                     {}
+func (nopMetrics) LockWait(time.Duration)    {}
+func (nopMetrics) Segments(int)              {}
+func (nopMetrics) ActiveSegmentFill(float64) {}
+func (nopMetrics) Recovered(int)             {}
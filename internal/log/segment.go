@@ -1,12 +1,15 @@
 package log
 
 import (
+	"context"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"os"
 	"path"
 
 	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/trace"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -21,6 +24,9 @@ type segment struct {
 	// 下一条要存储的记录的绝对下标
 	nextAbsOffset uint64
 
+	// 加密记录使用的数据密钥，不加密时为空
+	aead cipher.AEAD
+
 	config Config
 }
 
@@ -42,6 +48,10 @@ func newSegment(dir string, baseAbsOffset uint64, c Config) (s *segment, err err
 		storeFile.Close()
 		return nil, err
 	}
+	if s.aead, err = segmentCipher(dir, baseAbsOffset, s.store.size, c.MasterKeys); err != nil {
+		s.store.Close()
+		return nil, err
+	}
 
 	// 打开（创建）索引文件
 	indexFile, err := os.OpenFile(
@@ -71,11 +81,17 @@ func newSegment(dir string, baseAbsOffset uint64, c Config) (s *segment, err err
 
 var (
 	errNotEnoughSegmentSpace = errors.New("current segment has not enough space to append a new store or index entry")
+	errRecordTooLarge        = errors.New("record is larger than the maximum segment size")
 )
 
-func (s *segment) Append(record *api.Record) (absOff uint64, err error) {
-	if s.store.size+uint64(proto.Size(record)) > s.config.Segment.MaxStoreBytes ||
+func (s *segment) Append(ctx context.Context, record *api.Record) (absOff uint64, err error) {
+	ctx, span := trace.Start(ctx, "segment.Append")
+	defer span.End()
+	span.SetAttribute("segment", s.baseAbsOffset)
+
+	if s.store.size+uint64(proto.Size(record))+encryptionOverhead(s.aead) > s.config.Segment.MaxStoreBytes ||
 		s.index.size+uint64(entrySize) > s.config.Segment.MaxIndexBytes {
+		span.SetAttribute("full", true)
 		return s.nextAbsOffset - 1, errNotEnoughSegmentSpace
 	}
 
@@ -87,21 +103,80 @@ func (s *segment) Append(record *api.Record) (absOff uint64, err error) {
 	if err != nil {
 		return 0, err
 	}
+	if s.aead != nil {
+		if b, err = encryptRecord(s.aead, record.Offset, b); err != nil {
+			return 0, err
+		}
+	}
 
 	// 写入存储文件
+	// 存储文件使用缓冲 I/O，默认追加时不调用 fsync，数据在读取或者关闭时才写到文件中
+	// 设置了 SyncOnAppend 时存储文件同步成功之后才写入索引，参见 Config
+	_, storeSpan := trace.Start(ctx, "store.Append")
 	n, pos, err := s.store.Append(b)
+	storeSpan.SetAttribute("bytes", n)
+	storeSpan.SetAttribute("position", pos)
+	storeSpan.SetError(err)
+	storeSpan.End()
 	if n != uint64(len(b)+lenSize) || err != nil {
+		span.SetError(err)
 		return 0, err
 	}
+	// 同步失败的记录没有索引项，不会被读到，它的下标留给之后的记录
+	// 丢弃存储文件中这条记录的内容，否则缓冲区不再接受写入
+	if s.config.SyncOnAppend {
+		if err := s.sync(ctx, "store.Sync", s.store.Sync); err != nil {
+			if terr := s.store.truncate(pos); terr != nil {
+				err = fmt.Errorf("%w (failed to discard the record: %v)", err, terr)
+			}
+			span.SetError(err)
+			return 0, err
+		}
+	}
 
 	// 将相对下标和它在存储文件中的位置写入索引文件
-	s.index.Write(uint32(s.nextAbsOffset-s.baseAbsOffset), pos)
+	// 索引文件是内存映射的，写入只修改内存，由操作系统决定何时写回磁盘
+	_, indexSpan := trace.Start(ctx, "index.Write")
+	relOff := uint32(s.nextAbsOffset - s.baseAbsOffset)
+	err = s.index.Write(relOff, pos)
+	indexSpan.SetAttribute("relative_offset", relOff)
+	indexSpan.SetError(err)
+	indexSpan.End()
+	if err != nil {
+		span.SetError(err)
+		return 0, err
+	}
+	// 索引同步失败时撤销这个索引项，记录同样不会被读到
+	if s.config.SyncOnAppend {
+		if err := s.sync(ctx, "index.Sync", s.index.Sync); err != nil {
+			s.index.size -= entrySize
+			span.SetError(err)
+			return 0, err
+		}
+	}
 
 	s.nextAbsOffset++
 
+	span.SetAttribute("offset", record.Offset)
 	return record.Offset, nil
 }
 
+func (s *segment) sync(ctx context.Context, name string, sync func() error) error {
+	_, span := trace.Start(ctx, name)
+	err := sync()
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+// 把存储文件的缓冲区和索引文件的内存映射写到磁盘
+func (s *segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
 func (s *segment) Read(absOff uint64) (record *api.Record, err error) {
 	// 先根据相对下标读取索引文件
 	// 获取记录在存储文件中的位置
@@ -116,6 +191,11 @@ func (s *segment) Read(absOff uint64) (record *api.Record, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if s.aead != nil {
+		if b, err = decryptRecord(s.aead, absOff, b); err != nil {
+			return nil, err
+		}
+	}
 
 	// 反序列化
 	record = &api.Record{}
@@ -136,6 +216,14 @@ func (s *segment) Close() error {
 	return nil
 }
 
+// 存储文件和索引文件中较满的那个的使用比例
+func (s *segment) fill() float64 {
+	return max(
+		float64(s.store.size)/float64(s.config.Segment.MaxStoreBytes),
+		float64(s.index.size)/float64(s.config.Segment.MaxIndexBytes),
+	)
+}
+
 func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes ||
 		s.index.size >= s.config.Segment.MaxIndexBytes
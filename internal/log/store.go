@@ -85,6 +85,29 @@ func (s *store) Read(pos uint64) ([]byte, error) {
 	return b, nil
 }
 
+// 将缓冲区中的内容写到文件中并调用 fsync
+func (s *store) Sync() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.File.Sync()
+}
+
+// 丢弃从第 pos 个字节开始的内容，包括缓冲区中还没有写入文件的数据
+// 缓冲区写入失败之后不再接受写入，丢弃之后才能继续追加
+// 截断失败时文件末尾可能留下一部分记录，之后的记录写在它们后面
+func (s *store) truncate(pos uint64) error {
+	s.buf.Reset(s.File)
+	if err := s.File.Truncate(int64(pos)); err != nil {
+		if finfo, serr := s.File.Stat(); serr == nil {
+			s.size = uint64(finfo.Size())
+		}
+		return err
+	}
+	s.size = pos
+	return nil
+}
+
 // 将存储的日志写入磁盘并关闭相应的文件
 func (s *store) Close() error {
 	if err := s.buf.Flush(); err != nil {
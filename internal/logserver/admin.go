@@ -0,0 +1,29 @@
+package logserver
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// 管理服务器使用和 gRPC 服务器相同的认证方式和访问控制策略
+// HTTP 请求的 TLS 连接状态和 Authorization header 被转换为 gRPC 的 peer 和 metadata
+// 这样 MTLSAuthenticator 和令牌认证都可以直接使用
+func AuthorizeHTTP(authn Authenticator, authz Authorizer) func(r *http.Request, object, action string) error {
+	return func(r *http.Request, object, action string) error {
+		ctx := r.Context()
+		if r.TLS != nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: *r.TLS}})
+		}
+		if h := r.Header.Get("Authorization"); h != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", h))
+		}
+		subject, err := authn.Authenticate(ctx)
+		if err != nil {
+			return err
+		}
+		return authz.Authorize(subject, object, action)
+	}
+}
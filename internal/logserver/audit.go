@@ -0,0 +1,110 @@
+package logserver
+
+import (
+	"context"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/audit"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 审计接口
+// 每个请求结束后调用 Record 记录谁在什么时候对什么执行了什么操作
+type Auditor interface {
+	Record(audit.Entry) error
+	Verify() (audit.VerifyResult, error)
+}
+
+// 在 audit 包中的 *audit.Log 实现了 Auditor 接口
+var _ Auditor = (*audit.Log)(nil)
+
+const auditObject = "audit"
+
+var (
+	errNoAuditorUsed = status.New(codes.FailedPrecondition, "no audit log being used").Err()
+	errAuditFailed   = status.New(codes.Unavailable, "failed to write audit log").Err()
+)
+
+// 审计拦截器需要在认证拦截器之前执行，这样认证失败的请求也会被记录
+// 请求的信息在处理请求的过程中填写，参见 request.go
+// 写审计日志失败时请求返回 Unavailable，不返回响应，这样无法通过让审计失败来绕过审计
+// 请求已经产生的修改（比如追加的记录）不会被撤销
+func auditInterceptor(a Auditor) grpc.UnaryServerInterceptor {
+	logger := zap.L().Named("audit")
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		resp, err := handler(ctx, req)
+		ri := requestInfoFrom(ctx)
+
+		e := audit.Entry{
+			Time:      time.Now(),
+			Subject:   ri.subject,
+			Method:    info.FullMethod,
+			RequestID: ri.requestID,
+			Action:    ri.action,
+			Object:    ri.object,
+			Offsets:   ri.offsets,
+			Result:    status.Code(err).String(),
+		}
+		if err != nil {
+			e.Error = status.Convert(err).Message()
+		}
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			e.Peer = p.Addr.String()
+		}
+		if err := a.Record(e); err != nil {
+			logger.Error("failed to write audit log", zap.String("method", info.FullMethod), zap.Error(err))
+			return nil, errAuditFailed
+		}
+		return resp, err
+	}
+}
+
+// 记录请求涉及的日志下标范围
+func auditOffsets(ctx context.Context, first, last uint64) {
+	requestInfoFrom(ctx).offsets = &audit.OffsetRange{First: first, Last: last}
+}
+
+// 访问控制检查，同时记录检查的操作和对象
+func (s *gRPCServer) authorize(ctx context.Context, object, action string) error {
+	ri := requestInfoFrom(ctx)
+	ri.object, ri.action = object, action
+	return s.Authorizer.Authorize(subject(ctx), object, action)
+}
+
+// 还不知道具体的对象时，审计日志中的对象是 *
+func (s *gRPCServer) authorizeAny(ctx context.Context, action string) error {
+	ri := requestInfoFrom(ctx)
+	ri.object, ri.action = everyObject, action
+	return s.Authorizer.AuthorizeAny(subject(ctx), action)
+}
+
+func (s *gRPCServer) VerifyAuditLog(ctx context.Context, req *api.VerifyAuditLogRequest) (*api.VerifyAuditLogResponse, error) {
+	if s.Authorizer == nil {
+		return nil, errNoAuthorizationUsed
+	}
+	// 和管理策略一样只有拥有 admin 权限的用户可以验证审计日志
+	if err := s.authorize(ctx, auditObject, adminAction); err != nil {
+		return nil, err
+	}
+	if s.Auditor == nil {
+		return nil, errNoAuditorUsed
+	}
+	result, err := s.Auditor.Verify()
+	resp := &api.VerifyAuditLogResponse{
+		Entries:  result.Entries,
+		HeadHash: result.Head,
+		Valid:    err == nil,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp, nil
+}
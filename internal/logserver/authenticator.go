@@ -0,0 +1,204 @@
+package logserver
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/youngfr/dcls/internal/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 认证接口
+// 无论使用客户端证书还是令牌来认证用户
+// 都需要实现 Authenticate 方法返回用户的身份
+type Authenticator interface {
+	Authenticate(ctx context.Context) (string, error)
+}
+
+// Authenticator 可以选择实现的接口
+// 请求中带有这种认证方式的凭据（即使凭据无效）时返回真
+// ChainAuthenticator 认证失败时优先返回提供了凭据的认证方式的错误
+type CredentialsChecker interface {
+	HasCredentials(ctx context.Context) bool
+}
+
+var (
+	_ Authenticator      = MTLSAuthenticator{}
+	_ CredentialsChecker = MTLSAuthenticator{}
+	_ Authenticator      = ChainAuthenticator{}
+
+	// 在 auth 包中的 *auth.TokenAuthenticator 实现了 Authenticator 和 CredentialsChecker 接口
+	_ Authenticator      = (*auth.TokenAuthenticator)(nil)
+	_ CredentialsChecker = (*auth.TokenAuthenticator)(nil)
+)
+
+// 从客户端证书中提取用户身份的方式
+// 提取出的身份作为访问控制的主体
+type Identity int
+
+const (
+	// 证书主题的 CommonName，这是默认的方式
+	CommonNameIdentity Identity = iota
+
+	// 第一个 DNS 类型的 SAN
+	DNSNameIdentity
+
+	// 第一个 URI 类型的 SAN，比如 SPIFFE ID spiffe://corp/ns/orders/sa/writer
+	URIIdentity
+
+	// 第一个电子邮件类型的 SAN
+	EmailIdentity
+)
+
+var identityNames = map[Identity]string{
+	CommonNameIdentity: "cn",
+	DNSNameIdentity:    "dns",
+	URIIdentity:        "uri",
+	EmailIdentity:      "email",
+}
+
+func (i Identity) String() string {
+	if name, ok := identityNames[i]; ok {
+		return name
+	}
+	return fmt.Sprintf("Identity(%d)", int(i))
+}
+
+// 参数 name 可以是 cn、dns、uri（或 spiffe）和 email
+func ParseIdentity(name string) (Identity, error) {
+	if name == "spiffe" {
+		return URIIdentity, nil
+	}
+	for i, n := range identityNames {
+		if n == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown identity %q, expected one of cn, dns, uri, spiffe and email", name)
+}
+
+// 从证书中提取身份，证书中没有对应的字段时返回空字符串
+func (i Identity) extract(cert *x509.Certificate) string {
+	switch i {
+	case DNSNameIdentity:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case URIIdentity:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case EmailIdentity:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// 使用经过验证的客户端证书认证用户，Identity 决定使用证书的哪个字段作为身份
+// 任何无法确定身份的情况都返回 Unauthenticated
+type MTLSAuthenticator struct {
+	Identity Identity
+}
+
+func (a MTLSAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	peer, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.New(
+			codes.Unauthenticated,
+			"couldn't find peer info",
+		).Err()
+	}
+
+	tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return "", status.New(
+			codes.Unauthenticated,
+			"no transport security being used",
+		).Err()
+	}
+
+	// 服务端没有要求验证客户端证书时 VerifiedChains 为空
+	if len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", status.New(
+			codes.Unauthenticated,
+			"no verified client certificate",
+		).Err()
+	}
+
+	subject := a.Identity.extract(tlsInfo.State.VerifiedChains[0][0])
+	if subject == "" {
+		return "", status.New(
+			codes.Unauthenticated,
+			fmt.Sprintf("client certificate has no %s identity", a.Identity),
+		).Err()
+	}
+	return subject, nil
+}
+
+// 提供了经过验证的客户端证书
+func (a MTLSAuthenticator) HasCredentials(ctx context.Context) bool {
+	peer, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo)
+	return ok && len(tlsInfo.State.VerifiedChains) > 0
+}
+
+// 依次尝试多种认证方式，使用第一个认证成功的结果
+// 比如同时接受客户端证书和令牌
+//
+// 都失败时返回第一个提供了凭据的认证方式的错误（比如令牌过期），而不是没有提供客户端证书
+// 没有提供任何凭据时返回第一个错误
+type ChainAuthenticator []Authenticator
+
+func (c ChainAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	var first, presented error
+	for _, a := range c {
+		subject, err := a.Authenticate(ctx)
+		if err == nil {
+			return subject, nil
+		}
+		if first == nil {
+			first = err
+		}
+		if checker, ok := a.(CredentialsChecker); ok && presented == nil && checker.HasCredentials(ctx) {
+			presented = err
+		}
+	}
+	if presented != nil {
+		return "", presented
+	}
+	if first == nil {
+		first = status.New(codes.Unauthenticated, "no authenticator being used").Err()
+	}
+	return "", first
+}
+
+// 把认证得到的身份保存在 context 中，之后作为访问控制的主体
+func authenticate(a Authenticator) grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		subject, err := a.Authenticate(ctx)
+		if err != nil {
+			return ctx, err
+		}
+		requestInfoFrom(ctx).subject = subject
+		return context.WithValue(ctx, subjectContextKey{}, subject), nil
+	}
+}
+
+func subject(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectContextKey{}).(string)
+	return subject
+}
+
+type subjectContextKey struct{}
@@ -1,58 +1,48 @@
 package logserver
 
-import (
-	"context"
-
-	"github.com/youngfr/dcls/internal/auth"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/peer"
-	"google.golang.org/grpc/status"
-)
+import "github.com/youngfr/dcls/internal/auth"
 
 // 访问控制接口
 // 无论是否使用 casbin 库来做访问控制
 // 都需要实现 Authorize 方法
 type Authorizer interface {
 	Authorize(subject, object, action string) error
+
+	// 可以对至少一个对象执行 action 操作时返回空
+	AuthorizeAny(subject, action string) error
 }
 
 // 在 auth 包中的 *auth.Authorizer 实现了 Authorizer 接口
 var _ Authorizer = (*auth.Authorizer)(nil)
 
-// actions
+// objects
+// 带有主题的记录的对象是主题名，策略中可以使用通配符匹配一组主题
 const (
-	objects      = "all logs"
-	appendAction = "append"
-	readAction   = "read"
-	resetAction  = "reset"
+	objects       = "all logs"
+	clusterObject = "cluster"
+	policyObject  = "policies"
 )
 
-func authenticate(ctx context.Context) (context.Context, error) {
-	peer, ok := peer.FromContext(ctx)
-	if !ok {
-		return ctx, status.New(
-			codes.Unknown,
-			"couldn't find peer info",
-		).Err()
-	}
-
-	if peer.AuthInfo == nil {
-		return ctx, status.New(
-			codes.Unauthenticated,
-			"no transport security being used",
-		).Err()
-	}
-
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
-	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
+// 使用服务器反射需要对 reflection 的 admin 权限
+const reflectionObject = "reflection"
 
-	return ctx, nil
-}
+// 策略中匹配所有对象的通配符，可以对它执行的操作可以对任何对象执行
+const everyObject = "*"
 
-func subject(ctx context.Context) string {
-	return ctx.Value(subjectContextKey{}).(string)
+// 没有主题的记录仍然使用 all logs 作为对象
+func topicObject(topic string) string {
+	if topic == "" {
+		return objects
+	}
+	return topic
 }
 
-type subjectContextKey struct{}
+// actions
+const (
+	appendAction  = "append"
+	readAction    = "read"
+	resetAction   = "reset"
+	keyringAction = "keyring"
+	queryAction   = "query"
+	adminAction   = "admin"
+)
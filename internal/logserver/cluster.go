@@ -0,0 +1,63 @@
+package logserver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/discovery"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 在集群中所有节点上执行命令需要实现的接口
+type Cluster interface {
+	Query(name string, payload []byte, timeout time.Duration) ([]discovery.CommandResponse, error)
+}
+
+// 在 discovery 包中的 *discovery.Membership 实现了 Cluster 接口
+var _ Cluster = (*discovery.Membership)(nil)
+
+// 客户端设置的等待时间的上限，防止一个请求长时间占用 serf 查询
+const maxClusterQueryTimeout = 30 * time.Second
+
+func (s *gRPCServer) ClusterQuery(ctx context.Context, req *api.ClusterQueryRequest) (*api.ClusterQueryResponse, error) {
+	if s.Authorizer == nil {
+		return nil, errNoAuthorizationUsed
+	}
+	// 只有超级用户可以在集群中执行命令
+	if err := s.authorize(ctx, clusterObject, queryAction); err != nil {
+		return nil, err
+	}
+	if s.Cluster == nil {
+		return nil, errNoClusterUsed
+	}
+	if req.Name == "" {
+		return nil, status.New(codes.InvalidArgument, "command name is empty").Err()
+	}
+	// 只能执行只读或者幂等的命令，内部命令（比如复制策略修改）有自己的权限检查
+	if !slices.Contains(s.ClusterCommands, req.Name) {
+		return nil, status.New(
+			codes.PermissionDenied,
+			fmt.Sprintf("command %q cannot be run through ClusterQuery", req.Name),
+		).Err()
+	}
+
+	timeout := min(time.Duration(req.TimeoutMs)*time.Millisecond, maxClusterQueryTimeout)
+	responses, err := s.Cluster.Query(req.Name, req.Payload, timeout)
+	if err != nil {
+		return nil, status.New(codes.Unavailable, err.Error()).Err()
+	}
+
+	rsp := &api.ClusterQueryResponse{}
+	for _, r := range responses {
+		nodeRsp := &api.NodeResponse{Node: r.Node, Payload: r.Payload}
+		if r.Err != nil {
+			nodeRsp.Error = r.Err.Error()
+		}
+		rsp.Responses = append(rsp.Responses, nodeRsp)
+	}
+	return rsp, nil
+}
@@ -1,6 +1,8 @@
 package logserver
 
 import (
+	"context"
+
 	api "github.com/youngfr/dcls/api/v1"
 	"github.com/youngfr/dcls/internal/log"
 )
@@ -8,7 +10,7 @@ import (
 // 这里的 CommitLog 是一个通用的日志存储结构需要实现的接口
 // 这意味着我们在服务端真正使用的日志存储结构可以
 // 不使用 internal/log 目录下的实现的 Log 结构体
-// 而是只要实现这三种方法即可
+// 而是只要实现这些方法即可
 type CommitLog interface {
 
 	// 将一条日志追加到日志存储结构中
@@ -21,7 +23,31 @@ type CommitLog interface {
 
 	// 删除当前日志存储结构中的所有日志
 	Reset() error
+
+	// 第一条和最后一条日志的下标
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+
+	// 下一条日志的下标，等于 LowestOffset 时日志为空
+	NextOffset() (uint64, error)
 }
 
 // 在 log 包中的 *log.Log 实现了 CommitLog 接口
 var _ CommitLog = (*log.Log)(nil)
+
+// CommitLog 可以选择实现的接口
+// 实现了它时 Append 把请求的 context 传给日志存储结构
+// 这样存储层的 span 和请求的 span 属于同一个 trace
+type ContextAppender interface {
+	AppendContext(context.Context, *api.Record) (uint64, error)
+}
+
+// 在 log 包中的 *log.Log 实现了 ContextAppender 接口
+var _ ContextAppender = (*log.Log)(nil)
+
+func appendContext(ctx context.Context, l CommitLog, record *api.Record) (uint64, error) {
+	if a, ok := l.(ContextAppender); ok {
+		return a.AppendContext(ctx, record)
+	}
+	return l.Append(record)
+}
@@ -0,0 +1,190 @@
+package logserver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/controller"
+	"github.com/youngfr/dcls/internal/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// 存储层可以选择实现的健康检查接口
+// 返回错误时健康检查服务报告 NOT_SERVING
+type HealthChecker interface {
+	Check() error
+}
+
+// 在 log 包中的 *log.Log 实现了 HealthChecker 接口
+var _ HealthChecker = (*log.Log)(nil)
+
+// 存储检查的结果在这段时间内重复使用，Watch 也以这个间隔检查状态的变化
+const defaultHealthInterval = time.Second
+
+var errUnknownService = status.New(codes.NotFound, "unknown service").Err()
+
+// 标准的 grpc.health.v1.Health 服务
+//
+// 服务名为空或者 log.v1.Log 时表示整个服务器，log.v1.Log/<主题名> 表示一个主题
+// Start 之前（服务器还在启动）和 Shutdown 之后（服务器正在关闭）所有服务都是 NOT_SERVING
+// 存储检查失败时所有服务都是 NOT_SERVING，*log.Log 在重置和恢复归档期间的检查也会失败
+// 分配结果不只是建议时（参见 controller.Topic）主题还需要每个分区都有 leader 才是 SERVING
+//
+// 负载均衡器需要在没有用户身份的情况下检查健康状态，所以健康检查不需要认证
+type Health struct {
+	healthpb.UnimplementedHealthServer
+
+	storage  HealthChecker
+	topics   TopicDescriber
+	interval time.Duration
+	logger   *zap.Logger
+
+	mu        sync.Mutex
+	state     healthState
+	checkedAt time.Time
+	storeErr  error
+}
+
+type healthState int
+
+const (
+	healthStarting healthState = iota
+	healthServing
+	healthShutdown
+)
+
+// storage 和 topics 可以为空，这时不检查存储或者没有主题
+func NewHealth(storage HealthChecker, topics TopicDescriber) *Health {
+	return &Health{
+		storage:  storage,
+		topics:   topics,
+		interval: defaultHealthInterval,
+		logger:   zap.L().Named("health"),
+	}
+}
+
+// 服务器启动完成后调用
+func (h *Health) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == healthStarting {
+		h.state = healthServing
+	}
+}
+
+// 服务器开始关闭时调用，之后所有服务都是 NOT_SERVING
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = healthShutdown
+}
+
+// 不需要认证，参见 grpc_auth.ServiceAuthFuncOverride
+func (h *Health) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *Health) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s, err := h.status(req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: s}, nil
+}
+
+// 状态变化时发送新的状态
+// 未知的服务报告 SERVICE_UNKNOWN，之后主题被创建时会变为实际的状态
+func (h *Health) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		s, err := h.status(req.Service)
+		if err != nil {
+			s = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if s != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: s}); err != nil {
+				return err
+			}
+			last = s
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Health) status(service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	serviceName := api.Log_ServiceDesc.ServiceName
+	topic, isTopic := strings.CutPrefix(service, serviceName+"/")
+	if service != "" && service != serviceName && !isTopic {
+		return 0, errUnknownService
+	}
+	if isTopic {
+		t, err := h.describeTopic(topic)
+		if err != nil {
+			return 0, err
+		}
+		if !h.serving() {
+			return healthpb.HealthCheckResponse_NOT_SERVING, nil
+		}
+		// 建议的分配结果不影响读写，所有主题都由本节点的日志处理
+		for _, p := range t.Partitions {
+			if !t.Advisory && p.Leader == "" {
+				return healthpb.HealthCheckResponse_NOT_SERVING, nil
+			}
+		}
+		return healthpb.HealthCheckResponse_SERVING, nil
+	}
+	if !h.serving() {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
+
+func (h *Health) describeTopic(name string) (*controller.Topic, error) {
+	if h.topics == nil {
+		return nil, errUnknownService
+	}
+	t, err := h.topics.DescribeTopic(name)
+	if err != nil {
+		return nil, errUnknownService
+	}
+	return t, nil
+}
+
+// 服务器已经启动并且存储可以写入
+func (h *Health) serving() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state != healthServing {
+		return false
+	}
+	if h.storage == nil {
+		return true
+	}
+	if time.Since(h.checkedAt) >= h.interval {
+		err := h.storage.Check()
+		if err != nil && h.storeErr == nil {
+			h.logger.Error("storage became unhealthy", zap.Error(err))
+		} else if err == nil && h.storeErr != nil {
+			h.logger.Info("storage became healthy")
+		}
+		h.storeErr, h.checkedAt = err, time.Now()
+	}
+	return h.storeErr == nil
+}
+
+// 健康检查很频繁，不记录审计日志，也不受限额限制
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
@@ -0,0 +1,88 @@
+package logserver
+
+import (
+	"context"
+
+	"github.com/hashicorp/serf/serf"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/discovery"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 管理集群 gossip 加密密钥环需要实现的接口
+// 参数 key 都是 base64 编码的密钥
+type Keyring interface {
+	ListKeys() (*serf.KeyResponse, error)
+	InstallKey(key string) (*serf.KeyResponse, error)
+	UseKey(key string) (*serf.KeyResponse, error)
+	RemoveKey(key string) (*serf.KeyResponse, error)
+}
+
+// 在 discovery 包中的 *discovery.Membership 实现了 Keyring 接口
+var _ Keyring = (*discovery.Membership)(nil)
+
+func (s *gRPCServer) ListKeys(ctx context.Context, req *api.ListKeysRequest) (*api.KeyringResponse, error) {
+	if err := s.authorizeKeyring(ctx); err != nil {
+		return nil, err
+	}
+	return keyringResponse(s.Keyring.ListKeys())
+}
+
+func (s *gRPCServer) InstallKey(ctx context.Context, req *api.KeyringRequest) (*api.KeyringResponse, error) {
+	if err := s.authorizeKeyring(ctx); err != nil {
+		return nil, err
+	}
+	return keyringResponse(s.Keyring.InstallKey(req.Key))
+}
+
+func (s *gRPCServer) UseKey(ctx context.Context, req *api.KeyringRequest) (*api.KeyringResponse, error) {
+	if err := s.authorizeKeyring(ctx); err != nil {
+		return nil, err
+	}
+	return keyringResponse(s.Keyring.UseKey(req.Key))
+}
+
+func (s *gRPCServer) RemoveKey(ctx context.Context, req *api.KeyringRequest) (*api.KeyringResponse, error) {
+	if err := s.authorizeKeyring(ctx); err != nil {
+		return nil, err
+	}
+	return keyringResponse(s.Keyring.RemoveKey(req.Key))
+}
+
+// 只有超级用户可以管理密钥环
+func (s *gRPCServer) authorizeKeyring(ctx context.Context) error {
+	if s.Authorizer == nil {
+		return errNoAuthorizationUsed
+	}
+	if err := s.authorize(ctx, clusterObject, keyringAction); err != nil {
+		return err
+	}
+	if s.Keyring == nil {
+		return errNoClusterUsed
+	}
+	return nil
+}
+
+// 部分节点操作失败时 serf 也会返回错误
+// 这时仍然返回响应，客户端可以从 Messages 中看到每个节点的出错信息
+func keyringResponse(rsp *serf.KeyResponse, err error) (*api.KeyringResponse, error) {
+	if rsp == nil {
+		return nil, status.New(codes.FailedPrecondition, err.Error()).Err()
+	}
+	res := &api.KeyringResponse{
+		NumNodes:    int32(rsp.NumNodes),
+		NumResp:     int32(rsp.NumResp),
+		NumErr:      int32(rsp.NumErr),
+		Messages:    rsp.Messages,
+		Keys:        make(map[string]int32),
+		PrimaryKeys: make(map[string]int32),
+	}
+	for key, n := range rsp.Keys {
+		res.Keys[key] = int32(n)
+	}
+	for key, n := range rsp.PrimaryKeys {
+		res.PrimaryKeys[key] = int32(n)
+	}
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package logserver
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 请求日志
+// 每个请求结束后记录一条日志，需要在认证拦截器之前执行，这样认证失败的请求也会被记录
+// 成功和客户端造成的错误使用 Info 级别，服务器端的错误使用 Error 级别
+// 成功的健康检查很频繁，使用 Debug 级别
+
+func loggingInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logRequest(ctx, logger, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func loggingStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logRequest(ss.Context(), logger, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logRequest(ctx context.Context, logger *zap.Logger, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := zapcore.InfoLevel
+	switch code {
+	case codes.OK:
+		if isHealthCheck(method) {
+			level = zapcore.DebugLevel
+		}
+	case codes.Unknown, codes.Internal, codes.DataLoss:
+		level = zapcore.ErrorLevel
+	}
+	ce := logger.Check(level, "handled request")
+	if ce == nil {
+		return
+	}
+
+	ri := requestInfoFrom(ctx)
+	fields := []zap.Field{
+		zap.String("request_id", ri.requestID),
+		zap.String("method", method),
+		zap.String("subject", ri.subject),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("code", code.String()),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if err != nil {
+		fields = append(fields, zap.String("error", status.Convert(err).Message()))
+	}
+	ce.Write(fields...)
+}
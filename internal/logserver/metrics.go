@@ -0,0 +1,30 @@
+package logserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/youngfr/dcls/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// RPC 的指标
+// 每个请求结束后调用 Observe 记录请求的方法、状态码、用户和处理时间
+type RPCMetrics interface {
+	Observe(method, code, subject string, d time.Duration)
+}
+
+// 在 metrics 包中的 *metrics.RPCMetrics 实现了 RPCMetrics 接口
+var _ RPCMetrics = (*metrics.RPCMetrics)(nil)
+
+// 和审计拦截器一样需要在认证拦截器之前执行，这样认证失败的请求也会被统计
+// 认证失败的请求的用户为空
+func metricsInterceptor(m RPCMetrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.Observe(info.FullMethod, status.Code(err).String(), requestInfoFrom(ctx).subject, time.Since(start))
+		return resp, err
+	}
+}
@@ -0,0 +1,65 @@
+package logserver
+
+import (
+	"context"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/mirror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 保存其他集群镜像到本集群的进度需要实现的接口
+type MirrorCheckpoints interface {
+	// 没有保存过 source 的进度时 ok 为假
+	Checkpoint(source string) (next uint64, ok bool, err error)
+	SaveCheckpoint(source string, next uint64) error
+}
+
+// 在 mirror 包中的 *mirror.FileCheckpoints 实现了 MirrorCheckpoints 接口
+var _ MirrorCheckpoints = (*mirror.FileCheckpoints)(nil)
+
+// 镜像进度的对象，读取需要 read 权限，保存需要 append 权限
+const mirrorObject = "mirror"
+
+var (
+	errNoMirrorCheckpointsUsed = status.New(codes.FailedPrecondition, "no mirror checkpoints being used").Err()
+	errNoSourceCluster         = status.New(codes.InvalidArgument, "no source cluster provided").Err()
+)
+
+func (s *gRPCServer) GetMirrorCheckpoint(ctx context.Context, req *api.GetMirrorCheckpointRequest) (*api.GetMirrorCheckpointResponse, error) {
+	if err := s.authorizeMirror(ctx, req.SourceCluster, readAction); err != nil {
+		return nil, err
+	}
+	next, ok, err := s.Checkpoints.Checkpoint(req.SourceCluster)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetMirrorCheckpointResponse{Found: ok, NextOffset: next}, nil
+}
+
+func (s *gRPCServer) SetMirrorCheckpoint(ctx context.Context, req *api.SetMirrorCheckpointRequest) (*api.SetMirrorCheckpointResponse, error) {
+	if err := s.authorizeMirror(ctx, req.SourceCluster, appendAction); err != nil {
+		return nil, err
+	}
+	if err := s.Checkpoints.SaveCheckpoint(req.SourceCluster, req.NextOffset); err != nil {
+		return nil, err
+	}
+	return &api.SetMirrorCheckpointResponse{}, nil
+}
+
+func (s *gRPCServer) authorizeMirror(ctx context.Context, source, action string) error {
+	if s.Authorizer == nil {
+		return errNoAuthorizationUsed
+	}
+	if err := s.authorize(ctx, mirrorObject, action); err != nil {
+		return err
+	}
+	if s.Checkpoints == nil {
+		return errNoMirrorCheckpointsUsed
+	}
+	if source == "" {
+		return errNoSourceCluster
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package logserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 在运行时管理访问控制策略需要实现的接口
+// 添加或删除策略时返回值表示策略是否发生了变化
+type PolicyStore interface {
+	Policies() ([]auth.Policy, []auth.RoleAssignment)
+	AddPolicy(p auth.Policy) (bool, error)
+	RemovePolicy(p auth.Policy) (bool, error)
+	AddRoleForSubject(r auth.RoleAssignment) (bool, error)
+}
+
+// 在 auth 包中的 *auth.Authorizer 实现了 PolicyStore 接口
+// 加入集群时使用的实现还会把修改复制到其他节点
+var _ PolicyStore = (*auth.Authorizer)(nil)
+
+var errNoPolicyStoreUsed = status.New(codes.FailedPrecondition, "no policy store being used").Err()
+
+// 修改已经在本节点生效，但是没有被集群中所有节点确认时 PolicyStore 返回的错误
+// 服务器把它作为 PolicyResponse 的 failed_nodes 返回，而不是返回错误
+type ReplicationError struct {
+	// 节点名到错误的映射
+	Failed map[string]error
+}
+
+func (e *ReplicationError) Error() string {
+	nodes := make([]string, 0, len(e.Failed))
+	for node := range e.Failed {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return fmt.Sprintf("policy change not confirmed by %s", strings.Join(nodes, ", "))
+}
+
+func (s *gRPCServer) ListPolicies(ctx context.Context, req *api.ListPoliciesRequest) (*api.ListPoliciesResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	policies, roles := s.Policies.Policies()
+	res := &api.ListPoliciesResponse{
+		Policies: make([]*api.Policy, 0, len(policies)),
+		Roles:    make([]*api.RoleAssignment, 0, len(roles)),
+	}
+	for _, p := range policies {
+		res.Policies = append(res.Policies, &api.Policy{Subject: p.Subject, Object: p.Object, Action: p.Action})
+	}
+	for _, r := range roles {
+		res.Roles = append(res.Roles, &api.RoleAssignment{Subject: r.Subject, Role: r.Role})
+	}
+	return res, nil
+}
+
+func (s *gRPCServer) AddPolicy(ctx context.Context, req *api.PolicyRequest) (*api.PolicyResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return policyResponse(s.Policies.AddPolicy(policy(req.Policy)))
+}
+
+func (s *gRPCServer) RemovePolicy(ctx context.Context, req *api.PolicyRequest) (*api.PolicyResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return policyResponse(s.Policies.RemovePolicy(policy(req.Policy)))
+}
+
+func (s *gRPCServer) AddRoleForSubject(ctx context.Context, req *api.RoleRequest) (*api.PolicyResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return policyResponse(s.Policies.AddRoleForSubject(auth.RoleAssignment{
+		Subject: req.Role.GetSubject(),
+		Role:    req.Role.GetRole(),
+	}))
+}
+
+// 只有拥有 admin 权限的用户可以管理策略
+func (s *gRPCServer) authorizeAdmin(ctx context.Context) error {
+	if s.Authorizer == nil {
+		return errNoAuthorizationUsed
+	}
+	if err := s.authorize(ctx, policyObject, adminAction); err != nil {
+		return err
+	}
+	if s.Policies == nil {
+		return errNoPolicyStoreUsed
+	}
+	return nil
+}
+
+func policy(p *api.Policy) auth.Policy {
+	return auth.Policy{Subject: p.GetSubject(), Object: p.GetObject(), Action: p.GetAction()}
+}
+
+func policyResponse(changed bool, err error) (*api.PolicyResponse, error) {
+	if errors.Is(err, auth.ErrInvalidPolicy) {
+		return nil, status.New(codes.InvalidArgument, err.Error()).Err()
+	}
+	var replicationErr *ReplicationError
+	if errors.As(err, &replicationErr) {
+		rsp := &api.PolicyResponse{Changed: changed, FailedNodes: make(map[string]string)}
+		for node, err := range replicationErr.Failed {
+			rsp.FailedNodes[node] = err.Error()
+		}
+		return rsp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &api.PolicyResponse{Changed: changed}, nil
+}
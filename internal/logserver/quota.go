@@ -0,0 +1,51 @@
+package logserver
+
+import (
+	"context"
+
+	"github.com/youngfr/dcls/internal/quota"
+	"google.golang.org/grpc"
+)
+
+// 限额接口
+// 超出限额时返回 ResourceExhausted 错误
+type Quotas interface {
+	AllowAppend(subject string, bytes int) error
+	AllowRead(subject string) error
+
+	// 请求结束时需要调用返回的 release
+	Acquire(subject string) (release func(), err error)
+}
+
+// 在 quota 包中的 *quota.Manager 实现了 Quotas 接口
+var _ Quotas = (*quota.Manager)(nil)
+
+// 限制每个用户同时进行的请求数量
+// 需要在认证拦截器之后执行，这时才知道请求属于哪个用户
+func quotaInterceptor(q Quotas) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		release, err := q.Acquire(subject(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+func (s *gRPCServer) allowAppend(ctx context.Context, bytes int) error {
+	if s.Quotas == nil {
+		return nil
+	}
+	return s.Quotas.AllowAppend(subject(ctx), bytes)
+}
+
+func (s *gRPCServer) allowRead(ctx context.Context) error {
+	if s.Quotas == nil {
+		return nil
+	}
+	return s.Quotas.AllowRead(subject(ctx))
+}
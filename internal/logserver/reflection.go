@@ -0,0 +1,26 @@
+package logserver
+
+import (
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// 服务器反射的方法，包括 v1 和 v1alpha 两个版本
+const reflectionMethodPrefix = "/grpc.reflection."
+
+// 服务器反射是流式 RPC，需要在认证拦截器之后检查权限
+func reflectionInterceptor(a Authorizer) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, reflectionMethodPrefix) {
+			return handler(srv, ss)
+		}
+		if a == nil {
+			return errNoAuthorizationUsed
+		}
+		if err := a.Authorize(subject(ss.Context()), reflectionObject, adminAction); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,121 @@
+package logserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 副本的复制状态
+// 复制层需要实现这个接口来支持不同一致性级别的读取
+type Replica interface {
+	// 本副本已经提交的最大下标
+	// 只有不大于 commit index 的记录才能被读取
+	CommitIndex() uint64
+
+	// 本副本落后 leader 的记录数，以及距离上次与 leader 同步过去的时间
+	Lag() (offsets uint64, staleness time.Duration)
+
+	// 返回可以进行线性一致读的下标
+	// leader 需要先确认自己仍然是 leader
+	// follower 需要向 leader 获取它的 commit index 并等待自己追上
+	ReadIndex(ctx context.Context) (uint64, error)
+}
+
+// Replica 可以选择实现的接口
+// 返回错误时本副本不接受写入（比如它是 follower），Append、Reset 和 RestoreArchive 返回 FailedPrecondition
+type WriteChecker interface {
+	CheckWritable() error
+}
+
+func (s *gRPCServer) checkWritable() error {
+	w, ok := s.replica.(WriteChecker)
+	if !ok {
+		return nil
+	}
+	if err := w.CheckWritable(); err != nil {
+		return status.New(codes.FailedPrecondition, err.Error()).Err()
+	}
+	return nil
+}
+
+// 没有复制层时服务器就是唯一的副本
+// 所有追加成功的记录都已经提交，也永远不会落后
+type standaloneReplica struct {
+	CommitLog
+}
+
+var _ Replica = standaloneReplica{}
+
+func (r standaloneReplica) CommitIndex() uint64 {
+	off, err := r.HighestOffset()
+	if err != nil {
+		return 0
+	}
+	return off
+}
+
+func (r standaloneReplica) Lag() (uint64, time.Duration) {
+	return 0, 0
+}
+
+func (r standaloneReplica) ReadIndex(ctx context.Context) (uint64, error) {
+	return r.CommitIndex(), nil
+}
+
+// 按照请求的一致性级别检查本副本能否处理读取
+// 返回本副本当前的 commit index
+func checkConsistency(ctx context.Context, r Replica, req *api.ReadRequest) (uint64, error) {
+	commitIndex := r.CommitIndex()
+
+	switch req.Consistency {
+	case api.Consistency_STALE:
+	case api.Consistency_BOUNDED:
+		lagOffsets, staleness := r.Lag()
+		if req.MaxLagOffsets > 0 && lagOffsets > req.MaxLagOffsets {
+			return commitIndex, status.New(
+				codes.Unavailable,
+				fmt.Sprintf("replica lags %d offsets behind, max %d", lagOffsets, req.MaxLagOffsets),
+			).Err()
+		}
+		maxLag := time.Duration(req.MaxLagMs) * time.Millisecond
+		if maxLag > 0 && staleness > maxLag {
+			return commitIndex, status.New(
+				codes.Unavailable,
+				fmt.Sprintf("replica lags %v behind, max %v", staleness, maxLag),
+			).Err()
+		}
+	case api.Consistency_LINEARIZABLE:
+		readIndex, err := r.ReadIndex(ctx)
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return commitIndex, err
+			}
+			return commitIndex, status.New(codes.Unavailable, err.Error()).Err()
+		}
+		if commitIndex = r.CommitIndex(); commitIndex < readIndex {
+			return commitIndex, status.New(
+				codes.Unavailable,
+				fmt.Sprintf("replica commit index %d behind read index %d", commitIndex, readIndex),
+			).Err()
+		}
+	default:
+		return commitIndex, status.New(
+			codes.InvalidArgument,
+			fmt.Sprintf("unknown consistency level: %v", req.Consistency),
+		).Err()
+	}
+
+	// 读自己写入的数据
+	if commitIndex < req.MinCommitIndex {
+		return commitIndex, status.New(
+			codes.Unavailable,
+			fmt.Sprintf("replica commit index %d behind requested %d", commitIndex, req.MinCommitIndex),
+		).Err()
+	}
+	return commitIndex, nil
+}
@@ -0,0 +1,62 @@
+package logserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/youngfr/dcls/internal/audit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// 返回给客户端的请求 ID 的 metadata 的键
+// 请求 ID 在响应的 header 中，请求失败时客户端也能拿到
+const RequestIDKey = "x-request-id"
+
+// 在请求处理过程中逐步填写的请求信息，供审计、指标等在认证之前执行的拦截器使用
+// 收到请求时生成请求 ID，认证时填写主体，访问控制时填写操作和对象，处理请求时填写下标范围
+type requestInfo struct {
+	requestID string
+	subject   string
+	action    string
+	object    string
+	offsets   *audit.OffsetRange
+}
+
+type requestInfoContextKey struct{}
+
+func newRequestInfo(ctx context.Context) (context.Context, *requestInfo) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	ri := &requestInfo{requestID: hex.EncodeToString(b)}
+	return context.WithValue(ctx, requestInfoContextKey{}, ri), ri
+}
+
+// 最外层的拦截器，之后的拦截器和处理请求的方法都填写同一个 requestInfo
+func requestInfoInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, ri := newRequestInfo(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, ri.requestID))
+		return handler(ctx, req)
+	}
+}
+
+func requestInfoStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, ri := newRequestInfo(ss.Context())
+		ss.SetHeader(metadata.Pairs(RequestIDKey, ri.requestID))
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	ri, _ := ctx.Value(requestInfoContextKey{}).(*requestInfo)
+	if ri == nil {
+		return &requestInfo{}
+	}
+	return ri
+}
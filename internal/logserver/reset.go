@@ -0,0 +1,195 @@
+package logserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 归档接口
+// Reset 时把日志移动到归档中而不是删除它们
+type Archiver interface {
+	// 归档所有日志并清空日志，返回归档的名字
+	Archive() (string, error)
+
+	ListArchives() ([]log.Archive, error)
+
+	// 用归档替换当前的日志，返回保存当前日志的归档的名字
+	RestoreArchive(name string) (string, error)
+}
+
+// 在 log 包中的 *log.Log 实现了 Archiver 接口
+var _ Archiver = (*log.Log)(nil)
+
+const (
+	RESET_SUCC    = "Reset SUCCESS"
+	RESET_FAIL    = "Reset FAILED"
+	RESET_CONFIRM = "Reset CONFIRMATION REQUIRED"
+)
+
+const defaultResetWindow = time.Minute
+
+var (
+	errInvalidResetToken = status.New(codes.FailedPrecondition, "invalid or expired reset confirmation token").Err()
+	errNoArchiverUsed    = status.New(codes.FailedPrecondition, "no archive being used").Err()
+)
+
+// Reset 的确认令牌
+// 令牌只能使用一次，并且只能由申请它的用户在有效期内使用
+type resetTokens struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]resetToken
+}
+
+type resetToken struct {
+	subject   string
+	expiresAt time.Time
+}
+
+func newResetTokens(window time.Duration) *resetTokens {
+	if window <= 0 {
+		window = defaultResetWindow
+	}
+	return &resetTokens{window: window, tokens: make(map[string]resetToken)}
+}
+
+func (r *resetTokens) issue(subject string) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	expiresAt := now.Add(r.window)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 顺便清理过期的令牌
+	for t, rt := range r.tokens {
+		if now.After(rt.expiresAt) {
+			delete(r.tokens, t)
+		}
+	}
+	r.tokens[token] = resetToken{subject: subject, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+func (r *resetTokens) redeem(subject, token string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rt, ok := r.tokens[token]
+	if !ok {
+		return false
+	}
+	delete(r.tokens, token)
+	return rt.subject == subject && !time.Now().After(rt.expiresAt)
+}
+
+// 删除所有日志分为两步
+// 第一次请求不带令牌，服务器返回确认令牌
+// 第二次请求带上令牌，服务器在令牌有效时把所有日志移动到归档中
+func (s *gRPCServer) Reset(ctx context.Context, req *api.ResetRequest) (*api.ResetResponse, error) {
+	if s.Authorizer == nil {
+		return nil, errNoAuthorizationUsed
+	}
+	// 只有超级用户可以删除所有日志
+	if err := s.authorize(ctx, objects, resetAction); err != nil {
+		return nil, err
+	}
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if req.ConfirmationToken == "" {
+		token, expiresAt, err := s.resets.issue(subject(ctx))
+		if err != nil {
+			return nil, err
+		}
+		return &api.ResetResponse{
+			Reply:             RESET_CONFIRM,
+			ConfirmationToken: token,
+			ExpiresAtMs:       expiresAt.UnixMilli(),
+		}, nil
+	}
+	if !s.resets.redeem(subject(ctx), req.ConfirmationToken) {
+		return nil, errInvalidResetToken
+	}
+
+	// 审计日志中记录被删除的日志的下标范围，日志为空时不记录
+	lowest, lerr := s.CommitLog.LowestOffset()
+	next, nerr := s.CommitLog.NextOffset()
+	if lerr == nil && nerr == nil && next > lowest {
+		auditOffsets(ctx, lowest, next-1)
+	}
+	if s.Archives == nil {
+		if err := s.CommitLog.Reset(); err != nil {
+			return &api.ResetResponse{Reply: RESET_FAIL}, err
+		}
+		return &api.ResetResponse{Reply: RESET_SUCC}, nil
+	}
+	archive, err := s.Archives.Archive()
+	if err != nil {
+		return &api.ResetResponse{Reply: RESET_FAIL, Archive: archive}, err
+	}
+	return &api.ResetResponse{Reply: RESET_SUCC, Archive: archive}, nil
+}
+
+// 可以删除日志的用户才可以查看和恢复归档
+func (s *gRPCServer) authorizeArchives(ctx context.Context) error {
+	if s.Authorizer == nil {
+		return errNoAuthorizationUsed
+	}
+	if err := s.authorize(ctx, objects, resetAction); err != nil {
+		return err
+	}
+	if s.Archives == nil {
+		return errNoArchiverUsed
+	}
+	return nil
+}
+
+func (s *gRPCServer) ListArchives(ctx context.Context, req *api.ListArchivesRequest) (*api.ListArchivesResponse, error) {
+	if err := s.authorizeArchives(ctx); err != nil {
+		return nil, err
+	}
+	archives, err := s.Archives.ListArchives()
+	if err != nil {
+		return nil, err
+	}
+	resp := &api.ListArchivesResponse{Archives: make([]*api.Archive, 0, len(archives))}
+	for _, a := range archives {
+		resp.Archives = append(resp.Archives, &api.Archive{
+			Name:          a.Name,
+			CreatedAtMs:   a.CreatedAt.UnixMilli(),
+			LowestOffset:  a.LowestOffset,
+			HighestOffset: a.HighestOffset,
+			Segments:      uint32(a.Segments),
+			Bytes:         a.Bytes,
+		})
+	}
+	return resp, nil
+}
+
+func (s *gRPCServer) RestoreArchive(ctx context.Context, req *api.RestoreArchiveRequest) (*api.RestoreArchiveResponse, error) {
+	if err := s.authorizeArchives(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	archive, err := s.Archives.RestoreArchive(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &api.RestoreArchiveResponse{Archive: archive}, nil
+}
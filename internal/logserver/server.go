@@ -2,19 +2,57 @@ package logserver
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/trace"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // 配置实际使用了哪一种日志存储结构实现及哪一种访问控制实现
+// 没有加入集群时 Keyring、Cluster 和 Topics 为空
+// ClusterCommands 是可以通过 ClusterQuery 执行的命令，其他命令（比如复制策略修改的内部命令）都会被拒绝
+// 不允许在运行时管理访问控制策略时 Policies 为空
+// Authenticator 为空时使用客户端证书认证用户
+// 这时 Identity 决定从客户端证书的哪个字段提取用户身份，默认使用 CommonName
+// 没有复制层时 Replica 为空，这时服务器就是唯一的副本
+// Auditor 为空时不记录审计日志，Quotas 为空时不限制用户的请求
+// Archives 为空时 Reset 直接调用 CommitLog 的 Reset，这时不能管理归档
+// ResetWindow 是 Reset 的确认令牌的有效期，为零时使用一分钟
+// Signatures 为空时不检查记录的签名，Metrics 为空时不记录 RPC 的指标
+// Tracer 为空时不追踪请求，Checkpoints 为空时不能保存其他集群镜像到本集群的进度
+// Health 为空时使用立即进入 SERVING 状态的健康检查服务，参见 health.go
+// Reflection 为真时开启服务器反射，使用反射需要对 reflection 的 admin 权限
 type LogImplConfig struct {
-	CommitLog  CommitLog
-	Authorizer Authorizer
+	CommitLog       CommitLog
+	Authorizer      Authorizer
+	Keyring         Keyring
+	Cluster         Cluster
+	ClusterCommands []string
+	Replica         Replica
+	Topics          TopicDescriber
+	Policies        PolicyStore
+	Identity        Identity
+	Authenticator   Authenticator
+	Auditor         Auditor
+	Quotas          Quotas
+	Archives        Archiver
+	ResetWindow     time.Duration
+	Signatures      SignaturePolicy
+	Metrics         RPCMetrics
+	Tracer          *trace.Tracer
+	Checkpoints     MirrorCheckpoints
+	Health          *Health
+	Reflection      bool
 }
 
 var _ api.LogServer = (*gRPCServer)(nil)
@@ -22,73 +60,163 @@ var _ api.LogServer = (*gRPCServer)(nil)
 type gRPCServer struct {
 	*LogImplConfig
 	api.UnimplementedLogServer
+
+	replica Replica
+	resets  *resetTokens
 }
 
 // 根据实际使用的日志存储结构、访问控制机制和服务器选项创建 gRPC 服务器
 func NewgRPCServer(c *LogImplConfig, opts ...grpc.ServerOption) (*grpc.Server, error) {
-	opts = append(opts, grpc.UnaryInterceptor(
-		grpc_middleware.ChainUnaryServer(grpc_auth.UnaryServerInterceptor(authenticate)),
-	))
+	var authenticator Authenticator = MTLSAuthenticator{Identity: c.Identity}
+	if c.Authenticator != nil {
+		authenticator = c.Authenticator
+	}
+	logger := zap.L().Named("request")
+	interceptors := []grpc.UnaryServerInterceptor{requestInfoInterceptor(), loggingInterceptor(logger)}
+	if c.Tracer != nil {
+		interceptors = append(interceptors, trace.UnaryServerInterceptor(c.Tracer))
+	}
+	if c.Metrics != nil {
+		interceptors = append(interceptors, metricsInterceptor(c.Metrics))
+	}
+	if c.Auditor != nil {
+		interceptors = append(interceptors, auditInterceptor(c.Auditor))
+	}
+	interceptors = append(interceptors, grpc_auth.UnaryServerInterceptor(authenticate(authenticator)))
+	if c.Quotas != nil {
+		interceptors = append(interceptors, quotaInterceptor(c.Quotas))
+	}
+	opts = append(opts, grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(interceptors...)))
+	opts = append(opts, grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+		requestInfoStreamInterceptor(),
+		loggingStreamInterceptor(logger),
+		grpc_auth.StreamServerInterceptor(authenticate(authenticator)),
+		reflectionInterceptor(c.Authorizer),
+	)))
 
 	// 1. 调用 grpc.NewServer 方法
 	s := grpc.NewServer(opts...)
 
 	// 2. 创建自己的服务器
-	srv := &gRPCServer{LogImplConfig: c}
+	srv := &gRPCServer{LogImplConfig: c, replica: c.Replica, resets: newResetTokens(c.ResetWindow)}
+	if srv.replica == nil {
+		srv.replica = standaloneReplica{c.CommitLog}
+	}
 
 	// 3. 调用 ProtoBuf 自动生成的注册方法
 	api.RegisterLogServer(s, srv)
 
+	// 4. 注册健康检查服务和服务器反射
+	h := c.Health
+	if h == nil {
+		storage, _ := c.CommitLog.(HealthChecker)
+		h = NewHealth(storage, c.Topics)
+		h.Start()
+	}
+	healthpb.RegisterHealthServer(s, h)
+	if c.Reflection {
+		reflection.Register(s)
+	}
+
 	return s, nil
 }
 
-var errNoAuthorizationUsed = status.New(codes.Unauthenticated, "no authorization being used").Err()
+var (
+	errNoAuthorizationUsed = status.New(codes.Unauthenticated, "no authorization being used").Err()
+	errNoClusterUsed       = status.New(codes.FailedPrecondition, "no cluster membership being used").Err()
+)
 
 func (s *gRPCServer) Read(ctx context.Context, req *api.ReadRequest) (*api.ReadResponse, error) {
 	if s.Authorizer == nil {
 		return nil, errNoAuthorizationUsed
 	}
-	// 超级用户、普通用户和只读用户都可以读取日志
-	if err := s.Authorizer.Authorize(subject(ctx), objects, readAction); err != nil {
+	// 读取之后才知道记录属于哪个主题，在访问存储之前先确认用户至少可以读取一个主题
+	// 没有任何读取权限的用户不能知道 commit index 和哪些记录存在，也不会消耗限额
+	if err := s.authorizeAny(ctx, readAction); err != nil {
+		return nil, err
+	}
+	// 在读取之前检查限额，超出限额的请求不会占用日志的锁
+	if err := s.allowRead(ctx); err != nil {
 		return nil, err
 	}
+	commitIndex, err := checkConsistency(ctx, s.replica, req)
+	if err != nil {
+		return nil, err
+	}
+	// 还没有提交的记录不能被读取
+	auditOffsets(ctx, req.Offset, req.Offset)
+	trace.FromContext(ctx).SetAttribute("offset", req.Offset)
+	if req.Offset > commitIndex {
+		return nil, status.New(
+			codes.OutOfRange,
+			fmt.Sprintf("offset %d beyond commit index %d", req.Offset, commitIndex),
+		).Err()
+	}
 	record, err := s.CommitLog.Read(req.Offset)
+	if err == nil {
+		err = s.authorize(ctx, topicObject(record.Topic), readAction)
+	}
 	if err != nil {
+		// 只能读取部分主题的用户对不存在的记录和没有权限读取的记录得到相同的错误
+		// 这样他们不能知道其他主题的记录在哪些下标上
+		if s.Authorizer.Authorize(subject(ctx), everyObject, readAction) != nil {
+			return nil, status.New(
+				codes.PermissionDenied,
+				fmt.Sprintf("%s is not permitted to read offset %d", subject(ctx), req.Offset),
+			).Err()
+		}
 		return nil, err
 	}
-	return &api.ReadResponse{Record: record}, nil
+	return &api.ReadResponse{Record: record, CommitIndex: commitIndex}, nil
 }
 
 func (s *gRPCServer) Append(ctx context.Context, req *api.AppendRequest) (*api.AppendResponse, error) {
 	if s.Authorizer == nil {
 		return nil, errNoAuthorizationUsed
 	}
-	// 超级用户和普通用户可以追加日志
-	if err := s.Authorizer.Authorize(subject(ctx), objects, appendAction); err != nil {
+	// 需要有向记录所属的主题追加日志的权限
+	if err := s.authorize(ctx, topicObject(req.Record.GetTopic()), appendAction); err != nil {
+		return nil, err
+	}
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := s.allowAppend(ctx, proto.Size(req.Record)); err != nil {
 		return nil, err
 	}
-	absOff, err := s.CommitLog.Append(req.Record)
+	if err := s.checkSignature(req.Record); err != nil {
+		return nil, err
+	}
+	absOff, err := appendContext(ctx, s.CommitLog, req.Record)
 	if err != nil {
 		return nil, err
 	}
+	auditOffsets(ctx, absOff, absOff)
+	span := trace.FromContext(ctx)
+	span.SetAttribute("topic", req.Record.Topic)
+	span.SetAttribute("offset", absOff)
 	return &api.AppendResponse{Offset: absOff}, nil
 }
 
-const (
-	RESET_SUCC = "Reset SUCCESS"
-	RESET_FAIL = "Reset FAILED"
-)
-
-func (s *gRPCServer) Reset(ctx context.Context, req *api.ResetRequest) (*api.ResetResponse, error) {
+func (s *gRPCServer) Offsets(ctx context.Context, req *api.OffsetsRequest) (*api.OffsetsResponse, error) {
 	if s.Authorizer == nil {
 		return nil, errNoAuthorizationUsed
 	}
-	// 只有超级用户可以删除所有日志
-	if err := s.Authorizer.Authorize(subject(ctx), objects, resetAction); err != nil {
+	// 可以读取日志（包括只能读取部分主题）的用户都可以获取日志的下标范围
+	if err := s.authorizeAny(ctx, readAction); err != nil {
 		return nil, err
 	}
-	if err := s.CommitLog.Reset(); err != nil {
-		return &api.ResetResponse{Reply: RESET_FAIL}, err
+	lowest, err := s.CommitLog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	highest, err := s.CommitLog.HighestOffset()
+	if err != nil {
+		return nil, err
+	}
+	next, err := s.CommitLog.NextOffset()
+	if err != nil {
+		return nil, err
 	}
-	return &api.ResetResponse{Reply: RESET_SUCC}, nil
+	return &api.OffsetsResponse{Lowest: lowest, Highest: highest, NextOffset: next}, nil
 }
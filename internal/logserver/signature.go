@@ -0,0 +1,22 @@
+package logserver
+
+import (
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/sign"
+)
+
+// 签名策略接口
+// 追加记录之前检查记录的签名，不符合策略的记录不会被写入
+type SignaturePolicy interface {
+	CheckAppend(*api.Record) error
+}
+
+// 在 sign 包中的 *sign.Policy 实现了 SignaturePolicy 接口
+var _ SignaturePolicy = (*sign.Policy)(nil)
+
+func (s *gRPCServer) checkSignature(record *api.Record) error {
+	if s.Signatures == nil {
+		return nil
+	}
+	return s.Signatures.CheckAppend(record)
+}
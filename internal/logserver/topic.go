@@ -0,0 +1,56 @@
+package logserver
+
+import (
+	"context"
+	"errors"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/controller"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 查询主题的分区分配结果需要实现的接口
+type TopicDescriber interface {
+	DescribeTopic(name string) (*controller.Topic, error)
+}
+
+// 在 controller 包中的 *controller.Controller 实现了 TopicDescriber 接口
+var _ TopicDescriber = (*controller.Controller)(nil)
+
+func (s *gRPCServer) DescribeTopic(ctx context.Context, req *api.DescribeTopicRequest) (*api.DescribeTopicResponse, error) {
+	if s.Authorizer == nil {
+		return nil, errNoAuthorizationUsed
+	}
+	// 可以读取主题的用户都可以查看它的分区的分配结果
+	if err := s.authorize(ctx, topicObject(req.Topic), readAction); err != nil {
+		return nil, err
+	}
+	if s.Topics == nil {
+		return nil, errNoClusterUsed
+	}
+
+	t, err := s.Topics.DescribeTopic(req.Topic)
+	if errors.Is(err, controller.ErrTopicNotFound) {
+		return nil, status.New(codes.NotFound, err.Error()).Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &api.DescribeTopicResponse{
+		Topic:             t.Name,
+		ReplicationFactor: uint32(t.ReplicationFactor),
+		Advisory:          t.Advisory,
+	}
+	for _, p := range t.Partitions {
+		rsp.Partitions = append(rsp.Partitions, &api.PartitionAssignment{
+			Id:       uint32(p.ID),
+			Leader:   p.Leader,
+			Replicas: p.Replicas,
+			Syncing:  p.Syncing,
+			Retiring: p.Retiring,
+		})
+	}
+	return rsp, nil
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import "time"
+
+// 存储层的指标
+type LogMetrics struct {
+	Appends           *Counter
+	AppendedBytes     *Counter
+	Reads             *Counter
+	LockWaitSeconds   *Histogram
+	SegmentCount      *Gauge
+	ActiveFillRatio   *Gauge
+	RecoveredSegments *Counter
+}
+
+// 等待锁的时间通常很短，桶从 10 微秒开始
+var lockWaitBuckets = []float64{.00001, .0001, .001, .01, .1, 1}
+
+func NewLogMetrics(r *Registry) *LogMetrics {
+	return &LogMetrics{
+		Appends:           r.NewCounter("dcls_log_appends_total", "Records appended to the log."),
+		AppendedBytes:     r.NewCounter("dcls_log_appended_bytes_total", "Bytes written to store files."),
+		Reads:             r.NewCounter("dcls_log_reads_total", "Records read from the log."),
+		LockWaitSeconds:   r.NewHistogram("dcls_log_lock_wait_seconds", "Time spent waiting for the log lock.", lockWaitBuckets),
+		SegmentCount:      r.NewGauge("dcls_log_segments", "Number of segments in the log."),
+		ActiveFillRatio:   r.NewGauge("dcls_log_active_segment_fill_ratio", "Fill ratio of the active segment."),
+		RecoveredSegments: r.NewCounter("dcls_log_recovered_segments_total", "Segments recovered from disk when opening the log."),
+	}
+}
+
+func (m *LogMetrics) Appended(bytes uint64) {
+	m.Appends.Inc()
+	m.AppendedBytes.Add(float64(bytes))
+}
+
+func (m *LogMetrics) Read() {
+	m.Reads.Inc()
+}
+
+func (m *LogMetrics) LockWait(d time.Duration) {
+	m.LockWaitSeconds.Observe(d.Seconds())
+}
+
+func (m *LogMetrics) Segments(n int) {
+	m.SegmentCount.Set(float64(n))
+}
+
+func (m *LogMetrics) ActiveSegmentFill(ratio float64) {
+	m.ActiveFillRatio.Set(ratio)
+}
+
+func (m *LogMetrics) Recovered(segments int) {
+	m.RecoveredSegments.Add(float64(segments))
+}
@@ -0,0 +1,18 @@
+package metrics
+
+// 集群成员的指标
+type MembershipMetrics struct {
+	MemberCount *Gauge
+}
+
+func NewMembershipMetrics(r *Registry) *MembershipMetrics {
+	return &MembershipMetrics{
+		MemberCount: r.NewGauge("dcls_membership_members", "Cluster members by status.", "status"),
+	}
+}
+
+func (m *MembershipMetrics) Members(counts map[string]int) {
+	for status, n := range counts {
+		m.MemberCount.Set(float64(n), status)
+	}
+}
@@ -0,0 +1,307 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 以 Prometheus 文本格式导出的指标
+//
+// 只实现了 dcls 用到的 counter、gauge 和 histogram 三种指标
+// 每种指标都可以有一组标签，记录时按顺序给出每个标签的值
+// 指标也可以直接读出当前的值，方便测试断言
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w *bufio.Writer, name string)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.metrics[name] = m
+}
+
+// 按名字顺序写出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for i, m := range metrics {
+		m.write(bw, names[i])
+	}
+	return bw.Flush()
+}
+
+// /metrics 接口
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// 一组带有相同标签名的时间序列
+type family struct {
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+
+	// 只有 histogram 使用
+	counts []uint64
+	count  uint64
+}
+
+func newFamily(help, typ string, labels []string) *family {
+	return &family{help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+}
+
+// 调用者需要持有 f.mu
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// 读取当前值时不创建新的时间序列
+func (f *family) lookup(values []string) *series {
+	if s, ok := f.series[strings.Join(values, "\xff")]; ok {
+		return s
+	}
+	return &series{}
+}
+
+func (f *family) sorted() []*series {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	all := make([]*series, 0, len(keys))
+	for _, k := range keys {
+		all = append(all, f.series[k])
+	}
+	return all
+}
+
+func (f *family) header(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.ReplaceAll(f.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ)
+}
+
+// 写出一行样本，extra 是额外的标签（histogram 的 le）
+func (f *family) sample(w *bufio.Writer, name string, values []string, extra string, v float64) {
+	w.WriteString(name)
+	if len(values) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, l := range f.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=%q", l, values[i])
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 只增不减的计数器
+type Counter struct {
+	*family
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newFamily(help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	c.get(values).value += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookup(values).value
+}
+
+func (c *Counter) write(w *bufio.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, name)
+	for _, s := range c.sorted() {
+		c.sample(w, name, s.values, "", s.value)
+	}
+}
+
+// 可增可减的当前值
+type Gauge struct {
+	*family
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily(help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.mu.Lock()
+	g.get(values).value = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, values ...string) {
+	g.mu.Lock()
+	g.get(values).value += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Value(values ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lookup(values).value
+}
+
+func (g *Gauge) write(w *bufio.Writer, name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w, name)
+	for _, s := range g.sorted() {
+		g.sample(w, name, s.values, "", s.value)
+	}
+}
+
+// 默认的 histogram 桶（秒），和 Prometheus 客户端库的默认值相同
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// 观测值的分布，value 保存所有观测值的和
+type Histogram struct {
+	*family
+	buckets []float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{newFamily(help, "histogram", labels), buckets}
+	r.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// 观测值的个数
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lookup(values).count
+}
+
+// 观测值的和
+func (h *Histogram) Sum(values ...string) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lookup(values).value
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, name)
+	for _, s := range h.sorted() {
+		for i, upper := range h.buckets {
+			var n uint64
+			if s.counts != nil {
+				n = s.counts[i]
+			}
+			h.sample(w, name+"_bucket", s.values, fmt.Sprintf("le=%q", formatFloat(upper)), float64(n))
+		}
+		h.sample(w, name+"_bucket", s.values, `le="+Inf"`, float64(s.count))
+		h.sample(w, name+"_sum", s.values, "", s.value)
+		h.sample(w, name+"_count", s.values, "", float64(s.count))
+	}
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import "time"
+
+// RPC 的指标
+// 请求数按方法、状态码和用户统计，处理时间只按方法统计，避免时间序列过多
+type RPCMetrics struct {
+	Requests        *Counter
+	DurationSeconds *Histogram
+}
+
+func NewRPCMetrics(r *Registry) *RPCMetrics {
+	return &RPCMetrics{
+		Requests:        r.NewCounter("dcls_rpc_requests_total", "RPCs handled by the server.", "method", "code", "subject"),
+		DurationSeconds: r.NewHistogram("dcls_rpc_duration_seconds", "RPC latency.", nil, "method"),
+	}
+}
+
+func (m *RPCMetrics) Observe(method, code, subject string, d time.Duration) {
+	m.Requests.Inc(method, code, subject)
+	m.DurationSeconds.Observe(d.Seconds(), method)
+}
@@ -0,0 +1,83 @@
+package mirror
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 目标集群的服务器保存镜像进度的文件
+// 文件中是源集群的名字到下一条要镜像的源集群记录的下标的映射
+//
+//	{"shanghai": 1024}
+//
+// 进度不写入日志，所以不会被消费者读到，也不占用限额
+// 日志被 Reset 时进度不会被删除，镜像继续从原来的位置开始
+type FileCheckpoints struct {
+	file string
+
+	mu          sync.Mutex
+	checkpoints map[string]uint64
+}
+
+// 文件不存在时在第一次保存进度时创建
+func NewFileCheckpoints(file string) (*FileCheckpoints, error) {
+	c := &FileCheckpoints{file: file, checkpoints: make(map[string]uint64)}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.checkpoints); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *FileCheckpoints) Checkpoint(source string) (uint64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next, ok := c.checkpoints[source]
+	return next, ok, nil
+}
+
+// 先写入临时文件再替换原来的文件，写入失败时原来的进度不受影响
+func (c *FileCheckpoints) SaveCheckpoint(source string, next uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	checkpoints := make(map[string]uint64, len(c.checkpoints)+1)
+	for k, v := range c.checkpoints {
+		checkpoints[k] = v
+	}
+	checkpoints[source] = next
+	b, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.file), "."+filepath.Base(c.file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.file); err != nil {
+		return err
+	}
+	c.checkpoints = checkpoints
+	return nil
+}
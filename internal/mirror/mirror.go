@@ -0,0 +1,299 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 镜像到目标集群的记录上会添加以下 header 来表明它的来源
+const (
+	SourceClusterHeader = "dcls-source-cluster"
+	SourceOffsetHeader  = "dcls-source-offset"
+)
+
+// 镜像进度通过 SetMirrorCheckpoint 保存在目标集群的服务器上，不写入目标集群的日志
+// 检查点是下一条要镜像的源集群记录的下标
+//
+// 两次检查点之间镜像的记录本身带有 provenance header，也记录了进度
+// 重启时从检查点开始，再从后往前扫描目标集群最后的 ResumeScanLimit 条记录找到更新的进度
+// 扫描不到时（比如其他生产者在这期间追加了大量记录）可能重复镜像少量记录
+
+type Config struct {
+	// 源集群的名字，写入 provenance header 并用于区分不同来源的检查点
+	SourceCluster string
+
+	// 从源集群读取，向目标集群追加
+	Source api.LogClient
+	Target api.LogClient
+
+	// 需要镜像的主题，为空时镜像所有主题
+	Topics []string
+
+	// 源集群没有新记录时等待多久再读取
+	PollInterval time.Duration
+
+	// 每读取多少条源集群的记录写一次检查点
+	// 源集群没有新记录和停止时也会写检查点
+	CheckpointInterval uint64
+
+	// 重启时最多从后往前扫描多少条目标集群的记录，为零时使用 CheckpointInterval 的十倍
+	ResumeScanLimit uint64
+}
+
+var (
+	errNoSourceCluster = errors.New("no source cluster name provided for mirror")
+	errNoSourceClient  = errors.New("no source client provided for mirror")
+	errNoTargetClient  = errors.New("no target client provided for mirror")
+)
+
+// 把源集群中选中主题的记录持续地追加到目标集群
+type Mirror struct {
+	Config
+	topics map[string]bool
+	logger *zap.Logger
+
+	// 下一条要读取的源集群记录的下标
+	next uint64
+
+	// 上次写入检查点之后读取了多少条源集群的记录
+	uncheckpointed uint64
+}
+
+func New(c Config) (*Mirror, error) {
+	if c.SourceCluster == "" {
+		return nil, errNoSourceCluster
+	}
+	if c.Source == nil {
+		return nil, errNoSourceClient
+	}
+	if c.Target == nil {
+		return nil, errNoTargetClient
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = time.Second
+	}
+	if c.CheckpointInterval == 0 {
+		c.CheckpointInterval = 100
+	}
+	if c.ResumeScanLimit == 0 {
+		c.ResumeScanLimit = 10 * c.CheckpointInterval
+	}
+	m := &Mirror{
+		Config: c,
+		logger: zap.L().Named("mirror").With(zap.String("source", c.SourceCluster)),
+	}
+	if len(c.Topics) > 0 {
+		m.topics = make(map[string]bool)
+		for _, topic := range c.Topics {
+			m.topics[topic] = true
+		}
+	}
+	return m, nil
+}
+
+// 一直运行直到 ctx 被取消或者发生无法恢复的错误
+// 启动时从目标集群中的检查点恢复进度
+func (m *Mirror) Run(ctx context.Context) error {
+	next, err := m.resume(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resume mirror: %w", err)
+	}
+	m.next = next
+	m.logger.Info("mirror started", zap.Uint64("offset", m.next))
+
+	for {
+		rsp, err := m.Source.Read(ctx, &api.ReadRequest{Offset: m.next})
+		if err != nil {
+			if ctx.Err() != nil {
+				return m.stop()
+			}
+			if err := m.wait(ctx, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.mirror(ctx, rsp.Record); err != nil {
+			if ctx.Err() != nil {
+				return m.stop()
+			}
+			return err
+		}
+	}
+}
+
+// 源集群暂时没有新记录或者旧记录已经被删除时不是错误
+// 只镜像部分主题时，镜像使用的身份可以只有这些主题的读取权限，没有权限读取的记录被跳过
+func (m *Mirror) wait(ctx context.Context, readErr error) error {
+	denied := false
+	switch status.Code(readErr) {
+	case codes.OutOfRange, codes.InvalidArgument:
+	case codes.PermissionDenied:
+		if m.topics == nil {
+			return readErr
+		}
+		denied = true
+	case codes.Unavailable, codes.DeadlineExceeded:
+		m.logger.Warn("source cluster unavailable", zap.Error(readErr))
+	default:
+		return readErr
+	}
+
+	offsets, err := m.Source.Offsets(ctx, &api.OffsetsRequest{})
+	switch {
+	case err != nil && denied:
+		return readErr
+	case err != nil:
+	case m.next < offsets.Lowest:
+		m.logger.Warn(
+			"source records removed, skipping",
+			zap.Uint64("from", m.next),
+			zap.Uint64("to", offsets.Lowest),
+		)
+		m.next = offsets.Lowest
+		return nil
+	case m.next > offsets.NextOffset:
+		// 源集群的日志被重置后从新的日志的第一条记录开始镜像
+		// 否则源集群的日志重新增长到 m.next 之前的记录都不会被镜像
+		m.logger.Warn(
+			"source log was reset, restarting",
+			zap.Uint64("from", m.next),
+			zap.Uint64("to", offsets.Lowest),
+		)
+		m.next = offsets.Lowest
+		return nil
+	case denied && m.next < offsets.NextOffset:
+		m.logger.Debug("skipping record the mirror is not permitted to read", zap.Uint64("offset", m.next))
+		return m.advance(ctx, m.next+1)
+	}
+
+	if err := m.checkpoint(ctx); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return m.stop()
+	case <-time.After(m.PollInterval):
+		return nil
+	}
+}
+
+func (m *Mirror) mirror(ctx context.Context, record *api.Record) error {
+	if m.topics == nil || m.topics[record.Topic] {
+		headers := make(map[string][]byte, len(record.Headers)+2)
+		for k, v := range record.Headers {
+			headers[k] = v
+		}
+		headers[SourceClusterHeader] = []byte(m.SourceCluster)
+		headers[SourceOffsetHeader] = []byte(strconv.FormatUint(record.Offset, 10))
+		if _, err := m.Target.Append(ctx, &api.AppendRequest{
+			// 签名不包含 dcls- 开头的 header，所以镜像后的记录仍然可以验证签名
+			Record: &api.Record{
+				Value:       record.Value,
+				Headers:     headers,
+				Topic:       record.Topic,
+				Key:         record.Key,
+				TimestampMs: record.TimestampMs,
+				Signature:   record.Signature,
+				KeyId:       record.KeyId,
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to append record %d to target: %w", record.Offset, err)
+		}
+	}
+	return m.advance(ctx, record.Offset+1)
+}
+
+// 处理完一条源集群的记录（无论是否镜像）之后更新进度
+func (m *Mirror) advance(ctx context.Context, next uint64) error {
+	m.next = next
+	m.uncheckpointed++
+
+	if m.uncheckpointed >= m.CheckpointInterval {
+		return m.checkpoint(ctx)
+	}
+	return nil
+}
+
+// 在目标集群中记录下一条要读取的源集群记录的下标
+func (m *Mirror) checkpoint(ctx context.Context) error {
+	if m.uncheckpointed == 0 {
+		return nil
+	}
+	if _, err := m.Target.SetMirrorCheckpoint(ctx, &api.SetMirrorCheckpointRequest{
+		SourceCluster: m.SourceCluster,
+		NextOffset:    m.next,
+	}); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	m.uncheckpointed = 0
+	return nil
+}
+
+// 停止前尽量保存进度
+func (m *Mirror) stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.checkpoint(ctx); err != nil {
+		m.logger.Error("failed to checkpoint on stop", zap.Error(err))
+	}
+	return nil
+}
+
+// 从目标集群保存的检查点恢复进度
+// 再从后往前扫描目标集群最后的 ResumeScanLimit 条记录，找到检查点之后镜像的记录
+// 都没有时从源集群的第一条记录开始镜像
+func (m *Mirror) resume(ctx context.Context) (uint64, error) {
+	checkpoint, err := m.Target.GetMirrorCheckpoint(ctx, &api.GetMirrorCheckpointRequest{SourceCluster: m.SourceCluster})
+	if err != nil {
+		return 0, err
+	}
+	found, next := checkpoint.Found, checkpoint.NextOffset
+
+	offsets, err := m.Target.Offsets(ctx, &api.OffsetsRequest{})
+	if err != nil {
+		return 0, err
+	}
+	for off, scanned := offsets.NextOffset, uint64(0); off > offsets.Lowest && scanned < m.ResumeScanLimit; scanned++ {
+		off--
+		rsp, err := m.Target.Read(ctx, &api.ReadRequest{Offset: off})
+		if status.Code(err) == codes.InvalidArgument {
+			// 扫描期间目标集群的日志被重置了
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if mirrored, ok := m.progress(rsp.Record); ok {
+			if !found || mirrored > next {
+				found, next = true, mirrored
+			}
+			break
+		}
+	}
+	if found {
+		return next, nil
+	}
+
+	offsets, err = m.Source.Offsets(ctx, &api.OffsetsRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return offsets.Lowest, nil
+}
+
+// 从目标集群中被镜像的记录解析出本源集群的进度
+func (m *Mirror) progress(record *api.Record) (uint64, bool) {
+	if string(record.Headers[SourceClusterHeader]) != m.SourceCluster {
+		return 0, false
+	}
+	off, err := strconv.ParseUint(string(record.Headers[SourceOffsetHeader]), 10, 64)
+	return off + 1, err == nil
+}
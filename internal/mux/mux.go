@@ -0,0 +1,193 @@
+package mux
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 节点之间的复制流量和客户端的 gRPC 流量共用同一个端口
+// 它们通过连接建立后发送的第一个字节来区分：
+//
+// 客户端使用 TLS 连接，第一个字节总是 TLS 握手记录的类型 0x16
+// 节点之间的连接在 TLS 握手之前先发送一个 PeerStream 字节
+// 凡是第一个字节没有被注册过的连接都交给默认监听器（即 gRPC 服务器）处理
+const PeerStream byte = 1
+
+var ErrListenerClosed = errors.New("mux: listener closed")
+
+type Mux struct {
+	root net.Listener
+
+	// 读取第一个字节的超时时间
+	// 防止建立连接后不发送任何数据的客户端一直占用协程
+	ReadTimeout time.Duration
+
+	mu       sync.Mutex
+	matchers map[byte]*listener
+	fallback *listener
+
+	logger *zap.Logger
+}
+
+func New(l net.Listener) *Mux {
+	return &Mux{
+		root:        l,
+		ReadTimeout: 10 * time.Second,
+		matchers:    make(map[byte]*listener),
+		logger:      zap.L().Named("mux"),
+	}
+}
+
+// 返回第一个字节为 b 的连接的监听器
+// 对于这些连接，第一个字节在交给监听器之前就已经被读取并丢弃了
+func (m *Mux) Match(b byte) net.Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.matchers[b]; ok {
+		return l
+	}
+	l := newListener(m.root.Addr())
+	m.matchers[b] = l
+	return l
+}
+
+// 返回其他所有连接的监听器
+// 这些连接的第一个字节会被原样保留
+func (m *Mux) Default() net.Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fallback == nil {
+		m.fallback = newListener(m.root.Addr())
+	}
+	return m.fallback
+}
+
+func (m *Mux) Addr() net.Addr {
+	return m.root.Addr()
+}
+
+// 在底层监听器上接受连接并分发给对应的监听器
+// 底层监听器被关闭后关闭所有的子监听器并返回
+func (m *Mux) Serve() error {
+	defer m.closeListeners()
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+func (m *Mux) Close() error {
+	return m.root.Close()
+}
+
+func (m *Mux) dispatch(conn net.Conn) {
+	if m.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(m.ReadTimeout))
+	}
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	m.mu.Lock()
+	l, matched := m.matchers[b[0]]
+	if !matched {
+		l = m.fallback
+	}
+	m.mu.Unlock()
+
+	if l == nil {
+		m.logger.Warn(
+			"no listener for connection",
+			zap.Uint8("first_byte", b[0]),
+			zap.String("remote_addr", conn.RemoteAddr().String()),
+		)
+		conn.Close()
+		return
+	}
+	if matched {
+		r.Discard(1)
+	}
+	l.push(&bufferedConn{Conn: conn, r: r})
+}
+
+func (m *Mux) closeListeners() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range m.matchers {
+		l.Close()
+	}
+	if m.fallback != nil {
+		m.fallback.Close()
+	}
+}
+
+// 多路复用得到的子监听器
+type listener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	done   chan struct{}
+	closed sync.Once
+}
+
+var _ net.Listener = (*listener)(nil)
+
+func newListener(addr net.Addr) *listener {
+	return &listener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *listener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closed.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// 已经被预读了若干字节的连接
+// 读取时先返回缓冲区中的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
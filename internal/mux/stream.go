@@ -0,0 +1,102 @@
+package mux
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// 节点之间的复制流量使用的传输层
+// 它的 Accept 和 Dial 方法就是复制层收发连接的入口
+//
+// 节点之间必须进行双向 TLS 认证
+// serverTLSConfig 和 peerTLSConfig 都应该由 auth.SetupTLSConfig 创建
+// 并且都要开启 EnableMutualTLS
+type StreamLayer struct {
+	ln              net.Listener
+	serverTLSConfig *tls.Config
+	peerTLSConfig   *tls.Config
+}
+
+var _ net.Listener = (*StreamLayer)(nil)
+
+var (
+	errNoServerTLSConfigForPeer = errors.New("no server TLS config provided for peer stream")
+	errNoPeerTLSConfigForPeer   = errors.New("no peer TLS config provided for peer stream")
+	errPeerNotMutualTLS         = errors.New("peer stream requires mutual TLS authentication")
+)
+
+// 参数 ln 一般是 (*Mux).Match(PeerStream) 的返回值
+func NewStreamLayer(ln net.Listener, serverTLSConfig, peerTLSConfig *tls.Config) (*StreamLayer, error) {
+	if serverTLSConfig == nil {
+		return nil, errNoServerTLSConfigForPeer
+	}
+	if peerTLSConfig == nil {
+		return nil, errNoPeerTLSConfigForPeer
+	}
+	// 服务端必须验证对端节点的证书
+	// 对端节点也必须提供自己的证书
+	if serverTLSConfig.ClientAuth != tls.RequireAndVerifyClientCert ||
+		len(peerTLSConfig.Certificates) == 0 && peerTLSConfig.GetClientCertificate == nil {
+		return nil, errPeerNotMutualTLS
+	}
+	return &StreamLayer{
+		ln:              ln,
+		serverTLSConfig: serverTLSConfig,
+		peerTLSConfig:   peerTLSConfig,
+	}, nil
+}
+
+// 连接到 addr 上的节点
+// 先发送 PeerStream 字节让对端的 Mux 把连接交给复制层，再进行 TLS 握手
+func (s *StreamLayer) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write([]byte{PeerStream}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	cfg := s.peerTLSConfig
+	if cfg.ServerName == "" {
+		// 没有指定服务端名字时使用对端节点的主机名验证其证书
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// 接受一个来自其他节点的连接
+// PeerStream 字节已经被 Mux 读取，这里直接进行 TLS 握手
+func (s *StreamLayer) Accept() (net.Conn, error) {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(conn, s.serverTLSConfig), nil
+}
+
+func (s *StreamLayer) Close() error {
+	return s.ln.Close()
+}
+
+func (s *StreamLayer) Addr() net.Addr {
+	return s.ln.Addr()
+}
@@ -0,0 +1,248 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// 一个用户的限额，零表示不限制
+//
+// 每秒的限额同时也是突发的上限，比如 appends_per_second 为 100 时
+// 空闲了一段时间的用户可以一次追加 100 条记录，之后每秒只能追加 100 条
+type Limits struct {
+	AppendsPerSecond float64 `json:"appends_per_second,omitempty"`
+	BytesPerSecond   float64 `json:"bytes_per_second,omitempty"`
+	ReadsPerSecond   float64 `json:"reads_per_second,omitempty"`
+
+	// 同时进行的请求（每个 gRPC 请求都是一个 HTTP/2 流）的数量
+	MaxStreams int `json:"max_streams,omitempty"`
+}
+
+// 限额文件的格式，比如
+//
+//	{
+//	  "default": {"appends_per_second": 1000, "bytes_per_second": 10485760},
+//	  "roles": {"reader": {"reads_per_second": 500}},
+//	  "subjects": {"root user": {}}
+//	}
+//
+// 用户自己的限额优先，其次是用户拥有的角色的限额（直接拥有的角色优先于继承的角色）
+// 都没有时使用 default
+type config struct {
+	Default  Limits            `json:"default"`
+	Roles    map[string]Limits `json:"roles"`
+	Subjects map[string]Limits `json:"subjects"`
+}
+
+// 超出限额时建议客户端等待的最短时间
+const minRetryDelay = 10 * time.Millisecond
+
+// 按照认证得到的用户身份限制请求的速率和并发数
+// 每个用户都有自己的令牌桶，即使多个用户使用同一个角色的限额
+type Manager struct {
+	file   string
+	roles  func(subject string) []string
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	config config
+	usage  map[string]*usage
+}
+
+type usage struct {
+	appends bucket
+	bytes   bucket
+	reads   bucket
+	streams int
+}
+
+// 参数 roles 返回用户拥有的所有角色，可以为空
+func NewManager(file string, roles func(subject string) []string) (*Manager, error) {
+	m := &Manager{
+		file:   file,
+		roles:  roles,
+		logger: zap.L().Named("quota"),
+		usage:  make(map[string]*usage),
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 重新读取限额文件，修改限额时不需要重启服务器
+// 已经在进行的请求不受影响
+func (m *Manager) Reload() error {
+	b, err := os.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("failed to parse quota file %q: %w", m.file, err)
+	}
+	m.mu.Lock()
+	m.config = c
+	m.mu.Unlock()
+	m.logger.Info(
+		"reloaded quotas",
+		zap.String("file", m.file),
+		zap.Int("roles", len(c.Roles)),
+		zap.Int("subjects", len(c.Subjects)),
+	)
+	return nil
+}
+
+func (m *Manager) Files() []string {
+	return []string{m.file}
+}
+
+func (m *Manager) limits(subject string, roles []string) Limits {
+	if l, ok := m.config.Subjects[subject]; ok {
+		return l
+	}
+	for _, role := range roles {
+		if l, ok := m.config.Roles[role]; ok {
+			return l
+		}
+	}
+	return m.config.Default
+}
+
+// 追加一条 bytes 字节的记录
+func (m *Manager) AllowAppend(subject string, bytes int) error {
+	return m.take(subject, func(u *usage, l Limits, now time.Time) error {
+		appendDelay := u.appends.delay(l.AppendsPerSecond, 1, now)
+		bytesDelay := u.bytes.delay(l.BytesPerSecond, float64(bytes), now)
+		switch {
+		case appendDelay > 0:
+			return exceeded(subject, "appends_per_second", appendDelay)
+		case bytesDelay > 0:
+			return exceeded(subject, "bytes_per_second", bytesDelay)
+		}
+		u.appends.take(1)
+		u.bytes.take(float64(bytes))
+		return nil
+	})
+}
+
+// 读取一条记录
+func (m *Manager) AllowRead(subject string) error {
+	return m.take(subject, func(u *usage, l Limits, now time.Time) error {
+		if d := u.reads.delay(l.ReadsPerSecond, 1, now); d > 0 {
+			return exceeded(subject, "reads_per_second", d)
+		}
+		u.reads.take(1)
+		return nil
+	})
+}
+
+// 开始一个请求，请求结束时需要调用返回的 release
+func (m *Manager) Acquire(subject string) (func(), error) {
+	var release func()
+	err := m.take(subject, func(u *usage, l Limits, _ time.Time) error {
+		if l.MaxStreams > 0 && u.streams >= l.MaxStreams {
+			return exceeded(subject, "max_streams", minRetryDelay)
+		}
+		u.streams++
+		release = func() {
+			m.mu.Lock()
+			u.streams--
+			m.mu.Unlock()
+		}
+		return nil
+	})
+	return release, err
+}
+
+func (m *Manager) take(subject string, fn func(*usage, Limits, time.Time) error) error {
+	var roles []string
+	if m.roles != nil {
+		roles = m.roles(subject)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.usage[subject]
+	if !ok {
+		u = &usage{}
+		m.usage[subject] = u
+	}
+	return fn(u, m.limits(subject, roles), time.Now())
+}
+
+// 返回 ResourceExhausted 错误
+// 错误的详细信息中包含超出的限额（QuotaFailure）和建议的重试时间（RetryInfo）
+func exceeded(subject, limit string, retryAfter time.Duration) error {
+	if retryAfter < minRetryDelay {
+		retryAfter = minRetryDelay
+	}
+	st := status.New(
+		codes.ResourceExhausted,
+		fmt.Sprintf("%s exceeded %s quota, retry after %s", subject, limit, retryAfter),
+	)
+	detailed, err := st.WithDetails(
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     subject,
+			Description: limit,
+		}}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// 令牌桶，每秒补充 rate 个令牌，最多存放 rate 个（至少一个）令牌
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// 补充令牌，返回取出 n 个令牌需要等待的时间，rate 为零时不限制
+// 一次取出的令牌比桶的容量还多时，桶满就允许取出，之后的请求需要等待更久
+func (b *bucket) delay(rate, n float64, now time.Time) time.Duration {
+	if rate <= 0 {
+		b.rate = 0
+		return 0
+	}
+	burst := math.Max(rate, 1)
+	switch {
+	case b.rate == 0 || b.last.IsZero():
+		// 第一次使用或者之前不限制
+		b.tokens = burst
+	case b.rate != rate:
+		// 限额发生了变化，按照原来的速率补充到现在，剩余的令牌不能超过新的容量
+		// 否则修改限额（包括调低限额）会让用户立即获得一整桶令牌
+		old := math.Max(b.rate, 1)
+		b.tokens = math.Min(old, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.tokens = math.Min(burst, b.tokens)
+	default:
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.rate, b.last = rate, now
+
+	need := math.Min(n, burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / rate * float64(time.Second))
+}
+
+func (b *bucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
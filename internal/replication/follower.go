@@ -0,0 +1,305 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/trace"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// 复制层的 follower 端，把 leader 的记录按照相同的下标追加到本地日志
+// follower 不接受客户端的写入，读取时按照请求的一致性级别检查自己的复制状态
+type Follower struct {
+	Config
+	logger  *zap.Logger
+	started time.Time
+
+	mu sync.Mutex
+	// 当前连接，没有连接到 leader 时为空
+	enc *json.Encoder
+	// leader 最近一次发送的日志末尾和收到的时间
+	leaderNext uint64
+	synced     time.Time
+	// 等待回复的 read index 请求
+	lastReadIndex uint64
+	readIndexes   map[uint64]chan uint64
+	// 每次收到 leader 的消息之后关闭并替换，用于等待本地日志追上 read index
+	updated chan struct{}
+}
+
+// 在 replication 包中的 *replication.Follower 实现了 Replica 和 WriteChecker 接口
+var (
+	_ logserver.Replica      = (*Follower)(nil)
+	_ logserver.WriteChecker = (*Follower)(nil)
+)
+
+func NewFollower(c Config) (*Follower, error) {
+	if c.Log == nil {
+		return nil, errNoLog
+	}
+	if c.Leader == "" {
+		return nil, errNoLeader
+	}
+	if c.Dialer == nil {
+		return nil, errNoDialer
+	}
+	c.setDefaults()
+	return &Follower{
+		Config:      c,
+		logger:      zap.L().Named("replication").With(zap.String("leader", c.Leader)),
+		started:     time.Now(),
+		readIndexes: make(map[uint64]chan uint64),
+		updated:     make(chan struct{}),
+	}, nil
+}
+
+// 一直复制 leader 的日志直到 ctx 被取消，连接断开后每隔 RetryInterval 重新连接
+func (f *Follower) Run(ctx context.Context) {
+	for {
+		err := f.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// 本地日志已经重置，立即从 leader 的开头重新复制
+		if errors.Is(err, errDiverged) {
+			continue
+		}
+		f.logger.Warn("replication interrupted", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.RetryInterval):
+		}
+	}
+}
+
+func (f *Follower) follow(ctx context.Context) error {
+	conn, err := f.Dialer.Dial(f.Leader, f.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	lowest, err := f.Log.LowestOffset()
+	if err != nil {
+		return err
+	}
+	next, err := f.Log.NextOffset()
+	if err != nil {
+		return err
+	}
+	req := request{Topic: f.Topic, Partition: f.Partition, Next: next}
+	if next > lowest {
+		last, err := f.Log.Read(next - 1)
+		if err != nil {
+			return err
+		}
+		if req.Last, err = proto.Marshal(last); err != nil {
+			return err
+		}
+	}
+	if err := f.connect(conn, req); err != nil {
+		return err
+	}
+	defer f.disconnect()
+	f.logger.Info("following leader", zap.Uint64("offset", next))
+
+	dec := json.NewDecoder(conn)
+	for {
+		// leader 每隔 PollInterval 发送一次心跳
+		conn.SetReadDeadline(time.Now().Add(f.Timeout))
+		var rsp response
+		if err := dec.Decode(&rsp); err != nil {
+			return err
+		}
+		if rsp.Diverged {
+			return f.diverge(rsp, lowest, next)
+		}
+		for i, b := range rsp.Records {
+			record := &api.Record{}
+			if err := proto.Unmarshal(b, record); err != nil {
+				return err
+			}
+			want := record.Offset
+			var traceparent string
+			if i < len(rsp.Traceparents) {
+				traceparent = rsp.Traceparents[i]
+			}
+			off, err := f.append(ctx, record, traceparent)
+			if err != nil {
+				return err
+			}
+			if off != want {
+				return fmt.Errorf("leader record %d appended at offset %d", want, off)
+			}
+			next = off + 1
+		}
+		f.update(rsp)
+	}
+}
+
+// 记录带有有效的 traceparent 并且设置了 Tracer 时在 leader 的 trace 中追加
+func (f *Follower) append(ctx context.Context, record *api.Record, traceparent string) (off uint64, err error) {
+	a, ok := f.Log.(logserver.ContextAppender)
+	if !ok || f.Tracer == nil || traceparent == "" {
+		return f.Log.Append(record)
+	}
+	remote, err := trace.ParseTraceparent(traceparent)
+	if err != nil {
+		return f.Log.Append(record)
+	}
+	ctx, span := f.Tracer.StartRemote(ctx, "replication.Append", remote)
+	defer func() {
+		span.SetAttribute("leader", f.Leader)
+		span.SetAttribute("offset", off)
+		span.SetError(err)
+		span.End()
+	}()
+	return a.AppendContext(ctx, record)
+}
+
+// leader 被重置或者恢复了归档之后本地日志不再是 leader 的日志的前缀
+// 重置本地日志（被删除的记录保存在归档中）后从 leader 的开头重新复制
+func (f *Follower) diverge(rsp response, lowest, next uint64) error {
+	if next == lowest {
+		return fmt.Errorf("empty log starts at offset %d but leader log starts at %d", next, rsp.Lowest)
+	}
+	f.logger.Warn(
+		"log diverged from leader, resetting",
+		zap.Uint64("offset", next),
+		zap.Uint64("leader_lowest", rsp.Lowest),
+		zap.Uint64("leader_next", rsp.Next),
+	)
+	if err := f.Log.Reset(); err != nil {
+		return err
+	}
+	return errDiverged
+}
+
+func (f *Follower) connect(conn net.Conn, req request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	enc := json.NewEncoder(conn)
+	if err := enc.Encode(req); err != nil {
+		return err
+	}
+	f.enc = enc
+	return nil
+}
+
+// 连接断开时还没有回复的 read index 请求都会失败
+func (f *Follower) disconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enc = nil
+	for id, ch := range f.readIndexes {
+		close(ch)
+		delete(f.readIndexes, id)
+	}
+}
+
+func (f *Follower) update(rsp response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leaderNext = rsp.Next
+	f.synced = time.Now()
+	if ch, ok := f.readIndexes[rsp.ReadIndex]; ok {
+		ch <- rsp.Next
+		delete(f.readIndexes, rsp.ReadIndex)
+	}
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+func (f *Follower) CommitIndex() uint64 {
+	next, err := f.Log.NextOffset()
+	if err != nil || next == 0 {
+		return 0
+	}
+	return next - 1
+}
+
+// 从来没有和 leader 同步过时从启动开始计算
+func (f *Follower) Lag() (uint64, time.Duration) {
+	f.mu.Lock()
+	leaderNext, synced := f.leaderNext, f.synced
+	f.mu.Unlock()
+	if synced.IsZero() {
+		synced = f.started
+	}
+	var offsets uint64
+	if next, err := f.Log.NextOffset(); err == nil && leaderNext > next {
+		offsets = leaderNext - next
+	}
+	return offsets, time.Since(synced)
+}
+
+// 向 leader 请求它在收到请求之后的日志末尾，并等待本地日志追上
+func (f *Follower) ReadIndex(ctx context.Context) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	f.mu.Lock()
+	if f.enc == nil {
+		f.mu.Unlock()
+		return 0, errNotConnected
+	}
+	f.lastReadIndex++
+	id := f.lastReadIndex
+	ch := make(chan uint64, 1)
+	f.readIndexes[id] = ch
+	if err := f.enc.Encode(request{ReadIndex: id}); err != nil {
+		delete(f.readIndexes, id)
+		f.mu.Unlock()
+		return 0, err
+	}
+	f.mu.Unlock()
+
+	var leaderNext uint64
+	select {
+	case next, ok := <-ch:
+		if !ok {
+			return 0, errNotConnected
+		}
+		leaderNext = next
+	case <-ctx.Done():
+		f.mu.Lock()
+		delete(f.readIndexes, id)
+		f.mu.Unlock()
+		return 0, ctx.Err()
+	}
+	var readIndex uint64
+	if leaderNext > 0 {
+		readIndex = leaderNext - 1
+	}
+
+	for {
+		f.mu.Lock()
+		updated := f.updated
+		f.mu.Unlock()
+		if f.CommitIndex() >= readIndex {
+			return readIndex, nil
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// follower 的日志只能由复制层写入
+func (f *Follower) CheckWritable() error {
+	return fmt.Errorf("this node is a read-only follower of %s, send writes to the leader", f.Leader)
+}
@@ -0,0 +1,197 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/youngfr/dcls/internal/controller"
+	"go.uber.org/zap"
+)
+
+// 根据节点名找到它的复制地址（和 gRPC 共用的端口）
+type Resolver interface {
+	Addr(node string) (string, error)
+}
+
+type MoverConfig struct {
+	// Dialer、PollInterval、Timeout 和 RetryInterval 用于复制分区
+	Config
+
+	// 本节点的名字和按分区保存的日志
+	NodeName   string
+	Partitions Partitions
+
+	Resolver Resolver
+}
+
+var (
+	errNoNodeName = errors.New("no node name provided for mover")
+	errNoResolver = errors.New("no resolver provided for mover")
+)
+
+// 在节点之间移动分区的数据
+//
+// 每个节点的控制器计算出相同的分配结果，所以每个节点都会对同一次移动调用 Move
+// 只有目标节点真正复制数据：它启动一个从源节点复制这个分区的 follower
+// 复制期间源节点照常写入，不需要暂停
+// 所有节点都通过 Probe 请求比较源节点和目标节点的日志，目标节点追上源节点时 Move 返回
+//
+// 目标节点的 follower 在 Move 返回之后继续运行，让新的副本和源节点保持同步，直到 Close 被调用
+type Mover struct {
+	MoverConfig
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	followers map[partitionKey]*runningFollower
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+type runningFollower struct {
+	leader string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// 在 replication 包中的 *replication.Mover 实现了 controller.Mover 接口
+var _ controller.Mover = (*Mover)(nil)
+
+func NewMover(c MoverConfig) (*Mover, error) {
+	if c.NodeName == "" {
+		return nil, errNoNodeName
+	}
+	if c.Partitions == nil {
+		return nil, errNoPartitions
+	}
+	if c.Dialer == nil {
+		return nil, errNoDialer
+	}
+	if c.Resolver == nil {
+		return nil, errNoResolver
+	}
+	c.setDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Mover{
+		MoverConfig: c,
+		logger:      zap.L().Named("mover").With(zap.String("node", c.NodeName)),
+		followers:   make(map[partitionKey]*runningFollower),
+		ctx:         ctx,
+		cancel:      cancel,
+	}, nil
+}
+
+func (m *Mover) Move(ctx context.Context, topic string, partition int, from, to string) error {
+	fromAddr, err := m.Resolver.Addr(from)
+	if err != nil {
+		return err
+	}
+	toAddr, err := m.Resolver.Addr(to)
+	if err != nil {
+		return err
+	}
+	if to == m.NodeName {
+		if err := m.follow(topic, partition, fromAddr); err != nil {
+			return err
+		}
+	}
+
+	for {
+		// 先查询源节点，目标节点之后追上这个位置就说明复制已经追上了
+		src, err := m.probe(fromAddr, topic, partition)
+		if err == nil {
+			var dst *response
+			if dst, err = m.probe(toAddr, topic, partition); err == nil && dst.Lowest == src.Lowest && dst.Next >= src.Next {
+				m.logger.Info(
+					"moved partition",
+					zap.String("topic", topic),
+					zap.Int("partition", partition),
+					zap.String("from", from),
+					zap.String("to", to),
+					zap.Uint64("offset", dst.Next),
+				)
+				return nil
+			}
+		}
+		if err != nil {
+			m.logger.Debug("failed to probe partition", zap.String("topic", topic), zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.PollInterval):
+		}
+	}
+}
+
+// 在本节点启动从 leader 复制分区的 follower
+// 已经在从同一个节点复制时什么也不做，从其他节点复制时换成新的 leader
+func (m *Mover) follow(topic string, partition int, leader string) error {
+	key := partitionKey{topic: topic, partition: partition}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return m.ctx.Err()
+	}
+	if f, ok := m.followers[key]; ok {
+		if f.leader == leader {
+			return nil
+		}
+		// 等旧的 follower 退出，两个 follower 不能同时写同一个日志
+		f.cancel()
+		<-f.done
+	}
+	log, err := m.Partitions.PartitionLog(topic, partition)
+	if err != nil {
+		return err
+	}
+	c := m.Config
+	c.Log = log
+	c.Leader = leader
+	c.Topic = topic
+	c.Partition = partition
+	follower, err := NewFollower(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	running := &runningFollower{leader: leader, cancel: cancel, done: make(chan struct{})}
+	m.followers[key] = running
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(running.done)
+		follower.Run(ctx)
+	}()
+	return nil
+}
+
+// 查询 addr 上的节点中分区日志的起止下标
+func (m *Mover) probe(addr, topic string, partition int) (*response, error) {
+	conn, err := m.Dialer.Dial(addr, m.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(m.Timeout))
+	if err := json.NewEncoder(conn).Encode(request{Topic: topic, Partition: partition, Probe: true}); err != nil {
+		return nil, err
+	}
+	var rsp response
+	if err := json.NewDecoder(conn).Decode(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to probe %s: %w", addr, err)
+	}
+	return &rsp, nil
+}
+
+// 停止所有复制分区的 follower
+func (m *Mover) Close() error {
+	m.cancel()
+	m.wg.Wait()
+	return nil
+}
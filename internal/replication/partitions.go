@@ -0,0 +1,74 @@
+package replication
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	dclslog "github.com/youngfr/dcls/internal/log"
+)
+
+// 按照主题和分区保存的日志
+type Partitions interface {
+	PartitionLog(topic string, partition int) (Log, error)
+}
+
+// 每个分区的日志保存在 dir 下的 <主题名>-<分区号> 目录中，第一次使用时创建
+type PartitionLogs struct {
+	dir    string
+	config dclslog.Config
+
+	mu   sync.Mutex
+	logs map[partitionKey]*dclslog.Log
+}
+
+type partitionKey struct {
+	topic     string
+	partition int
+}
+
+var _ Partitions = (*PartitionLogs)(nil)
+
+func NewPartitionLogs(dir string, c dclslog.Config) (*PartitionLogs, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &PartitionLogs{dir: dir, config: c, logs: make(map[partitionKey]*dclslog.Log)}, nil
+}
+
+func (p *PartitionLogs) PartitionLog(topic string, partition int) (Log, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := partitionKey{topic: topic, partition: partition}
+	if l, ok := p.logs[key]; ok {
+		return l, nil
+	}
+	// 主题名中可能有路径分隔符
+	dir := filepath.Join(p.dir, fmt.Sprintf("%s-%d", url.PathEscape(topic), partition))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l, err := dclslog.NewLog(dir, p.config)
+	if err != nil {
+		return nil, err
+	}
+	p.logs[key] = l
+	return l, nil
+}
+
+func (p *PartitionLogs) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for key, l := range p.logs {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+		delete(p.logs, key)
+	}
+	return err
+}
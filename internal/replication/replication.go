@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/mux"
+	"github.com/youngfr/dcls/internal/trace"
+)
+
+// 节点之间的日志复制
+//
+// 每个节点都在 mux.StreamLayer 上运行 Server，把本地日志发送给连接上来的 follower
+// follower 通过 StreamLayer 连接 leader，把 leader 的记录按照相同的下标追加到本地日志
+//
+// 连接建立后 follower 发送一次自己的进度（下一条记录的下标和最后一条记录）
+// leader 从这个位置开始持续地发送记录，没有新记录时每隔 PollInterval 发送一次心跳
+// leader 找不到 follower 的最后一条记录时（比如 leader 被重置或者恢复了归档）
+// 回复 Diverged，follower 重置本地日志后重新连接
+//
+// leader 记得追加一条记录的请求的 trace context 时（参见 TracedLog）把它和记录一起发送
+// follower 追加这条记录的 span 和 leader 上的请求属于同一个 trace
+//
+// 之后 follower 只会发送 read index 请求，leader 在下一条消息中带上收到请求时日志的末尾
+//
+// 请求中带有主题名时复制的是这个主题的一个分区的日志，参见 Partitions 和 Mover
+// Probe 请求只查询日志的起止下标，leader 回复一条不带记录的消息后关闭连接
+
+// 复制层读写的日志
+type Log interface {
+	Append(*api.Record) (uint64, error)
+	Read(uint64) (*api.Record, error)
+	Reset() error
+	LowestOffset() (uint64, error)
+	NextOffset() (uint64, error)
+}
+
+// 在 log 包中的 *log.Log 实现了 Log 接口
+var _ Log = (*log.Log)(nil)
+
+// follower 连接 leader 使用的传输层
+type Dialer interface {
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+// 在 mux 包中的 *mux.StreamLayer 实现了 Dialer 接口
+var _ Dialer = (*mux.StreamLayer)(nil)
+
+type Config struct {
+	// 本地日志，leader 从中读取记录，follower 向其中追加记录
+	Log Log
+
+	// leader 上按分区保存的日志，为空时只能复制 Log
+	Partitions Partitions
+
+	// follower 复制的分区，Topic 为空时复制 leader 的 Log
+	Topic     string
+	Partition int
+
+	// leader 的地址和连接它使用的传输层，只有 follower 需要
+	Leader string
+	Dialer Dialer
+
+	// leader 没有新记录时隔多久再检查一次，也是向 follower 发送心跳的间隔
+	PollInterval time.Duration
+
+	// leader 的每条消息最多包含多少条记录
+	MaxBatch int
+
+	// 建立连接、等待对方的消息和等待 read index 的超时时间
+	Timeout time.Duration
+
+	// follower 和 leader 的连接断开后隔多久重新连接
+	RetryInterval time.Duration
+
+	// follower 追加 leader 的记录时使用的 Tracer，为空时不追踪
+	// 记录带有 traceparent 时追加的 span 成为 leader 上追加请求的 span 的子 span
+	Tracer *trace.Tracer
+}
+
+func (c *Config) setDefaults() {
+	if c.PollInterval == 0 {
+		c.PollInterval = 100 * time.Millisecond
+	}
+	if c.MaxBatch == 0 {
+		c.MaxBatch = 256
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = time.Second
+	}
+}
+
+// follower 发送的请求，以 JSON 编码
+type request struct {
+	// 第一个请求中是 follower 下一条记录的下标和最后一条记录（日志为空时没有）
+	Next uint64 `json:"next,omitempty"`
+	Last []byte `json:"last,omitempty"`
+
+	// 复制的分区，主题为空时复制 leader 的日志
+	Topic     string `json:"topic,omitempty"`
+	Partition int    `json:"partition,omitempty"`
+
+	// 只查询日志的起止下标
+	Probe bool `json:"probe,omitempty"`
+
+	// 之后的请求只有 read index 请求的编号
+	ReadIndex uint64 `json:"read_index,omitempty"`
+}
+
+// leader 发送的消息，以 JSON 编码
+type response struct {
+	// leader 日志的第一条记录和下一条记录的下标
+	Lowest uint64 `json:"lowest"`
+	Next   uint64 `json:"next"`
+
+	// 接着 follower 上一条记录的连续的记录
+	Records [][]byte `json:"records,omitempty"`
+
+	// 和 Records 一一对应，是追加每条记录的请求的 traceparent，不属于任何 trace 的记录为空
+	Traceparents []string `json:"traceparents,omitempty"`
+
+	// follower 的日志不再是 leader 的日志的前缀
+	Diverged bool `json:"diverged,omitempty"`
+
+	// 这条消息回复的 read index 请求的编号，Next 是收到请求之后读取的
+	ReadIndex uint64 `json:"read_index,omitempty"`
+}
+
+var (
+	errNoLog              = errors.New("no log provided for replication")
+	errNoPartitions       = errors.New("no partitions provided for replication")
+	errNoLeader           = errors.New("no leader address provided for follower")
+	errNoDialer           = errors.New("no dialer provided for follower")
+	errNotTLS             = errors.New("peer connection is not a TLS connection")
+	errNoPeerCertificate  = errors.New("peer provided no certificate")
+	errNotNodeCertificate = errors.New("peer certificate is not a node certificate")
+	errNotConnected       = errors.New("not connected to leader")
+	errDiverged           = errors.New("log diverged from leader")
+)
+
+// 完成 TLS 握手并确认对端使用的是节点的证书（带有 ServerAuth 用途）
+// 同一个根证书签发的客户端证书只有 ClientAuth 用途，持有它的用户不能绕过访问控制复制整个日志
+func verifyPeer(conn net.Conn, timeout time.Duration) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return errNotTLS
+	}
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return errNoPeerCertificate
+	}
+	for _, usage := range certs[0].ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return nil
+		}
+	}
+	return errNotNodeCertificate
+}
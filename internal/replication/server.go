@@ -0,0 +1,288 @@
+package replication
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// 复制层的 leader 端，把本地日志发送给连接上来的 follower
+type Server struct {
+	Config
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	ln      net.Listener
+	conns   map[net.Conn]struct{}
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewServer(c Config) (*Server, error) {
+	if c.Log == nil {
+		return nil, errNoLog
+	}
+	c.setDefaults()
+	return &Server{
+		Config:  c,
+		logger:  zap.L().Named("replication"),
+		conns:   make(map[net.Conn]struct{}),
+		closing: make(chan struct{}),
+	}, nil
+}
+
+// 接受 ln 上的 follower 的连接直到 Close 被调用
+// ln 一般是 mux.StreamLayer，它的连接还没有进行 TLS 握手
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.closing:
+				return nil
+			default:
+				return err
+			}
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.handle(conn)
+		}()
+	}
+}
+
+// 停止接受连接，断开所有 follower 并等待处理连接的协程退出
+func (s *Server) Close() error {
+	s.mu.Lock()
+	select {
+	case <-s.closing:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	close(s.closing)
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closing:
+		return false
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	logger := s.logger.With(zap.String("peer", conn.RemoteAddr().String()))
+	if err := verifyPeer(conn, s.Timeout); err != nil {
+		logger.Warn("rejected peer", zap.Error(err))
+		return
+	}
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	var req request
+	conn.SetReadDeadline(time.Now().Add(s.Timeout))
+	if err := dec.Decode(&req); err != nil {
+		logger.Warn("failed to read replication request", zap.Error(err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	log, err := s.log(req.Topic, req.Partition)
+	if err != nil {
+		logger.Warn("failed to open log for follower", zap.String("topic", req.Topic), zap.Error(err))
+		return
+	}
+	if req.Probe {
+		rsp, err := fetch(log, 0, nil, 0)
+		if err != nil {
+			logger.Warn("failed to read offsets for probe", zap.Error(err))
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		enc.Encode(rsp)
+		return
+	}
+	next := req.Next
+	var last *api.Record
+	if len(req.Last) > 0 {
+		last = &api.Record{}
+		if err := proto.Unmarshal(req.Last, last); err != nil {
+			logger.Warn("invalid last record from follower", zap.Error(err))
+			return
+		}
+	}
+	logger.Info(
+		"follower connected",
+		zap.String("topic", req.Topic),
+		zap.Int("partition", req.Partition),
+		zap.Uint64("offset", next),
+	)
+
+	// 之后 follower 只发送 read index 请求
+	readIndexes := make(chan uint64)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for {
+			var req request
+			if err := dec.Decode(&req); err != nil {
+				return
+			}
+			select {
+			case readIndexes <- req.ReadIndex:
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	var readIndex uint64
+	for {
+		rsp, err := fetch(log, next, last, s.MaxBatch)
+		if err != nil {
+			logger.Warn("failed to read records for follower", zap.Error(err))
+			return
+		}
+		rsp.ReadIndex = readIndex
+		readIndex = 0
+		conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		if err := enc.Encode(rsp); err != nil {
+			logger.Info("follower disconnected", zap.Error(err))
+			return
+		}
+		if rsp.Diverged {
+			logger.Info(
+				"follower diverged",
+				zap.Uint64("offset", next),
+				zap.Uint64("lowest", rsp.Lowest),
+				zap.Uint64("next", rsp.Next),
+			)
+			return
+		}
+		if n := len(rsp.Records); n > 0 {
+			next += uint64(n)
+			last = &api.Record{}
+			if err := proto.Unmarshal(rsp.Records[n-1], last); err != nil {
+				return
+			}
+			// 一条消息放不下时立即发送剩下的记录
+			if n == s.MaxBatch {
+				continue
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case readIndex = <-readIndexes:
+		case <-disconnected:
+			logger.Info("follower disconnected")
+			return
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// 主题为空时是本节点的日志，否则是分区的日志
+func (s *Server) log(topic string, partition int) (Log, error) {
+	if topic == "" {
+		return s.Log, nil
+	}
+	if s.Partitions == nil {
+		return nil, errNoPartitions
+	}
+	return s.Partitions.PartitionLog(topic, partition)
+}
+
+// 读取从 next 开始的最多 max 条记录
+// last 是 follower 的最后一条记录，它和 leader 中同一个下标的记录不同时 follower 已经分叉
+func fetch(log Log, next uint64, last *api.Record, max int) (*response, error) {
+	lowest, err := log.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	end, err := log.NextOffset()
+	if err != nil {
+		return nil, err
+	}
+	rsp := &response{Lowest: lowest, Next: end}
+	if max == 0 {
+		return rsp, nil
+	}
+	if next < lowest || next > end || last != nil && !contains(log, last) {
+		rsp.Diverged = true
+		return rsp, nil
+	}
+	traces, _ := log.(TraceSource)
+	traced := false
+	for off := next; off < end && len(rsp.Records) < max; off++ {
+		record, err := log.Read(off)
+		if err != nil {
+			return nil, err
+		}
+		b, err := proto.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		rsp.Records = append(rsp.Records, b)
+		var traceparent string
+		if traces != nil {
+			if sc, ok := traces.TraceContext(off); ok {
+				traceparent = sc.Traceparent()
+				traced = true
+			}
+		}
+		rsp.Traceparents = append(rsp.Traceparents, traceparent)
+	}
+	// 没有一条记录属于某个 trace 时不发送
+	if !traced {
+		rsp.Traceparents = nil
+	}
+	return rsp, nil
+}
+
+func contains(log Log, record *api.Record) bool {
+	got, err := log.Read(record.Offset)
+	return err == nil && proto.Equal(got, record)
+}
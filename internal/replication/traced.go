@@ -0,0 +1,89 @@
+package replication
+
+import (
+	"context"
+	"sync"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/trace"
+)
+
+// 最多记住最近多少条记录的 trace context
+const maxTracedRecords = 4096
+
+// leader 上可以选择实现的接口，返回追加某条记录的请求的 trace context
+// Server 把它和记录一起发送给 follower，follower 追加这条记录的 span 成为它的子 span
+type TraceSource interface {
+	TraceContext(offset uint64) (trace.SpanContext, bool)
+}
+
+// 记住最近追加的记录的 trace context 的日志，其他方法和 *log.Log 相同
+// trace context 只保存在内存中，重启之前追加的记录和落后太多的 follower 复制的记录不属于任何 trace
+type TracedLog struct {
+	*log.Log
+
+	mu      sync.Mutex
+	traces  map[uint64]trace.SpanContext
+	offsets []uint64
+}
+
+// 在 replication 包中的 *replication.TracedLog 实现了 ContextAppender 和 TraceSource 接口
+var (
+	_ logserver.ContextAppender = (*TracedLog)(nil)
+	_ TraceSource               = (*TracedLog)(nil)
+)
+
+func NewTracedLog(l *log.Log) *TracedLog {
+	return &TracedLog{Log: l, traces: make(map[uint64]trace.SpanContext)}
+}
+
+func (l *TracedLog) Append(record *api.Record) (uint64, error) {
+	return l.AppendContext(context.Background(), record)
+}
+
+func (l *TracedLog) AppendContext(ctx context.Context, record *api.Record) (uint64, error) {
+	off, err := l.Log.AppendContext(ctx, record)
+	if err != nil {
+		return off, err
+	}
+	if sc := trace.FromContext(ctx).Context(); sc.IsValid() {
+		l.mu.Lock()
+		l.traces[off] = sc
+		l.offsets = append(l.offsets, off)
+		if len(l.offsets) > maxTracedRecords {
+			delete(l.traces, l.offsets[0])
+			l.offsets = l.offsets[1:]
+		}
+		l.mu.Unlock()
+	}
+	return off, nil
+}
+
+func (l *TracedLog) TraceContext(offset uint64) (trace.SpanContext, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sc, ok := l.traces[offset]
+	return sc, ok
+}
+
+// 重置或者恢复归档之后同一个下标是另一条记录，之前记住的 trace context 不再有效
+func (l *TracedLog) Reset() error {
+	err := l.Log.Reset()
+	l.forget()
+	return err
+}
+
+func (l *TracedLog) RestoreArchive(name string) (string, error) {
+	archived, err := l.Log.RestoreArchive(name)
+	l.forget()
+	return archived, err
+}
+
+func (l *TracedLog) forget() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.traces = make(map[uint64]trace.SpanContext)
+	l.offsets = nil
+}
@@ -0,0 +1,46 @@
+package sign
+
+import (
+	"context"
+	"fmt"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 客户端拦截器，追加记录时自动签名，读取记录时自动验证签名
+// signer 为空时不签名，policy 为空时不验证
+//
+// 读取到的记录带有签名时总是验证签名
+// 没有签名的记录只在主题匹配 policy.Topics 时被拒绝
+// 验证失败时返回 DataLoss 错误
+func UnaryClientInterceptor(signer *Signer, policy *Policy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if r, ok := req.(*api.AppendRequest); ok && signer != nil && r.Record != nil && len(r.Record.Signature) == 0 {
+			// 不修改调用者的记录
+			record := proto.Clone(r.Record).(*api.Record)
+			signer.Sign(record)
+			req = &api.AppendRequest{Record: record}
+		}
+		if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+			return err
+		}
+		if r, ok := reply.(*api.ReadResponse); ok && policy != nil && r.Record != nil {
+			return policy.checkRead(r.Record)
+		}
+		return nil
+	}
+}
+
+func (p *Policy) checkRead(record *api.Record) error {
+	if len(record.Signature) == 0 && !p.requiresSignature(record.Topic) {
+		return nil
+	}
+	if err := p.Registry.Verify(record); err != nil {
+		return status.New(codes.DataLoss, fmt.Sprintf("record %d: %v", record.Offset, err)).Err()
+	}
+	return nil
+}
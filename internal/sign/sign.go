@@ -0,0 +1,261 @@
+package sign
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 记录级别的签名
+//
+// 生产者使用 Ed25519 私钥对记录的主题、键、值、header 和时间戳签名
+// 服务器和消费者使用信任的公钥列表验证签名
+//
+// 以 dcls- 开头的 header 由 dcls 自己的组件添加（比如镜像添加的来源信息），不参与签名
+
+// 不参与签名的 header 的前缀
+const ReservedHeaderPrefix = "dcls-"
+
+// PEM 块中保存密钥名字的 header
+const keyIDHeader = "Key-Id"
+
+var (
+	ErrUnsigned          = errors.New("record is not signed")
+	ErrUnknownKey        = errors.New("record signed by an untrusted key")
+	ErrInvalidSignature  = errors.New("invalid record signature")
+	errNoTrustedKeys     = errors.New("no trusted keys found")
+	errNotEd25519        = errors.New("signing key must be an Ed25519 key")
+	errDuplicateKeyID    = errors.New("duplicate key id")
+	errUnexpectedPEMType = errors.New("unexpected PEM block type")
+)
+
+// 被签名的内容
+// 每个字段都以长度作为前缀，header 按名字排序，所以编码是唯一的
+func Payload(record *api.Record) []byte {
+	names := make([]string, 0, len(record.Headers))
+	for name := range record.Headers {
+		if !strings.HasPrefix(name, ReservedHeaderPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	b := make([]byte, 0, 64+len(record.Key)+len(record.Value))
+	field := func(f []byte) {
+		b = binary.BigEndian.AppendUint64(b, uint64(len(f)))
+		b = append(b, f...)
+	}
+	field([]byte("dcls record v1"))
+	field([]byte(record.Topic))
+	field(record.Key)
+	field(record.Value)
+	b = binary.BigEndian.AppendUint64(b, uint64(record.TimestampMs))
+	b = binary.BigEndian.AppendUint64(b, uint64(len(names)))
+	for _, name := range names {
+		field([]byte(name))
+		field(record.Headers[name])
+	}
+	return b
+}
+
+// 生产者的签名密钥
+type Signer struct {
+	KeyID string
+	Key   ed25519.PrivateKey
+}
+
+// 从 PEM 编码的 PKCS #8 私钥文件中加载签名密钥
+// 密钥的名字是 PEM 块的 Key-Id header，没有时使用公钥的指纹
+func LoadSigner(file string) (*Signer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%w in %q: expected PRIVATE KEY", errUnexpectedPEMType, file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", errNotEd25519, key)
+	}
+	id := block.Headers[keyIDHeader]
+	if id == "" {
+		id = Fingerprint(priv.Public().(ed25519.PublicKey))
+	}
+	return &Signer{KeyID: id, Key: priv}, nil
+}
+
+// 签名并设置记录的 signature 和 key_id，没有时间戳的记录使用当前时间
+func (s *Signer) Sign(record *api.Record) {
+	if record.TimestampMs == 0 {
+		record.TimestampMs = time.Now().UnixMilli()
+	}
+	record.KeyId = s.KeyID
+	record.Signature = ed25519.Sign(s.Key, Payload(record))
+}
+
+// 公钥的指纹，SHA-256 的前 8 个字节
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// 信任的公钥列表
+// 文件中的每个 PEM 块是一个公钥，它的名字是 Key-Id header，没有时使用公钥的指纹
+//
+//	-----BEGIN PUBLIC KEY-----
+//	Key-Id: orders-producer
+//
+//	MCowBQYDK2VwAyEA...
+//	-----END PUBLIC KEY-----
+type Registry struct {
+	file   string
+	logger *zap.Logger
+
+	mu   sync.RWMutex
+	keys map[string]ed25519.PublicKey
+}
+
+func NewRegistry(file string) (*Registry, error) {
+	r := &Registry{file: file, logger: zap.L().Named("sign")}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 重新读取公钥文件，添加或撤销密钥时不需要重启服务器
+func (r *Registry) Reload() error {
+	b, err := os.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]ed25519.PublicKey)
+	for rest := b; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			return fmt.Errorf("%w in %q: %s", errUnexpectedPEMType, r.file, block.Type)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %T", errNotEd25519, pub)
+		}
+		id := block.Headers[keyIDHeader]
+		if id == "" {
+			id = Fingerprint(key)
+		}
+		if _, ok := keys[id]; ok {
+			return fmt.Errorf("%w %q in %q", errDuplicateKeyID, id, r.file)
+		}
+		keys[id] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%w in %q", errNoTrustedKeys, r.file)
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+	r.logger.Info("reloaded trusted keys", zap.String("file", r.file), zap.Int("keys", len(keys)))
+	return nil
+}
+
+func (r *Registry) Files() []string {
+	return []string{r.file}
+}
+
+// 验证记录的签名
+func (r *Registry) Verify(record *api.Record) error {
+	if len(record.Signature) == 0 {
+		return ErrUnsigned
+	}
+	r.mu.RLock()
+	key, ok := r.keys[record.KeyId]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, record.KeyId)
+	}
+	if !ed25519.Verify(key, Payload(record), record.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// 服务器端的签名策略
+// 匹配 Topics 中任意一个模式（path.Match 的语法，比如 audit.*）的主题只接受带有有效签名的记录
+type Policy struct {
+	Registry *Registry
+	Topics   []string
+}
+
+func (p *Policy) requiresSignature(topic string) bool {
+	for _, pattern := range p.Topics {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 主题要求签名时，没有签名的记录返回 FailedPrecondition，签名无效的记录返回 InvalidArgument
+func (p *Policy) CheckAppend(record *api.Record) error {
+	if !p.requiresSignature(record.Topic) {
+		return nil
+	}
+	err := p.Registry.Verify(record)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrUnsigned):
+		msg := fmt.Sprintf("topic %q only accepts signed records", record.Topic)
+		return status.New(codes.FailedPrecondition, msg).Err()
+	default:
+		return status.New(codes.InvalidArgument, err.Error()).Err()
+	}
+}
+
+// 以 PEM 格式编码私钥和公钥，两个 PEM 块都带有 Key-Id header
+// 私钥交给生产者，公钥追加到服务器和消费者的 trusted-keys.pem 中
+func EncodeKeyPair(keyID string, key ed25519.PrivateKey) (privPEM, pubPEM []byte, err error) {
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := map[string]string{keyIDHeader: keyID}
+	privPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Headers: headers, Bytes: priv})
+	pubPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: headers, Bytes: pub})
+	return privPEM, pubPEM, nil
+}
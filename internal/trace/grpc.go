@@ -0,0 +1,61 @@
+package trace
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 保存 traceparent 的 gRPC metadata 的键
+const TraceparentKey = "traceparent"
+
+// 客户端拦截器，为每个请求开始一个 span 并把 trace context 放在 metadata 中
+// 复制层等服务器之间的调用也应该使用它，这样被复制的追加属于同一个 trace
+func UnaryClientInterceptor(t *Tracer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := t.Start(ctx, method)
+		defer span.End()
+		span.SetAttribute("rpc.target", cc.Target())
+		ctx = metadata.AppendToOutgoingContext(ctx, TraceparentKey, span.Context().Traceparent())
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		span.SetAttribute("rpc.code", status.Code(err).String())
+		span.SetError(err)
+		return err
+	}
+}
+
+// 服务器拦截器，请求带有 traceparent 时作为远程 span 的子 span，否则开始一个新的 trace
+// 无效的 traceparent 被忽略
+func UnaryServerInterceptor(t *Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var span *Span
+		if remote, ok := remoteSpanContext(ctx); ok {
+			ctx, span = t.StartRemote(ctx, info.FullMethod, remote)
+		} else {
+			ctx, span = t.Start(ctx, info.FullMethod)
+		}
+		defer span.End()
+		resp, err := handler(ctx, req)
+		span.SetAttribute("rpc.code", status.Code(err).String())
+		span.SetError(err)
+		return resp, err
+	}
+}
+
+func remoteSpanContext(ctx context.Context) (SpanContext, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return SpanContext{}, false
+	}
+	values := md.Get(TraceparentKey)
+	if len(values) == 0 {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(values[0])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
@@ -0,0 +1,201 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 分布式追踪
+//
+// 一次请求经过的每个步骤（客户端调用、服务器处理、日志追加……）都是一个 span
+// 同一次请求的所有 span 有相同的 trace id，每个 span 记录它的父 span
+// 跨进程时 trace context 以 W3C Trace Context 的 traceparent 格式放在 gRPC metadata 中
+//
+// span 通过 context 传递，context 中没有 span 时 Start 返回空的 span
+// 空的 span 的所有方法都什么也不做，所以存储层不需要知道是否开启了追踪
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// 跨进程传播的 trace context
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// 编码为 traceparent，比如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("%w: %q", errInvalidTraceparent, s)
+	}
+	if n, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || n != len(sc.TraceID) || len(parts[1]) != 2*n {
+		return sc, fmt.Errorf("%w: %q", errInvalidTraceparent, s)
+	}
+	if n, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || n != len(sc.SpanID) || len(parts[2]) != 2*n {
+		return sc, fmt.Errorf("%w: %q", errInvalidTraceparent, s)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("%w: %q", errInvalidTraceparent, s)
+	}
+	return sc, nil
+}
+
+// 结束后交给 Exporter 的 span 的内容
+type SpanData struct {
+	Name       string         `json:"name"`
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_id,omitempty"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// 导出结束的 span，参见 exporter.go
+type Exporter interface {
+	Export(SpanData) error
+}
+
+type Tracer struct {
+	exporter Exporter
+	logger   *zap.Logger
+}
+
+func NewTracer(e Exporter) *Tracer {
+	return &Tracer{exporter: e, logger: zap.L().Named("trace")}
+}
+
+// 开始一个新的 span，context 中有 span 时作为它的子 span，否则开始一个新的 trace
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent != nil {
+		return t.start(ctx, name, parent.sc.TraceID, parent.sc.SpanID)
+	}
+	return t.start(ctx, name, TraceID{}, SpanID{})
+}
+
+// 以远程的 span 为父 span 开始一个新的 span
+func (t *Tracer) StartRemote(ctx context.Context, name string, remote SpanContext) (context.Context, *Span) {
+	return t.start(ctx, name, remote.TraceID, remote.SpanID)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, traceID TraceID, parentID SpanID) (context.Context, *Span) {
+	s := &Span{tracer: t, sc: SpanContext{TraceID: traceID}}
+	if traceID == (TraceID{}) {
+		rand.Read(s.sc.TraceID[:])
+	}
+	rand.Read(s.sc.SpanID[:])
+	s.data = SpanData{
+		Name:    name,
+		TraceID: s.sc.TraceID.String(),
+		SpanID:  s.sc.SpanID.String(),
+		Start:   time.Now(),
+	}
+	if parentID != (SpanID{}) {
+		s.data.ParentID = parentID.String()
+	}
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+type spanContextKey struct{}
+
+// 返回 context 中的 span，没有时返回空的 span
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanContextKey{}).(*Span)
+	return s
+}
+
+// 使用 context 中的 span 所属的 Tracer 开始一个子 span
+// context 中没有 span 时不追踪，返回空的 span
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name)
+}
+
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// err 为空时什么也不做
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// 结束 span 并导出，多次调用只导出一次
+// 导出失败时只记录错误日志
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if err := s.tracer.exporter.Export(data); err != nil {
+		s.tracer.logger.Error("failed to export span", zap.String("name", data.Name), zap.Error(err))
+	}
+}
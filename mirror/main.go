@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/mirror"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	source        = flag.String("source", "127.0.0.1:8080", "the address of the source cluster")
+	target        = flag.String("target", "127.0.0.1:8081", "the address of the target cluster")
+	sourceCluster = flag.String("source-cluster", "", "the name of the source cluster, recorded in provenance headers")
+	topics        = flag.String("topics", "", "comma separated topics to mirror (empty mirrors all topics)")
+
+	// 镜像进程需要在源集群有读取权限，在目标集群有追加权限
+	certFile = flag.String("cert", auth.RootClientCertFile, "the client certificate used for both clusters")
+	keyFile  = flag.String("key", auth.RootClientKeyFile, "the client private key used for both clusters")
+	caFile   = flag.String("ca", auth.CAFile, "the root certificate of both clusters")
+
+	reloadInterval = flag.Duration("reload-interval", 10*time.Second, "how often certificate files are checked for changes")
+)
+
+func main() {
+	flag.Parse()
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("failed to create logger: %v\n", err)
+	}
+	zap.ReplaceGlobals(logger)
+
+	// 双向 TLS 设置
+	// 镜像进程会长时间运行，证书轮换后新建的连接使用新的证书
+	clientCerts, err := auth.NewCertReloader(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        *certFile,
+		KeyFile:         *keyFile,
+		CAFile:          *caFile,
+	})
+	if err != nil {
+		log.Fatalf("failed to setup client mTLS: %v\n", err)
+	}
+	clientOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(clientCerts.Config())),
+	}
+
+	sourceConn, err := grpc.Dial(*source, clientOptions...)
+	if err != nil {
+		log.Fatalf("failed to connect to source: %v\n", err)
+	}
+	defer sourceConn.Close()
+	targetConn, err := grpc.Dial(*target, clientOptions...)
+	if err != nil {
+		log.Fatalf("failed to connect to target: %v\n", err)
+	}
+	defer targetConn.Close()
+
+	c := mirror.Config{
+		SourceCluster: *sourceCluster,
+		Source:        api.NewLogClient(sourceConn),
+		Target:        api.NewLogClient(targetConn),
+	}
+	if *topics != "" {
+		c.Topics = strings.Split(*topics, ",")
+	}
+	m, err := mirror.New(c)
+	if err != nil {
+		log.Fatalf("failed to create mirror: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go auth.NewWatcher(*reloadInterval, clientCerts).Run(ctx)
+	if err := m.Run(ctx); err != nil {
+		log.Printf("mirror stopped: %v\n", err)
+		os.Exit(1)
+	}
+	log.Printf("mirror shutdown\n")
+}
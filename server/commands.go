@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/discovery"
+	dclslog "github.com/youngfr/dcls/internal/log"
+)
+
+// 可以通过 ClusterQuery 在所有节点上执行的命令
+const (
+	// 报告本节点日志的起始和结束下标
+	offsetsCommand = "offsets"
+
+	// 重新加载访问控制策略文件
+	reloadACLCommand = "reload-acl"
+)
+
+// 通过 ClusterQuery 执行的命令的白名单
+var queryableCommands = []string{offsetsCommand, reloadACLCommand}
+
+// next 等于 lowest 时日志为空
+type offsetsResponse struct {
+	Lowest  uint64 `json:"lowest"`
+	Highest uint64 `json:"highest"`
+	Next    uint64 `json:"next"`
+}
+
+func registerCommands(m *discovery.Membership, clog *dclslog.Log, authorizer *auth.Authorizer) {
+	m.RegisterCommand(offsetsCommand, discovery.JSONCommand(func(struct{}) (offsetsResponse, error) {
+		lowest, err := clog.LowestOffset()
+		if err != nil {
+			return offsetsResponse{}, err
+		}
+		highest, err := clog.HighestOffset()
+		if err != nil {
+			return offsetsResponse{}, err
+		}
+		next, err := clog.NextOffset()
+		if err != nil {
+			return offsetsResponse{}, err
+		}
+		return offsetsResponse{Lowest: lowest, Highest: highest, Next: next}, nil
+	}))
+
+	m.RegisterCommand(reloadACLCommand, discovery.JSONCommand(func(struct{}) (struct{}, error) {
+		return struct{}{}, authorizer.Reload()
+	}))
+}
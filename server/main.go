@@ -1,80 +1,440 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/youngfr/dcls/internal/adminserver"
+	"github.com/youngfr/dcls/internal/audit"
 	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/controller"
+	"github.com/youngfr/dcls/internal/discovery"
 	dclslog "github.com/youngfr/dcls/internal/log"
 	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/metrics"
+	"github.com/youngfr/dcls/internal/mirror"
+	"github.com/youngfr/dcls/internal/mux"
+	"github.com/youngfr/dcls/internal/quota"
+	"github.com/youngfr/dcls/internal/replication"
+	"github.com/youngfr/dcls/internal/sign"
+	"github.com/youngfr/dcls/internal/trace"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
 const logStoringDir = "log-services"
 
-var port = flag.Int("port", 8080, "the port to serve on")
+// 审计日志保存在单独的目录中，Reset 不会删除审计日志
+const auditLogDir = "audit-log"
+
+// 其他集群镜像到本集群的进度
+const mirrorCheckpointsFile = "mirror-checkpoints.json"
+
+var (
+	port = flag.Int("port", 8080, "the port to serve on")
+
+	// 集群成员配置
+	// 没有设置 bind-addr 时不加入集群
+	nodeName    = flag.String("node-name", "", "the unique name of this node in the cluster (defaults to hostname)")
+	bindAddr    = flag.String("bind-addr", "", "the address serf binds to (empty disables cluster membership)")
+	joinAddrs   = flag.String("join", "", "comma separated serf addresses of existing cluster members")
+	gossipKey   = flag.String("gossip-key", "", "base64 encoded key used to encrypt gossip messages")
+	keyringFile = flag.String("keyring-file", "", "the file where the gossip keyring is persisted")
+
+	// 分区分配配置
+	rack     = flag.String("rack", "", "the rack this node is in, replicas of a partition are spread across racks")
+	capacity = flag.String("capacity", "1", "the relative number of replicas this node can hold (0 drains the node)")
+	topics   = flag.String("topics", "", "comma separated topics in the form name:partitions:replicas")
+
+	// 从客户端证书的哪个字段提取用户身份
+	identity = flag.String("identity", "cn", "the client certificate field used as the identity: cn, dns, uri (spiffe) or email")
+
+	// 使用令牌认证无法提供客户端证书的客户端
+	// 没有设置 token-key 时只接受客户端证书
+	tokenKey      = flag.String("token-key", "", "the HMAC secret or Ed25519 public key (PEM) file used to verify bearer tokens")
+	tokenAudience = flag.String("token-audience", "dcls", "the audience bearer tokens must be issued for")
+
+	// 静态加密，没有设置 master-key-file 时存储文件不加密
+	masterKeyFile = flag.String("master-key-file", "", "the file of master keys wrapping per-segment data keys, the last key is used for new segments")
+
+	// 只接受带有有效签名的记录的主题，公钥从配置目录中的 trusted-keys.pem 加载
+	signedTopics = flag.String("signed-topics", "", "comma separated topic patterns (e.g. audit.*) that only accept signed records")
+
+	// Reset 产生的归档的保留策略
+	archiveMaxAge   = flag.Duration("archive-max-age", 30*24*time.Hour, "archives older than this are purged (0 keeps them forever)")
+	archiveMaxCount = flag.Int("archive-max-count", 10, "the number of newest archives kept (0 keeps all)")
+
+	// 每次追加后调用 fsync，降低吞吐量换取操作系统崩溃时不丢失已经确认的记录
+	syncOnAppend = flag.Bool("sync-on-append", false, "fsync the store and index after every append")
+
+	// 作为 follower 复制 leader 的日志，follower 不接受客户端的写入
+	replicateFrom = flag.String("replicate-from", "", "the address of the leader whose log this node replicates (empty makes this node a leader)")
+
+	// 服务器反射，方便使用 grpcurl 调试，只有拥有 reflection 的 admin 权限的用户可以使用
+	enableReflection = flag.Bool("reflection", false, "enable gRPC server reflection for users allowed to admin reflection")
+
+	// 日志级别，运行时可以通过管理服务器修改
+	logLevel = flag.String("log-level", "info", "the minimum level of logs: debug, info, warn or error")
+
+	// 管理用的 HTTPS 服务器，没有设置 admin-addr 时不启动
+	// 使用和 gRPC 服务器相同的证书、认证方式和访问控制策略
+	adminAddr = flag.String("admin-addr", "", "the address of the admin HTTPS server serving metrics, pprof and status pages (empty disables it)")
+
+	// 追踪每个请求，span 以 JSON 格式追加到这个文件中
+	traceFile = flag.String("trace-file", "", "the file spans of every request are appended to (empty disables tracing)")
+
+	// 证书和访问控制策略发生变化时自动重新加载，收到 SIGHUP 信号时也会重新加载
+	reloadInterval = flag.Duration("reload-interval", 10*time.Second, "how often certificate and policy files are checked for changes")
+)
 
 func main() {
+	started := time.Now()
 	flag.Parse()
 
+	// 日志级别可以通过管理服务器的 /log/level 在运行时修改
+	level, err := zap.ParseAtomicLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid log level: %v\n", err)
+		os.Exit(2)
+	}
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = level
+	logger, err := zapConfig.Build()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
 	lis, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *port))
 	if err != nil {
-		log.Fatalf("failed to listen: %v\n", err)
+		logger.Fatal("failed to listen", zap.Error(err))
 	}
-	log.Printf("server starting on port %d...\n", *port)
+	logger.Info("server starting", zap.String("addr", lis.Addr().String()))
 
 	// 创建存储日志的对象
 	if _, err := os.Stat(logStoringDir); os.IsNotExist(err) {
 		if err := os.Mkdir(logStoringDir, 0744); err != nil {
-			log.Fatalf("failed to create log storing directory: %v\n", err)
+			logger.Fatal("failed to create log storing directory", zap.Error(err))
+		}
+	}
+	// 没有启动管理服务器时不记录指标
+	var registry *metrics.Registry
+	if *adminAddr != "" {
+		registry = metrics.NewRegistry()
+	}
+
+	var logConfig dclslog.Config
+	logConfig.Archive.MaxAge = *archiveMaxAge
+	logConfig.Archive.MaxCount = *archiveMaxCount
+	logConfig.SyncOnAppend = *syncOnAppend
+	if *masterKeyFile != "" {
+		if logConfig.MasterKeys, err = dclslog.LoadMasterKeys(*masterKeyFile); err != nil {
+			logger.Fatal("failed to load master keys", zap.Error(err))
 		}
 	}
-	clog, err := dclslog.NewLog(logStoringDir, dclslog.Config{})
+	if registry != nil {
+		logConfig.Metrics = metrics.NewLogMetrics(registry)
+	}
+	clog, err := dclslog.NewLog(logStoringDir, logConfig)
+	if err != nil {
+		logger.Fatal("failed to create Log object", zap.Error(err))
+	}
+
+	auditLog, err := audit.Open(auditLogDir)
 	if err != nil {
-		log.Fatalf("failed to create Log object: %v\n", err)
+		logger.Fatal("failed to open audit log", zap.Error(err))
 	}
 
 	// 双向 TLS 设置
-	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+	// 每次握手时都使用最新加载的证书
+	// 拒绝配置目录中的证书吊销列表里的证书，吊销列表可以在服务器启动之后才出现
+	crl, err := auth.NewRevocationList(auth.CRLFile, auth.CAFile)
+	if err != nil {
+		logger.Fatal("failed to load certificate revocation list", zap.Error(err))
+	}
+	serverTLS := auth.TLSConfig{
 		IsServerConfig:  true,
 		EnableMutualTLS: true,
 		CertFile:        auth.ServerCertFile,
 		KeyFile:         auth.ServerKeyFile,
 		CAFile:          auth.CAFile,
 		ServerName:      lis.Addr().String(),
+		CRL:             crl,
+	}
+	// 对端节点总是需要提供证书
+	peerServerCerts, err := auth.NewCertReloader(serverTLS)
+	if err != nil {
+		logger.Fatal("failed to setup server mTLS", zap.Error(err))
+	}
+	// 使用令牌的客户端不提供证书
+	serverCerts := peerServerCerts
+	if *tokenKey != "" {
+		serverTLS.ClientCertOptional = true
+		if serverCerts, err = auth.NewCertReloader(serverTLS); err != nil {
+			logger.Fatal("failed to setup server mTLS", zap.Error(err))
+		}
+	}
+	serverCredentials := credentials.NewTLS(serverCerts.Config())
+
+	id, err := logserver.ParseIdentity(*identity)
+	if err != nil {
+		logger.Fatal("invalid identity", zap.Error(err))
+	}
+	authorizer := auth.NewAuthorizer(auth.ACLModelFile, auth.ACLPolicyFile)
+	// 记住最近追加的记录的 trace context，复制时发送给 follower
+	traced := replication.NewTracedLog(clog)
+	implConfig := &logserver.LogImplConfig{
+		CommitLog:  traced,
+		Authorizer: authorizer,
+		Policies:   authorizer,
+		Identity:   id,
+		Auditor:    auditLog,
+		Archives:   traced,
+	}
+	if registry != nil {
+		implConfig.Metrics = metrics.NewRPCMetrics(registry)
+	}
+	if implConfig.Checkpoints, err = mirror.NewFileCheckpoints(mirrorCheckpointsFile); err != nil {
+		logger.Fatal("failed to load mirror checkpoints", zap.Error(err))
+	}
+	var spans *trace.FileExporter
+	if *traceFile != "" {
+		if spans, err = trace.NewFileExporter(*traceFile); err != nil {
+			logger.Fatal("failed to open trace file", zap.Error(err))
+		}
+		implConfig.Tracer = trace.NewTracer(spans)
+	}
+	reloadables := []auth.Reloadable{peerServerCerts, authorizer}
+	if *signedTopics != "" {
+		registry, err := sign.NewRegistry(auth.TrustedKeysFile)
+		if err != nil {
+			logger.Fatal("failed to load trusted keys", zap.Error(err))
+		}
+		implConfig.Signatures = &sign.Policy{Registry: registry, Topics: strings.Split(*signedTopics, ",")}
+		reloadables = append(reloadables, registry)
+	}
+	// 配置目录中有限额文件时限制每个用户的请求速率和并发数
+	if _, err := os.Stat(auth.QuotaFile); err == nil {
+		quotas, err := quota.NewManager(auth.QuotaFile, authorizer.RolesForSubject)
+		if err != nil {
+			logger.Fatal("failed to load quotas", zap.Error(err))
+		}
+		implConfig.Quotas = quotas
+		reloadables = append(reloadables, quotas)
+	}
+	if logConfig.MasterKeys != nil {
+		reloadables = append(reloadables, logConfig.MasterKeys)
+	}
+	if *tokenKey != "" {
+		tokens, err := auth.NewTokenAuthenticator(*tokenKey, *tokenAudience)
+		if err != nil {
+			logger.Fatal("failed to setup token authentication", zap.Error(err))
+		}
+		implConfig.Authenticator = logserver.ChainAuthenticator{
+			logserver.MTLSAuthenticator{Identity: id},
+			tokens,
+		}
+		reloadables = append(reloadables, serverCerts, tokens)
+	}
+
+	// 加入集群
+	var membership *discovery.Membership
+	if *bindAddr != "" {
+		var ctrl *controller.Controller
+		var mm discovery.Metrics
+		if registry != nil {
+			mm = metrics.NewMembershipMetrics(registry)
+		}
+		membership, ctrl, err = setupMembership(lis.Addr().String(), mm)
+		if err != nil {
+			logger.Fatal("failed to setup membership", zap.Error(err))
+		}
+		defer ctrl.Close()
+		registerCommands(membership, clog, authorizer)
+		implConfig.Keyring = membership
+		implConfig.Cluster = membership
+		implConfig.ClusterCommands = queryableCommands
+		implConfig.Topics = ctrl
+		// 策略修改通过 serf 查询复制，没有加密时任何人都可以伪造查询修改策略
+		// 这时不允许在运行时管理策略，只能修改每个节点的策略文件
+		if membership.EncryptionEnabled() {
+			registerPolicyCommand(membership, authorizer)
+			implConfig.Policies = &replicatedPolicies{Authorizer: authorizer, membership: membership}
+		} else {
+			logger.Warn("gossip encryption is disabled, runtime policy management is turned off")
+			implConfig.Policies = nil
+		}
+	}
+
+	// 启动完成之前健康检查服务报告 NOT_SERVING
+	health := logserver.NewHealth(clog, implConfig.Topics)
+	implConfig.Health = health
+	implConfig.Reflection = *enableReflection
+
+	// 节点之间的复制流量和客户端的 gRPC 流量共用同一个端口
+	// 第一个字节为 mux.PeerStream 的连接交给复制层
+	// 其他连接都交给 gRPC 服务器
+	m := mux.New(lis)
+	peerCerts, err := auth.NewCertReloader(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+		CRL:             crl,
 	})
 	if err != nil {
-		log.Fatalf("failed to setup server mTLS: %v\n", err)
+		logger.Fatal("failed to setup peer mTLS", zap.Error(err))
+	}
+	peers, err := mux.NewStreamLayer(m.Match(mux.PeerStream), peerServerCerts.Config(), peerCerts.Config())
+	if err != nil {
+		logger.Fatal("failed to create peer stream layer", zap.Error(err))
+	}
+	// 设置了 replicate-from 时本节点是 follower，读取时按照一致性级别检查复制状态
+	var follower *replication.Follower
+	if *replicateFrom != "" {
+		follower, err = replication.NewFollower(replication.Config{
+			Log:    traced,
+			Leader: *replicateFrom,
+			Dialer: peers,
+			Tracer: implConfig.Tracer,
+		})
+		if err != nil {
+			logger.Fatal("failed to create follower", zap.Error(err))
+		}
+		implConfig.Replica = follower
 	}
-	serverCredentials := credentials.NewTLS(serverTLSConfig)
 
 	// 创建服务器
-	server, err := logserver.NewgRPCServer(
-		&logserver.LogImplConfig{
-			CommitLog:  clog,
-			Authorizer: auth.NewAuthorizer(auth.ACLModelFile, auth.ACLPolicyFile),
-		},
-		grpc.Creds(serverCredentials),
-	)
+	server, err := logserver.NewgRPCServer(implConfig, grpc.Creds(serverCredentials))
+	if err != nil {
+		logger.Fatal("failed to create server", zap.Error(err))
+	}
+
+	// 每个节点都把本地日志发送给连接上来的 follower
+	replicator, err := replication.NewServer(replication.Config{Log: traced})
 	if err != nil {
-		log.Fatalf("failed to create server: %v\n", err)
+		logger.Fatal("failed to create replication server", zap.Error(err))
+	}
+	// 在任何协程开始接受连接之前注册所有的 matcher
+	grpcLis := m.Default()
+	go func() {
+		if err := replicator.Serve(peers); err != nil {
+			logger.Fatal("replication server stopped", zap.Error(err))
+		}
+	}()
+	go func() {
+		if err := server.Serve(grpcLis); err != nil {
+			logger.Fatal("gRPC server stopped", zap.Error(err))
+		}
+	}()
+	go func() {
+		if err := m.Serve(); err != nil {
+			logger.Fatal("connection multiplexer stopped", zap.Error(err))
+		}
+	}()
+
+	var admin *adminserver.Server
+	if *adminAddr != "" {
+		var authenticator logserver.Authenticator = logserver.MTLSAuthenticator{Identity: id}
+		if implConfig.Authenticator != nil {
+			authenticator = implConfig.Authenticator
+		}
+		admin, err = adminserver.New(adminserver.Config{
+			Addr:      *adminAddr,
+			Metrics:   registry.Handler(),
+			TLS:       serverCerts.Config(),
+			Authorize: logserver.AuthorizeHTTP(authenticator, authorizer),
+		})
+		if err != nil {
+			logger.Fatal("failed to create admin server", zap.Error(err))
+		}
+		status := adminserver.Status{
+			NodeName: *nodeName,
+			RPCAddr:  lis.Addr().String(),
+			Started:  started,
+			Segments: clog,
+		}
+		admin.Handle("/log/level", level)
+		admin.HandlePprof()
+		admin.Handle("/config", adminserver.ConfigHandler(flag.CommandLine, "gossip-key"))
+		admin.Handle("/segments", adminserver.SegmentsHandler(clog))
+		if membership != nil {
+			status.NodeName = membership.NodeName
+			status.Members = membership
+			admin.Handle("/members", adminserver.MembersHandler(membership))
+		}
+		if status.NodeName == "" {
+			status.NodeName, _ = os.Hostname()
+		}
+		admin.Handle("/", adminserver.StatusHandler(status))
+		logger.Info("admin server listening", zap.String("addr", admin.Addr()))
+		go func() {
+			if err := admin.Serve(); err != nil {
+				logger.Fatal("admin server stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	health.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	following := make(chan struct{})
+	if follower != nil {
+		go func() {
+			defer close(following)
+			follower.Run(ctx)
+		}()
+	} else {
+		close(following)
 	}
 
+	// 热加载证书和访问控制策略
+	reloadables = append(reloadables, peerCerts, crl)
+	watcher := auth.NewWatcher(*reloadInterval, reloadables...)
+	go watcher.Run(ctx)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		log.Fatal(server.Serve(lis))
+		for range hup {
+			if err := watcher.ReloadAll(); err != nil {
+				logger.Error("failed to reload", zap.Error(err))
+			}
+		}
 	}()
 
 	// 优雅地关闭服务器
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	log.Printf("received signal: %v\n", <-ch)
-	clog.Close()
+	logger.Info("received signal", zap.Stringer("signal", <-ch))
+	// 先让负载均衡器停止发送新的请求
+	health.Shutdown()
+	if membership != nil {
+		membership.Leave()
+	}
 	server.GracefulStop()
-	log.Printf("server shutdown\n")
+	// 关闭日志之前停止复制
+	cancel()
+	<-following
+	replicator.Close()
+	if admin != nil {
+		admin.Shutdown(context.Background())
+	}
+	clog.Close()
+	auditLog.Close()
+	if spans != nil {
+		spans.Close()
+	}
+	logger.Info("server shutdown")
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/youngfr/dcls/internal/controller"
+	"github.com/youngfr/dcls/internal/discovery"
+	"go.uber.org/zap"
+)
+
+// 加入集群并创建为每个分区分配节点的控制器
+func setupMembership(rpcAddr string, mm discovery.Metrics) (*discovery.Membership, *controller.Controller, error) {
+	c := discovery.Config{
+		NodeName: *nodeName,
+		BindAddr: *bindAddr,
+		Tags: map[string]string{
+			"rpc_addr":             rpcAddr,
+			controller.RackTag:     *rack,
+			controller.CapacityTag: *capacity,
+		},
+		KeyringFile: *keyringFile,
+		Metrics:     mm,
+	}
+	if c.NodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.NodeName = hostname
+	}
+	if *joinAddrs != "" {
+		c.StartJoinAddrs = strings.Split(*joinAddrs, ",")
+	}
+	if *gossipKey != "" {
+		key, err := base64.StdEncoding.DecodeString(*gossipKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode gossip key: %w", err)
+		}
+		c.EncryptKey = key
+	}
+
+	h := &memberHandler{logger: zap.L().Named("cluster")}
+	m, err := discovery.NewMembership(h, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 客户端的读写目前还是落在本节点的日志上，没有按分区路由
+	// 所以这里不使用 replication.Mover 移动分区的日志，控制器只负责计算分配结果，DescribeTopic 把它标记为建议
+	ctrl := controller.New(m, nil)
+	if *topics != "" {
+		for _, topic := range strings.Split(*topics, ",") {
+			name, partitions, replicas, err := parseTopic(topic)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := ctrl.CreateTopic(name, partitions, replicas); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	h.setNext(ctrl)
+	// 在 setNext 之前发生的成员变化不会通知控制器
+	ctrl.Rebalance()
+
+	return m, ctrl, nil
+}
+
+// 格式为 name:partitions:replicas
+func parseTopic(s string) (name string, partitions, replicas int, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", 0, 0, fmt.Errorf("invalid topic %q, want name:partitions:replicas", s)
+	}
+	if partitions, err = strconv.Atoi(parts[1]); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid partitions of topic %q: %w", s, err)
+	}
+	if replicas, err = strconv.Atoi(parts[2]); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid replicas of topic %q: %w", s, err)
+	}
+	return parts[0], partitions, replicas, nil
+}
+
+// 记录集群成员的变化并转发给控制器
+type memberHandler struct {
+	logger *zap.Logger
+
+	mu   sync.RWMutex
+	next *controller.Controller
+}
+
+func (h *memberHandler) setNext(c *controller.Controller) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next = c
+}
+
+func (h *memberHandler) rebalance() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.next != nil {
+		h.next.Rebalance()
+	}
+}
+
+func (h *memberHandler) Join(name, addr string) error {
+	h.logger.Info("member joined", zap.String("name", name), zap.String("rpc_addr", addr))
+	h.rebalance()
+	return nil
+}
+
+func (h *memberHandler) Leave(name string) error {
+	h.logger.Info("member left", zap.String("name", name))
+	h.rebalance()
+	return nil
+}
+
+func (h *memberHandler) Fail(name string) error {
+	h.logger.Warn("member failed", zap.String("name", name))
+	h.rebalance()
+	return nil
+}
+
+func (h *memberHandler) Update(name, addr string) error {
+	h.logger.Info("member updated", zap.String("name", name), zap.String("rpc_addr", addr))
+	h.rebalance()
+	return nil
+}
+
+func (h *memberHandler) Reap(name string) error {
+	h.logger.Info("member reaped", zap.String("name", name))
+	h.rebalance()
+	return nil
+}
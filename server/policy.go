@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/discovery"
+	"github.com/youngfr/dcls/internal/logserver"
+	"go.uber.org/zap"
+)
+
+// 复制访问控制策略修改的命令
+const policyCommand = "policy"
+
+// 策略修改的类型
+const (
+	addPolicyOp    = "add-policy"
+	removePolicyOp = "remove-policy"
+	addRoleOp      = "add-role"
+)
+
+type policyChange struct {
+	Op     string               `json:"op"`
+	Policy *auth.Policy         `json:"policy,omitempty"`
+	Role   *auth.RoleAssignment `json:"role,omitempty"`
+}
+
+// 先在本节点修改策略并写回策略文件，再通过 serf 查询让所有节点执行相同的修改
+// 不使用用户事件是因为它既不保证送达也不保证顺序，查询可以知道哪些节点没有执行修改
+// 每个请求都等待查询结束才返回，所以同一个管理员先后发出的修改在每个节点上的执行顺序不变
+//
+// 修改是幂等的，本节点收到自己发出的查询时不会再次修改
+// 没有确认的节点通过 logserver.ReplicationError 返回给客户端，可以重试同一个请求
+// 修改时不在集群中的节点不会收到修改，加入集群之前需要复制策略文件
+type replicatedPolicies struct {
+	*auth.Authorizer
+	membership *discovery.Membership
+}
+
+var _ logserver.PolicyStore = (*replicatedPolicies)(nil)
+
+func (r *replicatedPolicies) AddPolicy(p auth.Policy) (bool, error) {
+	return r.apply(policyChange{Op: addPolicyOp, Policy: &p})
+}
+
+func (r *replicatedPolicies) RemovePolicy(p auth.Policy) (bool, error) {
+	return r.apply(policyChange{Op: removePolicyOp, Policy: &p})
+}
+
+func (r *replicatedPolicies) AddRoleForSubject(role auth.RoleAssignment) (bool, error) {
+	return r.apply(policyChange{Op: addRoleOp, Role: &role})
+}
+
+func (r *replicatedPolicies) apply(c policyChange) (bool, error) {
+	changed, err := applyPolicyChange(r.Authorizer, c)
+	if err != nil {
+		return false, err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return changed, err
+	}
+	failed, err := r.membership.QueryAll(policyCommand, payload, 0)
+	if err != nil {
+		return changed, fmt.Errorf("policy changed locally but failed to replicate: %w", err)
+	}
+	if len(failed) > 0 {
+		return changed, &logserver.ReplicationError{Failed: failed}
+	}
+	return changed, nil
+}
+
+func applyPolicyChange(a *auth.Authorizer, c policyChange) (bool, error) {
+	switch {
+	case c.Op == addPolicyOp && c.Policy != nil:
+		return a.AddPolicy(*c.Policy)
+	case c.Op == removePolicyOp && c.Policy != nil:
+		return a.RemovePolicy(*c.Policy)
+	case c.Op == addRoleOp && c.Role != nil:
+		return a.AddRoleForSubject(*c.Role)
+	default:
+		return false, fmt.Errorf("invalid policy change: %q", c.Op)
+	}
+}
+
+// 其他节点修改策略时在本节点执行相同的修改
+func registerPolicyCommand(m *discovery.Membership, authorizer *auth.Authorizer) {
+	m.RegisterCommand(policyCommand, discovery.JSONCommand(func(c policyChange) (struct{}, error) {
+		changed, err := applyPolicyChange(authorizer, c)
+		if changed {
+			zap.L().Info("applied replicated policy change", zap.String("op", c.Op))
+		}
+		return struct{}{}, err
+	}))
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/adminserver"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/metrics"
+)
+
+func TestAdminServer(t *testing.T) {
+	s := setupTestServer(t, nil)
+	for i := 0; i < 3; i++ {
+		_, err := s.Log.Append(&api.Record{Value: []byte("hello")})
+		require.NoError(t, err)
+	}
+
+	serverTLS, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	admin, err := adminserver.New(adminserver.Config{
+		Addr:      "127.0.0.1:0",
+		Metrics:   metrics.NewRegistry().Handler(),
+		TLS:       serverTLS,
+		Authorize: logserver.AuthorizeHTTP(logserver.MTLSAuthenticator{}, s.Config.Authorizer),
+	})
+	require.NoError(t, err)
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.String("gossip-key", "c2VjcmV0", "")
+	fs.Int("port", 8080, "")
+	members := &fakeMembers{members: []serf.Member{{
+		Name:   "node-1",
+		Addr:   net.ParseIP("127.0.0.1"),
+		Port:   8401,
+		Tags:   map[string]string{"rpc_addr": "127.0.0.1:8400"},
+		Status: serf.StatusAlive,
+	}}}
+	admin.HandlePprof()
+	admin.Handle("/config", adminserver.ConfigHandler(fs, "gossip-key"))
+	admin.Handle("/segments", adminserver.SegmentsHandler(s.Log))
+	admin.Handle("/members", adminserver.MembersHandler(members))
+	admin.Handle("/", adminserver.StatusHandler(adminserver.Status{
+		NodeName: "node-1",
+		RPCAddr:  s.Addr,
+		Started:  time.Now(),
+		Segments: s.Log,
+		Members:  members,
+	}))
+	go admin.Serve()
+	defer admin.Shutdown(context.Background())
+
+	httpClient := func(certFile, keyFile string) *http.Client {
+		c, err := auth.SetupTLSConfig(auth.TLSConfig{
+			EnableMutualTLS: true,
+			CertFile:        certFile,
+			KeyFile:         keyFile,
+			CAFile:          auth.CAFile,
+		})
+		require.NoError(t, err)
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: c}}
+	}
+	get := func(c *http.Client, path string) (int, string) {
+		t.Helper()
+		resp, err := c.Get("https://" + admin.Addr() + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	root := httpClient(auth.RootClientCertFile, auth.RootClientKeyFile)
+	readonly := httpClient(auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+
+	code, body := get(root, "/segments")
+	require.Equal(t, http.StatusOK, code)
+	var segments []dclslog.SegmentInfo
+	require.NoError(t, json.Unmarshal([]byte(body), &segments))
+	require.Len(t, segments, 1)
+	require.Equal(t, uint64(0), segments[0].BaseOffset)
+	require.Equal(t, uint64(3), segments[0].NextOffset)
+	require.Greater(t, segments[0].StoreBytes, uint64(0))
+	require.Equal(t, uint64(3*12), segments[0].IndexBytes)
+	require.True(t, segments[0].Active)
+
+	code, body = get(root, "/config")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"gossip-key": "<redacted>", "port": "8080"}`, body)
+
+	code, body = get(root, "/members")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `[{
+		"name": "node-1",
+		"addr": "127.0.0.1:8401",
+		"rpc_addr": "127.0.0.1:8400",
+		"status": "alive",
+		"tags": {"rpc_addr": "127.0.0.1:8400"}
+	}]`, body)
+
+	code, body = get(root, "/")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "<h1>dcls node-1</h1>")
+	require.Contains(t, body, "<td>0</td><td>3</td>")
+	require.Contains(t, body, "<td>node-1</td><td>127.0.0.1:8401</td>")
+	require.NotContains(t, body, "<button")
+
+	code, _ = get(root, "/debug/pprof/")
+	require.Equal(t, http.StatusOK, code)
+
+	// reader 可以读取指标，但是不能访问调试接口
+	code, _ = get(readonly, "/metrics")
+	require.Equal(t, http.StatusOK, code)
+	for _, path := range []string{"/", "/segments", "/config", "/debug/pprof/"} {
+		code, _ = get(readonly, path)
+		require.Equal(t, http.StatusForbidden, code, path)
+	}
+
+	// 没有客户端证书时无法完成 TLS 握手
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	_, err = noCert.Get("https://" + admin.Addr() + "/segments")
+	require.Error(t, err)
+}
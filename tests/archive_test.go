@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestResetArchives(t *testing.T) {
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.ResetWindow = 200 * time.Millisecond
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	for _, v := range []string{"a", "b", "c"} {
+		_, err := root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte(v)}})
+		require.NoError(t, err)
+	}
+
+	// 不带令牌的请求不会删除日志
+	resetRsp, err := root.Reset(ctx, &api.ResetRequest{})
+	require.NoError(t, err)
+	require.Equal(t, logserver.RESET_CONFIRM, resetRsp.Reply)
+	_, err = root.Read(ctx, &api.ReadRequest{Offset: 2})
+	require.NoError(t, err)
+
+	// 过期的令牌
+	time.Sleep(300 * time.Millisecond)
+	_, err = root.Reset(ctx, &api.ResetRequest{ConfirmationToken: resetRsp.ConfirmationToken})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	resetRsp, err = root.Reset(ctx, &api.ResetRequest{})
+	require.NoError(t, err)
+	token := resetRsp.ConfirmationToken
+	resetRsp, err = root.Reset(ctx, &api.ResetRequest{ConfirmationToken: token})
+	require.NoError(t, err)
+	require.Equal(t, logserver.RESET_SUCC, resetRsp.Reply)
+	require.NotEmpty(t, resetRsp.Archive)
+	archived := resetRsp.Archive
+
+	// 令牌只能使用一次
+	_, err = root.Reset(ctx, &api.ResetRequest{ConfirmationToken: token})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = root.Read(ctx, &api.ReadRequest{Offset: 2})
+	require.Error(t, err)
+
+	listRsp, err := root.ListArchives(ctx, &api.ListArchivesRequest{})
+	require.NoError(t, err)
+	require.Len(t, listRsp.Archives, 1)
+	require.Equal(t, archived, listRsp.Archives[0].Name)
+	require.Equal(t, uint64(0), listRsp.Archives[0].LowestOffset)
+	require.Equal(t, uint64(2), listRsp.Archives[0].HighestOffset)
+
+	// 恢复归档时当前的日志也被归档
+	_, err = root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("d")}})
+	require.NoError(t, err)
+	restoreRsp, err := root.RestoreArchive(ctx, &api.RestoreArchiveRequest{Name: archived})
+	require.NoError(t, err)
+	require.NotEmpty(t, restoreRsp.Archive)
+	readRsp, err := root.Read(ctx, &api.ReadRequest{Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), readRsp.Record.Value)
+
+	listRsp, err = root.ListArchives(ctx, &api.ListArchivesRequest{})
+	require.NoError(t, err)
+	require.Len(t, listRsp.Archives, 1)
+	require.Equal(t, restoreRsp.Archive, listRsp.Archives[0].Name)
+
+	_, err = root.RestoreArchive(ctx, &api.RestoreArchiveRequest{Name: "../etc"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = root.RestoreArchive(ctx, &api.RestoreArchiveRequest{Name: archived})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// 普通用户不能查看归档
+	ordinary := s.client(t, auth.OrdinaryClientCertFile, auth.OrdinaryClientKeyFile)
+	_, err = ordinary.ListArchives(ctx, &api.ListArchivesRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestPurgeArchives(t *testing.T) {
+	var c dclslog.Config
+	c.Archive.Dir = t.TempDir()
+	c.Archive.MaxCount = 2
+	clog, err := dclslog.NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer clog.Close()
+
+	names := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		_, err := clog.Append(&api.Record{Value: []byte("hello")})
+		require.NoError(t, err)
+		name, err := clog.Archive()
+		require.NoError(t, err)
+		names = append(names, name)
+	}
+	// 空的日志不会产生归档
+	name, err := clog.Archive()
+	require.NoError(t, err)
+	require.Empty(t, name)
+
+	archives, err := clog.ListArchives()
+	require.NoError(t, err)
+	require.Len(t, archives, 2)
+	require.Equal(t, names[1], archives[0].Name)
+	require.Equal(t, names[2], archives[1].Name)
+}
+
+// 空日志和只有一条记录的日志的 lowest 和 highest 相同，只能用 next_offset 区分
+func TestOffsetsOfEmptyLog(t *testing.T) {
+	s := setupTestServer(t, nil)
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	offsetsRsp, err := root.Offsets(ctx, &api.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsetsRsp.Highest)
+	require.Equal(t, offsetsRsp.Lowest, offsetsRsp.NextOffset)
+
+	_, err = root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("a")}})
+	require.NoError(t, err)
+	offsetsRsp, err = root.Offsets(ctx, &api.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsetsRsp.Highest)
+	require.Equal(t, uint64(1), offsetsRsp.NextOffset)
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/audit"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := audit.Open(dir)
+	require.NoError(t, err)
+
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Auditor = auditLog
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ordinary := s.client(t, auth.OrdinaryClientCertFile, auth.OrdinaryClientKeyFile)
+	ctx := context.Background()
+
+	_, err = ordinary.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	_, err = ordinary.Reset(ctx, &api.ResetRequest{})
+	require.Error(t, err)
+	resetRsp, err := root.Reset(ctx, &api.ResetRequest{})
+	require.NoError(t, err)
+	_, err = root.Reset(ctx, &api.ResetRequest{ConfirmationToken: resetRsp.ConfirmationToken})
+	require.NoError(t, err)
+
+	// 验证请求本身在返回之后才被记录
+	verifyRsp, err := root.VerifyAuditLog(ctx, &api.VerifyAuditLogRequest{})
+	require.NoError(t, err)
+	require.True(t, verifyRsp.Valid, verifyRsp.Error)
+	require.Equal(t, uint64(4), verifyRsp.Entries)
+	_, err = ordinary.VerifyAuditLog(ctx, &api.VerifyAuditLogRequest{})
+	require.Error(t, err)
+
+	entries := make([]audit.Entry, 0)
+	result, err := auditLog.Walk(func(_ uint64, e audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), result.Entries)
+
+	require.Equal(t, "ordinary user", entries[0].Subject)
+	require.Equal(t, "/log.v1.Log/Append", entries[0].Method)
+	require.Equal(t, "append", entries[0].Action)
+	require.Equal(t, "all logs", entries[0].Object)
+	require.Equal(t, &audit.OffsetRange{First: 0, Last: 0}, entries[0].Offsets)
+	require.Equal(t, "OK", entries[0].Result)
+	require.NotEmpty(t, entries[0].Peer)
+
+	// 谁调用了 Reset
+	require.Equal(t, "ordinary user", entries[1].Subject)
+	require.Equal(t, "/log.v1.Log/Reset", entries[1].Method)
+	require.Equal(t, "PermissionDenied", entries[1].Result)
+	require.Equal(t, "root user", entries[3].Subject)
+	require.Equal(t, "/log.v1.Log/Reset", entries[3].Method)
+	require.Equal(t, "OK", entries[3].Result)
+	require.Equal(t, &audit.OffsetRange{First: 0, Last: 0}, entries[3].Offsets)
+
+	// 重新打开后继续哈希链
+	require.NoError(t, auditLog.Close())
+	auditLog, err = audit.Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, auditLog.Record(audit.Entry{Method: "/log.v1.Log/Offsets", Result: "OK"}))
+	result, err = auditLog.Verify()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), result.Entries)
+	require.NoError(t, auditLog.Close())
+
+	// 修改第一条记录中的用户身份
+	store := filepath.Join(dir, "0.store")
+	b, err := os.ReadFile(store)
+	require.NoError(t, err)
+	b = bytes.Replace(b, []byte("ordinary user"), []byte("ordinarx user"), 1)
+	require.NoError(t, os.WriteFile(store, b, 0644))
+
+	auditLog, err = audit.Open(dir)
+	require.NoError(t, err)
+	defer auditLog.Close()
+	result, err = auditLog.Verify()
+	require.ErrorIs(t, err, audit.ErrTampered)
+	require.Equal(t, uint64(1), result.Entries)
+}
+
+func TestAuditLogOversizedEntry(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := audit.Open(dir)
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Auditor = auditLog
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	// 主题名比默认的 segment 还大
+	topic := strings.Repeat("t", 8<<10)
+	_, err = root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello"), Topic: topic}})
+	require.Error(t, err)
+	for i := 0; i < 100; i++ {
+		_, err = root.Offsets(ctx, &api.OffsetsRequest{})
+		require.NoError(t, err)
+	}
+
+	entries := make([]audit.Entry, 0)
+	result, err := auditLog.Walk(func(_ uint64, e audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), result.Entries)
+	require.Equal(t, "/log.v1.Log/Append", entries[0].Method)
+	require.Less(t, len(entries[0].Object), 2<<10)
+	require.True(t, strings.HasPrefix(entries[0].Object, "ttt"))
+	require.Contains(t, entries[0].Object, "8192 bytes")
+
+	// 审计日志使用大的 segment，不会每十几个请求就新建一个
+	files, err := filepath.Glob(filepath.Join(dir, "*.store"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+// 总是写入失败的审计日志
+type failingAuditor struct{}
+
+func (failingAuditor) Record(audit.Entry) error {
+	return errors.New("disk full")
+}
+
+func (failingAuditor) Verify() (audit.VerifyResult, error) {
+	return audit.VerifyResult{}, nil
+}
+
+func TestAuditFailureFailsRequest(t *testing.T) {
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Auditor = failingAuditor{}
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+
+	// 没有审计记录的请求不返回结果
+	_, err := root.Offsets(context.Background(), &api.OffsetsRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
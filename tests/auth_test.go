@@ -29,6 +29,8 @@ func TestAuthenticationAndAuthorization(t *testing.T) {
 		// 创建存储日志的目录
 		ldir, err := os.MkdirTemp("", "server-log-services")
 		require.NoError(t, err)
+		// Reset 时日志被移动到相邻的归档目录中
+		defer os.RemoveAll(ldir + ".archive")
 
 		// 日志的起始下标
 		initialOffset := uint64(1)
@@ -174,8 +176,13 @@ func TestAuthenticationAndAuthorization(t *testing.T) {
 			require.Error(t, err)
 
 			// 超级用户有清空所有日志的权限
+			// 先申请确认令牌，再用令牌确认
 			resetRsp, err := rootClient.Reset(ctx, &api.ResetRequest{})
 			require.NoError(t, err)
+			require.Equal(t, logserver.RESET_CONFIRM, resetRsp.Reply)
+			require.NotEmpty(t, resetRsp.ConfirmationToken)
+			resetRsp, err = rootClient.Reset(ctx, &api.ResetRequest{ConfirmationToken: resetRsp.ConfirmationToken})
+			require.NoError(t, err)
 			require.Equal(t, logserver.RESET_SUCC, resetRsp.Reply)
 
 			// 在清空所有日志后再次读取会报错
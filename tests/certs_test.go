@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/certs"
+)
+
+func TestCertificateAuthority(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := certs.Init(dir, "test ca", certs.DefaultCAValidity)
+	require.NoError(t, err)
+	_, err = certs.Init(dir, "test ca", certs.DefaultCAValidity)
+	require.ErrorIs(t, err, certs.ErrCAExists)
+
+	ca, err = certs.Load(dir)
+	require.NoError(t, err)
+	_, err = ca.Issue(certs.Request{
+		Name:       "server",
+		CommonName: "log server",
+		Hosts:      []string{"localhost", "127.0.0.1"},
+		Server:     true,
+	})
+	require.NoError(t, err)
+	client, err := ca.Issue(certs.Request{
+		Name:       "writer-client",
+		CommonName: "writer",
+		Hosts:      []string{testSPIFFEID, testEmail},
+	})
+	require.NoError(t, err)
+	require.Equal(t, testSPIFFEID, client.URIs[0].String())
+	require.Equal(t, testEmail, client.EmailAddresses[0])
+	_, err = ca.Issue(certs.Request{Name: "ca", CommonName: "evil"})
+	require.Error(t, err)
+
+	// 签发的证书可以直接用于双向 TLS 认证
+	file := func(name string) string { return filepath.Join(dir, name) }
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        file("server.pem"),
+		KeyFile:         file("server-key.pem"),
+		CAFile:          file(certs.CAFile),
+	})
+	require.NoError(t, err)
+	clientTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		EnableMutualTLS: true,
+		CertFile:        file("writer-client.pem"),
+		KeyFile:         file("writer-client-key.pem"),
+		CAFile:          file(certs.CAFile),
+	})
+	require.NoError(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+	require.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte{1})
+			conn.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", lis.Addr().String(), clientTLSConfig)
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	require.NoError(t, err)
+	conn.Close()
+
+	issued, err := ca.List()
+	require.NoError(t, err)
+	require.Len(t, issued, 2)
+	require.Equal(t, "server", issued[0].Name)
+	require.Equal(t, "writer-client", issued[1].Name)
+	require.True(t, issued[1].RevokedAt.IsZero())
+
+	// 吊销之后生成的 CRL 可以被服务器加载
+	revoked, err := ca.Revoke(client.SerialNumber.Text(16))
+	require.NoError(t, err)
+	require.Equal(t, "writer-client", revoked.Name)
+	issued, err = ca.List()
+	require.NoError(t, err)
+	require.False(t, issued[1].RevokedAt.IsZero())
+	require.True(t, issued[0].RevokedAt.IsZero())
+	_, err = ca.Revoke("unknown")
+	require.Error(t, err)
+
+	crl, err := auth.NewRevocationList(file(certs.CRLFile), file(certs.CAFile))
+	require.NoError(t, err)
+	require.Error(t, crl.VerifyPeerCertificate([][]byte{client.Raw}, nil))
+	require.NoError(t, crl.VerifyPeerCertificate([][]byte{issued[0].Cert.Raw}, nil))
+}
+
+func TestRevocationWithoutRestart(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+	ca, err := certs.Init(dir, "test ca", certs.DefaultCAValidity)
+	require.NoError(t, err)
+	client, err := ca.Issue(certs.Request{Name: "writer-client", CommonName: "writer"})
+	require.NoError(t, err)
+
+	// 初始化时写入了空的 CRL
+	crl, err := auth.NewRevocationList(file(certs.CRLFile), file(certs.CAFile))
+	require.NoError(t, err)
+	require.NoError(t, crl.VerifyPeerCertificate([][]byte{client.Raw}, nil))
+
+	// 没有 CRL 时服务器也可以启动，之后出现的 CRL 会被自动加载
+	require.NoError(t, os.Remove(file(certs.CRLFile)))
+	crl, err = auth.NewRevocationList(file(certs.CRLFile), file(certs.CAFile))
+	require.NoError(t, err)
+	require.NoError(t, crl.VerifyPeerCertificate([][]byte{client.Raw}, nil))
+	watcher := auth.NewWatcher(10*time.Millisecond, crl)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	_, err = ca.Revoke("writer-client")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return crl.VerifyPeerCertificate([][]byte{client.Raw}, nil) != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// 删除加载过的 CRL 不会让被吊销的证书重新可用
+	require.NoError(t, os.Remove(file(certs.CRLFile)))
+	require.Error(t, watcher.ReloadAll())
+	require.Error(t, crl.VerifyPeerCertificate([][]byte{client.Raw}, nil))
+}
@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/cli"
+	"github.com/youngfr/dcls/internal/sign"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// tail -f 在另一个协程中写输出，读写需要加锁
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCLI(t *testing.T) {
+	s := setupTestServer(t, nil)
+	ctx := context.Background()
+
+	// 以 root 用户的身份运行命令，返回标准输出
+	run := func(command func(context.Context, cli.IO, []string) error, stdin string, args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		err := command(ctx, cli.IO{In: strings.NewReader(stdin), Out: &stdout, Err: &stderr}, append([]string{"-addr", s.Addr}, args...))
+		return stdout.String(), err
+	}
+
+	// 空的日志
+	out, err := run(cli.Offsets, "")
+	require.NoError(t, err)
+	require.Equal(t, "lowest: 0, highest: -, log is empty\n", out)
+	out, err = run(cli.Tail, "")
+	require.NoError(t, err)
+	require.Empty(t, out)
+
+	// 标准输入的每一行是一条记录
+	out, err = run(cli.Append, "first\nsecond\nthird\n", "-lines")
+	require.NoError(t, err)
+	require.Equal(t, "0\n1\n2\n", out)
+	out, err = run(cli.Append, "", "-topic", "orders", "-record-key", "k1", "hello")
+	require.NoError(t, err)
+	require.Equal(t, "3\n", out)
+	_, err = run(cli.Append, "", "-file", "records.txt", "hello")
+	require.Error(t, err)
+
+	out, err = run(cli.Offsets, "")
+	require.NoError(t, err)
+	require.Equal(t, "lowest: 0, highest: 3\n", out)
+
+	// read-range 包含两端的记录
+	out, err = run(cli.ReadRange, "", "0", "2")
+	require.NoError(t, err)
+	require.Equal(t, "first\nsecond\nthird\n", out)
+	_, err = run(cli.ReadRange, "", "2", "1")
+	require.Error(t, err)
+
+	// 每种输出格式
+	out, err = run(cli.Read, "", "3")
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", out)
+	out, err = run(cli.Read, "", "-o", "hex", "3")
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString([]byte("hello"))+"\n", out)
+	out, err = run(cli.Read, "", "-o", "base64", "3")
+	require.NoError(t, err)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("hello"))+"\n", out)
+	out, err = run(cli.Read, "", "-o", "json", "3")
+	require.NoError(t, err)
+	record := &api.Record{}
+	require.NoError(t, protojson.Unmarshal([]byte(out), record))
+	require.Equal(t, []byte("hello"), record.Value)
+	require.Equal(t, []byte("k1"), record.Key)
+	require.Equal(t, "orders", record.Topic)
+	require.EqualValues(t, 3, record.Offset)
+	_, err = run(cli.Read, "", "-o", "xml", "3")
+	require.Error(t, err)
+
+	out, err = run(cli.Tail, "", "-n", "2")
+	require.NoError(t, err)
+	require.Equal(t, "third\nhello\n", out)
+}
+
+func TestCLITailAcrossReset(t *testing.T) {
+	s := setupTestServer(t, nil)
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	appendValue := func(v string) {
+		_, err := root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte(v)}})
+		require.NoError(t, err)
+	}
+	appendValue("old")
+
+	// 先打印最后一条记录，之后跟随新的记录
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- cli.Tail(ctx, cli.IO{Out: stdout, Err: stderr}, []string{"-addr", s.Addr, "-n", "1", "-f", "-interval", "10ms"})
+	}()
+	require.Eventually(t, func() bool {
+		return stdout.String() == "old\n"
+	}, 3*time.Second, 10*time.Millisecond)
+
+	appendValue("a")
+	appendValue("b")
+	require.Eventually(t, func() bool {
+		return stdout.String() == "old\na\nb\n"
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// 重置之后从新的日志的开头继续
+	var resetOut bytes.Buffer
+	require.NoError(t, cli.Reset(ctx, cli.IO{Out: &resetOut, Err: &resetOut}, []string{"-addr", s.Addr, "-yes"}))
+	require.Contains(t, resetOut.String(), "archived as")
+	appendValue("c")
+	require.Eventually(t, func() bool {
+		return stdout.String() == "old\na\nb\nc\n"
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Contains(t, stderr.String(), "log was reset")
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("tail -f did not stop after cancellation")
+	}
+}
+
+func TestCLISignedTopics(t *testing.T) {
+	// 服务器不检查签名，比如配置错误的服务器或者镜像的目标集群
+	s := setupTestServer(t, nil)
+	ctx := context.Background()
+	dir := t.TempDir()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privPEM, pubPEM, err := sign.EncodeKeyPair("audit-producer", key)
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "audit-producer.pem")
+	trustedKeys := filepath.Join(dir, "trusted-keys.pem")
+	require.NoError(t, os.WriteFile(keyFile, privPEM, 0600))
+	require.NoError(t, os.WriteFile(trustedKeys, pubPEM, 0644))
+
+	run := func(command func(context.Context, cli.IO, []string) error, args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		err := command(ctx, cli.IO{In: strings.NewReader(""), Out: &stdout, Err: &stderr}, append([]string{"-addr", s.Addr}, args...))
+		return stdout.String(), err
+	}
+	out, err := run(cli.Append, "-signing-key", keyFile, "-topic", "audit.orders", "signed")
+	require.NoError(t, err)
+	require.Equal(t, "0\n", out)
+
+	// 去掉签名之后再写入
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	rsp, err := root.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.NoError(t, err)
+	require.NotEmpty(t, rsp.Record.Signature)
+	stripped := &api.Record{Topic: rsp.Record.Topic, Value: rsp.Record.Value}
+	_, err = root.Append(ctx, &api.AppendRequest{Record: stripped})
+	require.NoError(t, err)
+
+	out, err = run(cli.Read, "-trusted-keys", trustedKeys, "-signed-topics", "audit.*", "0")
+	require.NoError(t, err)
+	require.Equal(t, "signed\n", out)
+	_, err = run(cli.Read, "-trusted-keys", trustedKeys, "-signed-topics", "audit.*", "1")
+	require.Equal(t, codes.DataLoss, status.Code(err))
+	// 不知道哪些主题要求签名时发现不了被去掉的签名
+	out, err = run(cli.Read, "-trusted-keys", trustedKeys, "1")
+	require.NoError(t, err)
+	require.Equal(t, "signed\n", out)
+
+	// 没有信任的公钥时不能要求签名
+	_, err = run(cli.Read, "-trusted-keys", filepath.Join(dir, "missing.pem"), "-signed-topics", "audit.*", "1")
+	require.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/discovery"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 记录收到的命令，每个命令都由一个节点成功执行
+type recordingCluster struct {
+	mu       sync.Mutex
+	commands []string
+	timeouts []time.Duration
+}
+
+func (c *recordingCluster) Query(name string, payload []byte, timeout time.Duration) ([]discovery.CommandResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, name)
+	c.timeouts = append(c.timeouts, timeout)
+	return []discovery.CommandResponse{{Node: "node-1"}}, nil
+}
+
+func (c *recordingCluster) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.commands...)
+}
+
+func TestClusterQueryAllowlist(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	b, err := os.ReadFile(auth.ACLPolicyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(policyFile, b, 0644))
+	authorizer := auth.NewAuthorizer(auth.ACLModelFile, policyFile)
+	// 只有在集群中执行命令的权限，没有管理策略的权限
+	_, err = authorizer.AddPolicy(auth.Policy{Subject: "readonly user", Object: "cluster", Action: "query"})
+	require.NoError(t, err)
+
+	cluster := &recordingCluster{}
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Authorizer = authorizer
+		c.Policies = authorizer
+		c.Cluster = cluster
+		c.ClusterCommands = []string{"offsets", "reload-acl"}
+	})
+	readonly := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	ctx := context.Background()
+
+	rsp, err := readonly.ClusterQuery(ctx, &api.ClusterQueryRequest{Name: "offsets"})
+	require.NoError(t, err)
+	require.Len(t, rsp.Responses, 1)
+
+	// 复制策略修改的内部命令不能通过 ClusterQuery 发送，否则可以绕过 policies 的 admin 权限
+	_, err = readonly.ClusterQuery(ctx, &api.ClusterQueryRequest{
+		Name:    "policy",
+		Payload: []byte(`{"op":"add-role","role":{"subject":"readonly user","role":"admin"}}`),
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = readonly.ClusterQuery(ctx, &api.ClusterQueryRequest{Name: "unknown"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Equal(t, []string{"offsets"}, cluster.received())
+
+	_, err = readonly.ListPolicies(ctx, &api.ListPoliciesRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestClusterQueryTimeoutLimit(t *testing.T) {
+	cluster := &recordingCluster{}
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Cluster = cluster
+		c.ClusterCommands = []string{"offsets"}
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	for _, ms := range []uint32{0, 2000, 24 * 60 * 60 * 1000} {
+		_, err := root.ClusterQuery(ctx, &api.ClusterQueryRequest{Name: "offsets", TimeoutMs: ms})
+		require.NoError(t, err)
+	}
+	// 为零时使用默认值，超过上限时使用上限
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	require.Equal(t, []time.Duration{0, 2 * time.Second, 30 * time.Second}, cluster.timeouts)
+}
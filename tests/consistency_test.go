@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReadConsistency(t *testing.T) {
+	replica := &laggingReplica{}
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Replica = replica
+	})
+	client := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("record")}})
+		require.NoError(t, err)
+	}
+
+	// 副本已经提交了前两条记录，落后 leader 5 条记录和 2 秒
+	replica.commitIndex = 1
+	replica.lagOffsets = 5
+	replica.staleness = 2 * time.Second
+
+	// STALE 直接读取副本上已经提交的记录
+	readRsp, err := client.Read(ctx, &api.ReadRequest{Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), readRsp.CommitIndex)
+
+	// 还没有提交的记录不能被读取
+	_, err = client.Read(ctx, &api.ReadRequest{Offset: 2})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	// BOUNDED 在副本落后太多时失败
+	_, err = client.Read(ctx, &api.ReadRequest{Offset: 1, Consistency: api.Consistency_BOUNDED, MaxLagOffsets: 3})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = client.Read(ctx, &api.ReadRequest{Offset: 1, Consistency: api.Consistency_BOUNDED, MaxLagMs: 1000})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = client.Read(ctx, &api.ReadRequest{
+		Offset:        1,
+		Consistency:   api.Consistency_BOUNDED,
+		MaxLagOffsets: 10,
+		MaxLagMs:      5000,
+	})
+	require.NoError(t, err)
+
+	// 读自己写入的数据
+	_, err = client.Read(ctx, &api.ReadRequest{Offset: 0, MinCommitIndex: 2})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// LINEARIZABLE 在无法确认 read index 时失败
+	replica.readIndexErr = errors.New("not leader")
+	_, err = client.Read(ctx, &api.ReadRequest{Offset: 0, Consistency: api.Consistency_LINEARIZABLE})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// 副本追上 read index 后可以线性一致地读取
+	replica.readIndexErr = nil
+	replica.commitIndex = 2
+	readRsp, err = client.Read(ctx, &api.ReadRequest{
+		Offset:         2,
+		Consistency:    api.Consistency_LINEARIZABLE,
+		MinCommitIndex: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), readRsp.Record.Offset)
+	require.Equal(t, uint64(2), readRsp.CommitIndex)
+}
+
+func TestReadConsistencyStandalone(t *testing.T) {
+	s := setupTestServer(t, nil)
+	client := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	appendRsp, err := client.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("record")}})
+	require.NoError(t, err)
+
+	// 没有复制层时所有追加成功的记录都已经提交
+	for _, consistency := range []api.Consistency{
+		api.Consistency_STALE,
+		api.Consistency_BOUNDED,
+		api.Consistency_LINEARIZABLE,
+	} {
+		readRsp, err := client.Read(ctx, &api.ReadRequest{
+			Offset:         appendRsp.Offset,
+			Consistency:    consistency,
+			MaxLagOffsets:  1,
+			MinCommitIndex: appendRsp.Offset,
+		})
+		require.NoError(t, err)
+		require.Equal(t, appendRsp.Offset, readRsp.CommitIndex)
+	}
+}
+
+// 由测试控制复制状态的副本
+type laggingReplica struct {
+	commitIndex  uint64
+	lagOffsets   uint64
+	staleness    time.Duration
+	readIndexErr error
+}
+
+var _ logserver.Replica = (*laggingReplica)(nil)
+
+func (r *laggingReplica) CommitIndex() uint64 {
+	return r.commitIndex
+}
+
+func (r *laggingReplica) Lag() (uint64, time.Duration) {
+	return r.lagOffsets, r.staleness
+}
+
+func (r *laggingReplica) ReadIndex(ctx context.Context) (uint64, error) {
+	return r.commitIndex, r.readIndexErr
+}
@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/controller"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestControllerAssignment(t *testing.T) {
+	members := &fakeMembers{}
+	for i := 0; i < 4; i++ {
+		members.add(fmt.Sprintf("node-%d", i), fmt.Sprintf("rack-%d", i%2), "1")
+	}
+	mover := &fakeMover{release: make(chan struct{})}
+	c := controller.New(members, mover)
+	defer c.Close()
+
+	require.NoError(t, c.CreateTopic("orders", 8, 2))
+	require.Error(t, c.CreateTopic("orders", 8, 2))
+
+	topic, err := c.DescribeTopic("orders")
+	require.NoError(t, err)
+	require.Len(t, topic.Partitions, 8)
+	leaders := make(map[string]int)
+	for _, p := range topic.Partitions {
+		// 同一个分区的副本分布在不同的机架上
+		require.Len(t, p.Replicas, 2)
+		require.NotEqual(t, members.rack(p.Replicas[0]), members.rack(p.Replicas[1]))
+		require.Contains(t, p.Replicas, p.Leader)
+		// 新分区不需要移动数据
+		require.Empty(t, p.Syncing)
+		leaders[p.Leader]++
+	}
+	require.Greater(t, len(leaders), 1)
+
+	// 所有节点看到相同的成员时计算出相同的分配结果
+	other := controller.New(members, nil)
+	defer other.Close()
+	require.NoError(t, other.CreateTopic("orders", 8, 2))
+	otherTopic, err := other.DescribeTopic("orders")
+	require.NoError(t, err)
+	require.Equal(t, topic.Partitions, otherTopic.Partitions)
+	require.False(t, topic.Advisory)
+	require.True(t, otherTopic.Advisory)
+
+	// 节点失败后它的副本被重新分配
+	// 在数据复制完成之前，新副本处于 syncing 状态，写入仍由持有数据的节点处理
+	members.setStatus("node-0", serf.StatusFailed)
+	c.Fail("node-0")
+	topic, err = c.DescribeTopic("orders")
+	require.NoError(t, err)
+	moved := 0
+	for _, p := range topic.Partitions {
+		require.NotContains(t, p.Replicas, "node-0")
+		require.NotEqual(t, "node-0", p.Leader)
+		require.NotContains(t, p.Syncing, p.Leader)
+		moved += len(p.Syncing)
+	}
+	require.Greater(t, moved, 0)
+
+	// 数据移动完成后所有副本都不再处于 syncing 状态
+	close(mover.release)
+	require.Eventually(t, func() bool {
+		topic, err := c.DescribeTopic("orders")
+		require.NoError(t, err)
+		for _, p := range topic.Partitions {
+			if len(p.Syncing) > 0 || len(p.Retiring) > 0 {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 50*time.Millisecond)
+	require.Equal(t, moved, mover.count())
+
+	// 容量为零的节点不分配副本
+	members.setCapacity("node-1", "0")
+	c.Update("node-1", "")
+	topic, err = c.DescribeTopic("orders")
+	require.NoError(t, err)
+	for _, p := range topic.Partitions {
+		require.NotContains(t, p.Replicas, "node-1")
+	}
+}
+
+func TestDescribeTopic(t *testing.T) {
+	members := &fakeMembers{}
+	members.add("node-0", "", "1")
+	c := controller.New(members, nil)
+	defer c.Close()
+	require.NoError(t, c.CreateTopic("orders", 2, 1))
+
+	s := setupTestServer(t, func(cfg *logserver.LogImplConfig) {
+		cfg.Topics = c
+	})
+	client := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+
+	rsp, err := client.DescribeTopic(context.Background(), &api.DescribeTopicRequest{Topic: "orders"})
+	require.NoError(t, err)
+	require.Equal(t, "orders", rsp.Topic)
+	// 没有 Mover 的控制器的分配结果只是建议
+	require.True(t, rsp.Advisory)
+	require.Len(t, rsp.Partitions, 2)
+	for _, p := range rsp.Partitions {
+		require.Equal(t, "node-0", p.Leader)
+		require.Equal(t, []string{"node-0"}, p.Replicas)
+	}
+
+	_, err = client.DescribeTopic(context.Background(), &api.DescribeTopicRequest{Topic: "payments"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+type fakeMembers struct {
+	mu      sync.Mutex
+	members []serf.Member
+}
+
+func (f *fakeMembers) add(name, rack, capacity string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.members = append(f.members, serf.Member{
+		Name:   name,
+		Tags:   map[string]string{controller.RackTag: rack, controller.CapacityTag: capacity},
+		Status: serf.StatusAlive,
+	})
+}
+
+func (f *fakeMembers) setStatus(name string, status serf.MemberStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.members {
+		if f.members[i].Name == name {
+			f.members[i].Status = status
+		}
+	}
+}
+
+func (f *fakeMembers) setCapacity(name, capacity string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.members {
+		if f.members[i].Name == name {
+			f.members[i].Tags[controller.CapacityTag] = capacity
+		}
+	}
+}
+
+func (f *fakeMembers) rack(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.members {
+		if m.Name == name {
+			return m.Tags[controller.RackTag]
+		}
+	}
+	return ""
+}
+
+func (f *fakeMembers) Members() []serf.Member {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	members := make([]serf.Member, len(f.members))
+	for i, m := range f.members {
+		tags := make(map[string]string)
+		for k, v := range m.Tags {
+			tags[k] = v
+		}
+		m.Tags = tags
+		members[i] = m
+	}
+	return members
+}
+
+// 收到 release 信号后才完成数据移动
+type fakeMover struct {
+	release chan struct{}
+	mu      sync.Mutex
+	moves   int
+}
+
+func (m *fakeMover) Move(ctx context.Context, topic string, partition int, from, to string) error {
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.moves++
+	return nil
+}
+
+func (m *fakeMover) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.moves
+}
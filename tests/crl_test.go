@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/youngfr/dcls/internal/auth"
+)
+
+func TestCertificateRevocation(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCerts(t, dir, 1)
+	caFile := filepath.Join(dir, "ca.pem")
+	crlFile := filepath.Join(dir, "crl.pem")
+
+	b, err := os.ReadFile(caFile)
+	require.NoError(t, err)
+	block, _ := pem.Decode(b)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	b, err = os.ReadFile(filepath.Join(dir, "ca-key.pem"))
+	require.NoError(t, err)
+	block, _ = pem.Decode(b)
+	caKey, err := x509.ParseECPrivateKey(block.Bytes)
+	require.NoError(t, err)
+
+	// 由 signer 签发的吊销了 serials 的 CRL
+	writeCRL := func(signer *ecdsa.PrivateKey, number int64, serials ...int64) {
+		entries := make([]x509.RevocationListEntry, 0, len(serials))
+		for _, serial := range serials {
+			entries = append(entries, x509.RevocationListEntry{
+				SerialNumber:   big.NewInt(serial),
+				RevocationTime: time.Now(),
+			})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(number),
+			ThisUpdate:                time.Now().Add(-time.Minute),
+			NextUpdate:                time.Now().Add(time.Hour),
+			RevokedCertificateEntries: entries,
+		}, ca, signer)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(crlFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644))
+	}
+
+	writeCRL(caKey, 1)
+	crl, err := auth.NewRevocationList(crlFile, caFile)
+	require.NoError(t, err)
+
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        filepath.Join(dir, "server.pem"),
+		KeyFile:         filepath.Join(dir, "server-key.pem"),
+		CAFile:          caFile,
+		CRL:             crl,
+	})
+	require.NoError(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+	require.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte{1})
+			conn.Close()
+		}
+	}()
+
+	clientTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		EnableMutualTLS: true,
+		CertFile:        filepath.Join(dir, "client.pem"),
+		KeyFile:         filepath.Join(dir, "client-key.pem"),
+		CAFile:          caFile,
+	})
+	require.NoError(t, err)
+	// 使用 TLS 1.3 时客户端在服务端验证客户端证书之前就完成了握手
+	// 所以需要读取数据才能知道服务端是否接受了连接
+	connect := func() error {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), clientTLSConfig)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Read(make([]byte, 1))
+		return err
+	}
+	require.NoError(t, connect())
+
+	// 吊销客户端证书之后连接被拒绝
+	writeCRL(caKey, 2, 1)
+	require.NoError(t, crl.Reload())
+	require.Error(t, connect())
+
+	// 不是由根证书签发的 CRL 不会被加载
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	writeCRL(otherKey, 3)
+	require.Error(t, crl.Reload())
+	require.Error(t, connect())
+	_, err = auth.NewRevocationList(crlFile, caFile)
+	require.Error(t, err)
+}
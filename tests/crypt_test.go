@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEncryptionAtRest(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "master.keys")
+	addMasterKey := func(id string) {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		require.NoError(t, err)
+		f, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		require.NoError(t, err)
+		fmt.Fprintf(f, "%s:%s\n", id, base64.StdEncoding.EncodeToString(key))
+		require.NoError(t, f.Close())
+	}
+	addMasterKey("k1")
+	keys, err := dclslog.LoadMasterKeys(keyFile)
+	require.NoError(t, err)
+
+	// 每个 segment 放 10 条记录
+	var c dclslog.Config
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 10 * 12
+	c.MasterKeys = keys
+	clog, err := dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+
+	value := func(i int) []byte { return []byte(fmt.Sprintf("secret-%02d", i)) }
+	for i := 0; i < 5; i++ {
+		_, err := clog.Append(&api.Record{Value: value(i)})
+		require.NoError(t, err)
+	}
+
+	// 轮换主密钥之后新建的 segment 使用新的主密钥
+	addMasterKey("k2")
+	require.NoError(t, keys.Reload())
+	for i := 5; i < 15; i++ {
+		_, err := clog.Append(&api.Record{Value: value(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, clog.Close())
+
+	masterKeyID := func(base int) string {
+		b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.key", base)))
+		require.NoError(t, err)
+		var w struct {
+			MasterKeyID string `json:"master_key_id"`
+		}
+		require.NoError(t, json.Unmarshal(b, &w))
+		return w.MasterKeyID
+	}
+	require.Equal(t, "k1", masterKeyID(0))
+	require.Equal(t, "k2", masterKeyID(10))
+	store, err := os.ReadFile(filepath.Join(dir, "0.store"))
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(store, []byte("secret")))
+
+	// 没有主密钥时不能打开加密的日志
+	_, err = dclslog.NewLog(dir, dclslog.Config{Segment: c.Segment})
+	require.Error(t, err)
+
+	clog, err = dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 15; i++ {
+		record, err := clog.Read(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, value(i), record.Value)
+	}
+	require.NoError(t, clog.Close())
+
+	// 篡改第一条记录的密文，长度前缀之后是 12 字节的 nonce
+	store[8+12] ^= 0xff
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0.store"), store, 0644))
+	clog, err = dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+	defer clog.Close()
+	_, err = clog.Read(0)
+	require.Equal(t, codes.DataLoss, status.Code(err))
+	_, err = clog.Read(1)
+	require.NoError(t, err)
+}
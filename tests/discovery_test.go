@@ -1,7 +1,12 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,6 +44,66 @@ func TestMembership(t *testing.T) {
 	require.Equal(t, fmt.Sprintf("%d", 2), <-handler.leaves)
 }
 
+func TestMembershipFailAndUpdate(t *testing.T) {
+	m, handler := setupMember(t, nil)
+	m, _ = setupMember(t, m)
+	m, _ = setupMember(t, m)
+
+	require.Eventually(
+		t,
+		func() bool {
+			return len(handler.joins) == 2 && len(m[0].Members()) == 3
+		},
+		3*time.Second,
+		250*time.Millisecond,
+	)
+
+	// 节点的标签变化时通知 Handler 新的 rpc_addr
+	require.NoError(t, m[1].SetTags(map[string]string{"rpc_addr": "127.0.0.1:9999"}))
+	require.Eventually(
+		t,
+		func() bool {
+			return len(handler.updates) == 1
+		},
+		3*time.Second,
+		250*time.Millisecond,
+	)
+	require.Equal(t, map[string]string{"id": "1", "addr": "127.0.0.1:9999"}, <-handler.updates)
+
+	// 节点崩溃时通知 Handler
+	require.NoError(t, m[2].Shutdown())
+	require.Eventually(
+		t,
+		func() bool {
+			return len(handler.fails) == 1 && len(handler.leaves) == 0
+		},
+		15*time.Second,
+		250*time.Millisecond,
+	)
+	require.Equal(t, "2", <-handler.fails)
+}
+
+func TestMembershipSetTagsConcurrently(t *testing.T) {
+	m, _ := setupMember(t, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				tags := map[string]string{"rpc_addr": fmt.Sprintf("127.0.0.1:%d", 9000+i), "rack": "a"}
+				require.NoError(t, m[0].SetTags(tags))
+				// 保存的是副本
+				tags["rack"] = "b"
+				require.Equal(t, "a", m[0].LocalTags()["rack"])
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, m[0].LocalTags()["rpc_addr"], m[0].Members()[0].Tags["rpc_addr"])
+}
+
 func setupMember(t *testing.T, members []*discovery.Membership) ([]*discovery.Membership, *handler) {
 	id := len(members)
 	ports := dynaport.Get(1)
@@ -53,6 +118,8 @@ func setupMember(t *testing.T, members []*discovery.Membership) ([]*discovery.Me
 	if len(members) == 0 {
 		h.joins = make(chan map[string]string, 3)
 		h.leaves = make(chan string, 3)
+		h.fails = make(chan string, 3)
+		h.updates = make(chan map[string]string, 3)
 	} else {
 		c.StartJoinAddrs = []string{members[0].BindAddr}
 	}
@@ -63,11 +130,17 @@ func setupMember(t *testing.T, members []*discovery.Membership) ([]*discovery.Me
 }
 
 type handler struct {
-	joins  chan map[string]string
-	leaves chan string
+	joins   chan map[string]string
+	leaves  chan string
+	fails   chan string
+	updates chan map[string]string
 }
 
-var _ discovery.Handler = &handler{}
+var (
+	_ discovery.Handler       = &handler{}
+	_ discovery.FailHandler   = &handler{}
+	_ discovery.UpdateHandler = &handler{}
+)
 
 func (h *handler) Join(id, addr string) error {
 	if h.joins != nil {
@@ -82,3 +155,192 @@ func (h *handler) Leave(id string) error {
 	}
 	return nil
 }
+
+func (h *handler) Fail(id string) error {
+	if h.fails != nil {
+		h.fails <- id
+	}
+	return nil
+}
+
+func (h *handler) Update(id, addr string) error {
+	if h.updates != nil {
+		h.updates <- map[string]string{"id": id, "addr": addr}
+	}
+	return nil
+}
+
+func TestMembershipKeyRotation(t *testing.T) {
+	// memberlist 的 (*Keyring).UseKey 在加锁之前就读取了密钥列表
+	// 处理安装和使用密钥的查询的协程之间会被检测出数据竞争
+	if raceEnabled {
+		t.Skip("memberlist keyring is not race free")
+	}
+
+	dir, err := os.MkdirTemp("", "keyring")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	encodedOldKey := base64.StdEncoding.EncodeToString(oldKey)
+	encodedNewKey := base64.StdEncoding.EncodeToString(newKey)
+
+	newMember := func(id int, key []byte, join []string) (*discovery.Membership, error) {
+		addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+		return discovery.NewMembership(&handler{}, discovery.Config{
+			NodeName:       fmt.Sprintf("%d", id),
+			BindAddr:       addr,
+			Tags:           map[string]string{"rpc_addr": addr},
+			StartJoinAddrs: join,
+			EncryptKey:     key,
+			KeyringFile:    filepath.Join(dir, fmt.Sprintf("%d.keyring", id)),
+		})
+	}
+
+	m0, err := newMember(0, oldKey, nil)
+	require.NoError(t, err)
+	m1, err := newMember(1, oldKey, []string{m0.BindAddr})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(m0.Members()) == 2
+	}, 3*time.Second, 250*time.Millisecond)
+
+	// 没有正确密钥的节点无法加入集群
+	_, err = newMember(2, nil, []string{m0.BindAddr})
+	require.Error(t, err)
+
+	// 轮换密钥
+	rsp, err := m0.InstallKey(encodedNewKey)
+	require.NoError(t, err)
+	require.Equal(t, 0, rsp.NumErr)
+	_, err = m1.UseKey(encodedNewKey)
+	require.NoError(t, err)
+	_, err = m0.RemoveKey(encodedOldKey)
+	require.NoError(t, err)
+
+	rsp, err = m1.ListKeys()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{encodedNewKey: 2}, rsp.Keys)
+	require.Equal(t, map[string]int{encodedNewKey: 2}, rsp.PrimaryKeys)
+
+	// 轮换后的密钥环被写回文件，节点重启后仍然使用新密钥
+	b, err := os.ReadFile(filepath.Join(dir, "1.keyring"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), encodedNewKey)
+	require.NotContains(t, string(b), encodedOldKey)
+
+	require.NoError(t, m1.Leave())
+	require.NoError(t, m0.Leave())
+}
+
+func TestMembershipKeyringFilePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	encodedNewKey := base64.StdEncoding.EncodeToString(newKey)
+
+	newMember := func(id int, key []byte, keyringFile string, join []string) (*discovery.Membership, error) {
+		addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+		return discovery.NewMembership(&handler{}, discovery.Config{
+			NodeName:       fmt.Sprintf("%d", id),
+			BindAddr:       addr,
+			Tags:           map[string]string{"rpc_addr": addr},
+			StartJoinAddrs: join,
+			EncryptKey:     key,
+			KeyringFile:    keyringFile,
+		})
+	}
+
+	// 密钥轮换后重启的节点仍然使用原来的 -gossip-key 参数，但是以密钥环文件为准
+	rotated := filepath.Join(dir, "0.keyring")
+	require.NoError(t, os.WriteFile(rotated, []byte(`["`+encodedNewKey+`"]`), 0600))
+	m0, err := newMember(0, oldKey, rotated, nil)
+	require.NoError(t, err)
+	defer m0.Leave()
+
+	// 空的密钥环文件使用配置中的密钥初始化
+	empty := filepath.Join(dir, "1.keyring")
+	require.NoError(t, os.WriteFile(empty, nil, 0600))
+	m1, err := newMember(1, newKey, empty, []string{m0.BindAddr})
+	require.NoError(t, err)
+	defer m1.Leave()
+	b, err := os.ReadFile(empty)
+	require.NoError(t, err)
+	require.Contains(t, string(b), encodedNewKey)
+	require.Eventually(t, func() bool {
+		return len(m0.Members()) == 2
+	}, 3*time.Second, 250*time.Millisecond)
+
+	// 只有旧密钥的节点无法加入集群
+	_, err = newMember(2, oldKey, "", []string{m0.BindAddr})
+	require.Error(t, err)
+}
+
+func TestMembershipCommands(t *testing.T) {
+	m, _ := setupMember(t, nil)
+	m, _ = setupMember(t, m)
+	m, _ = setupMember(t, m)
+	require.Eventually(t, func() bool {
+		return len(m[0].Members()) == 3
+	}, 3*time.Second, 250*time.Millisecond)
+
+	type echoRequest struct {
+		Msg string `json:"msg"`
+	}
+	type echoResponse struct {
+		Node string `json:"node"`
+		Msg  string `json:"msg"`
+	}
+	broadcasts := make(chan string, 3)
+	for _, member := range m {
+		name := member.NodeName
+		member.RegisterCommand("echo", discovery.JSONCommand(func(req echoRequest) (echoResponse, error) {
+			if name == "2" {
+				return echoResponse{}, fmt.Errorf("node %s refused", name)
+			}
+			return echoResponse{Node: name, Msg: req.Msg}, nil
+		}))
+		member.RegisterCommand("notify", func(payload []byte) ([]byte, error) {
+			broadcasts <- name + ":" + string(payload)
+			return nil, nil
+		})
+	}
+
+	// 查询会收集所有节点（包括发起查询的节点）的响应
+	results, errs, err := discovery.QueryJSON[echoRequest, echoResponse](m[1], "echo", echoRequest{Msg: "hi"}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, map[string]echoResponse{
+		"0": {Node: "0", Msg: "hi"},
+		"1": {Node: "1", Msg: "hi"},
+	}, results)
+	require.Len(t, errs, 1)
+	require.EqualError(t, errs["2"], "node 2 refused")
+
+	// QueryAll 只返回没有成功执行命令的节点
+	failed, err := m[0].QueryAll("echo", []byte(`{"msg":"hi"}`), time.Second)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.EqualError(t, failed["2"], "node 2 refused")
+
+	// 没有注册的命令在每个节点上都会出错
+	responses, err := m[0].Query("unknown", nil, time.Second)
+	require.NoError(t, err)
+	require.Len(t, responses, 3)
+	for _, r := range responses {
+		require.Error(t, r.Err)
+	}
+
+	// 用户事件会被所有节点执行
+	require.NoError(t, m[0].Broadcast("notify", []byte("retention")))
+	received := make([]string, 0)
+	require.Eventually(t, func() bool {
+		select {
+		case b := <-broadcasts:
+			received = append(received, b)
+		default:
+		}
+		return len(received) == 3
+	}, 3*time.Second, 50*time.Millisecond)
+	require.ElementsMatch(t, []string{"0:retention", "1:retention", "2:retention"}, received)
+}
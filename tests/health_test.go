@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/controller"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+type fakeStorage struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (s *fakeStorage) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *fakeStorage) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+type fakeTopics map[string]*controller.Topic
+
+func (f fakeTopics) DescribeTopic(name string) (*controller.Topic, error) {
+	t, ok := f[name]
+	if !ok {
+		return nil, controller.ErrTopicNotFound
+	}
+	return t, nil
+}
+
+func TestHealth(t *testing.T) {
+	storage := &fakeStorage{}
+	topics := fakeTopics{
+		"orders":  {Name: "orders", Partitions: []controller.Partition{{ID: 0, Leader: "a"}, {ID: 1, Leader: "b"}}},
+		"billing": {Name: "billing", Partitions: []controller.Partition{{ID: 0, Leader: "a"}, {ID: 1}}},
+		"metrics": {Name: "metrics", Partitions: []controller.Partition{{ID: 0}}, Advisory: true},
+	}
+	health := logserver.NewHealth(storage, topics)
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Health = health
+	})
+	client := healthpb.NewHealthClient(s.conn(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile))
+	ctx := context.Background()
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	// 启动完成之前
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+
+	health.Start()
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("log.v1.Log"))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("log.v1.Log/orders"))
+	// 有分区没有 leader
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("log.v1.Log/billing"))
+	// 分配结果只是建议时不检查 leader
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("log.v1.Log/metrics"))
+
+	for _, service := range []string{"log.v1.Log/payments", "other.Service"} {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.Equal(t, codes.NotFound, status.Code(err), service)
+	}
+
+	// 存储出错后（检查结果最多缓存一秒）
+	storage.fail(errors.New("no space left on device"))
+	require.Eventually(t, func() bool {
+		return check("") == healthpb.HealthCheckResponse_NOT_SERVING
+	}, 3*time.Second, 100*time.Millisecond)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("log.v1.Log/orders"))
+
+	storage.fail(nil)
+	require.Eventually(t, func() bool {
+		return check("") == healthpb.HealthCheckResponse_SERVING
+	}, 3*time.Second, 100*time.Millisecond)
+
+	// 开始关闭之后
+	health.Shutdown()
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("log.v1.Log/orders"))
+}
+
+func TestHealthStorageCheck(t *testing.T) {
+	s := setupTestServer(t, nil)
+	require.NoError(t, s.Log.Check())
+
+	client := healthpb.NewHealthClient(s.conn(t, auth.RootClientCertFile, auth.RootClientKeyFile))
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestHealthStorageCheckDuringReset(t *testing.T) {
+	s := setupTestServer(t, nil)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Log.Append(&api.Record{Value: []byte("hello")})
+			s.Log.Reset()
+		}
+	}()
+
+	// 重置期间健康检查不等待锁，直接报告错误
+	require.Eventually(t, func() bool {
+		err := s.Log.Check()
+		return err != nil && strings.Contains(err.Error(), "archived")
+	}, 3*time.Second, time.Millisecond)
+	close(stop)
+	<-done
+	require.NoError(t, s.Log.Check())
+
+	// 太大的记录不是存储的问题
+	_, err := s.Log.Append(&api.Record{Value: make([]byte, 1<<20)})
+	require.Error(t, err)
+	require.NoError(t, s.Log.Check())
+}
+
+func TestReflection(t *testing.T) {
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Reflection = true
+	})
+	ctx := context.Background()
+
+	listServices := func(certFile, keyFile string) ([]string, error) {
+		client := reflectionpb.NewServerReflectionClient(s.conn(t, certFile, keyFile))
+		stream, err := client.ServerReflectionInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, s := range resp.GetListServicesResponse().GetService() {
+			names = append(names, s.Name)
+		}
+		return names, nil
+	}
+
+	services, err := listServices(auth.RootClientCertFile, auth.RootClientKeyFile)
+	require.NoError(t, err)
+	require.Contains(t, services, "log.v1.Log")
+	require.Contains(t, services, "grpc.health.v1.Health")
+
+	_, err = listServices(auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
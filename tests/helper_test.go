@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type testServer struct {
+	Addr   string
+	Dir    string
+	Log    *dclslog.Log
+	Config *logserver.LogImplConfig
+}
+
+// 在随机端口上启动一个使用双向 TLS 认证的日志服务器
+// 参数 fn 不为空时可以在创建服务器前修改服务器使用的实现
+// 测试结束时自动关闭服务器并删除日志目录
+func setupTestServer(t *testing.T, fn func(*logserver.LogImplConfig)) *testServer {
+	t.Helper()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+	lis, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "test-log-services")
+	require.NoError(t, err)
+	clog, err := dclslog.NewLog(dir, dclslog.Config{})
+	require.NoError(t, err)
+
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+		ServerName:      addr,
+	})
+	require.NoError(t, err)
+
+	c := &logserver.LogImplConfig{
+		CommitLog:  clog,
+		Authorizer: auth.NewAuthorizer(auth.ACLModelFile, auth.ACLPolicyFile),
+		Archives:   clog,
+	}
+	if fn != nil {
+		fn(c)
+	}
+	server, err := logserver.NewgRPCServer(c, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	go server.Serve(lis)
+
+	t.Cleanup(func() {
+		server.Stop()
+		clog.Close()
+		os.RemoveAll(dir)
+		os.RemoveAll(dir + ".archive")
+	})
+	return &testServer{Addr: addr, Dir: dir, Log: clog, Config: c}
+}
+
+// 以 certFile 和 keyFile 对应的用户身份连接服务器
+func (s *testServer) client(t *testing.T, certFile, keyFile string, opts ...grpc.DialOption) api.LogClient {
+	t.Helper()
+	return api.NewLogClient(s.conn(t, certFile, keyFile, opts...))
+}
+
+// 用于连接 Log 之外的服务，比如健康检查和服务器反射
+func (s *testServer) conn(t *testing.T, certFile, keyFile string, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+
+	clientTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        certFile,
+		KeyFile:         keyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
+	conn, err := grpc.Dial(s.Addr, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// 测试生成的客户端证书中的 SAN
+const (
+	testDNSName  = "writer.orders.svc"
+	testSPIFFEID = "spiffe://corp/ns/orders/sa/writer"
+	testEmail    = "writer@corp.example"
+)
+
+// 生成一个新的根证书（及其私钥）以及由它签发的服务端和客户端证书
+// 所有证书的序列号都是 serial
+// 客户端证书的 CN 是 client，还带有 DNS、URI 和电子邮件类型的 SAN
+func writeTestCerts(t *testing.T, dir string, serial int64) {
+	t.Helper()
+
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		return key
+	}
+	write := func(name, typ string, b []byte) {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dir, name),
+			pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}),
+			0600,
+		))
+	}
+
+	caKey := newKey()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	write("ca.pem", "CERTIFICATE", caDER)
+	caKeyDER, err := x509.MarshalECPrivateKey(caKey)
+	require.NoError(t, err)
+	write("ca-key.pem", "EC PRIVATE KEY", caKeyDER)
+
+	spiffeID, err := url.Parse(testSPIFFEID)
+	require.NoError(t, err)
+	for name, usage := range map[string]x509.ExtKeyUsage{
+		"server": x509.ExtKeyUsageServerAuth,
+		"client": x509.ExtKeyUsageClientAuth,
+	} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		if name == "client" {
+			template.DNSNames = []string{testDNSName}
+			template.URIs = []*url.URL{spiffeID}
+			template.EmailAddresses = []string{testEmail}
+		}
+		key := newKey()
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		write(name+"-key.pem", "EC PRIVATE KEY", keyDER)
+		write(name+".pem", "CERTIFICATE", der)
+	}
+}
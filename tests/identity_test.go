@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestIdentityExtraction(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCerts(t, dir, 1)
+
+	// 启动一个只允许 subject 追加日志的服务器
+	// 参数 mutual 为假时服务器不要求客户端提供证书
+	serve := func(t *testing.T, identity logserver.Identity, subject string, mutual bool) string {
+		policy := filepath.Join(t.TempDir(), "policy.csv")
+		require.NoError(t, os.WriteFile(policy, []byte(fmt.Sprintf("p, %s, *, append\n", subject)), 0644))
+
+		clog, err := dclslog.NewLog(t.TempDir(), dclslog.Config{})
+		require.NoError(t, err)
+		serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+			IsServerConfig:  true,
+			EnableMutualTLS: mutual,
+			CertFile:        filepath.Join(dir, "server.pem"),
+			KeyFile:         filepath.Join(dir, "server-key.pem"),
+			CAFile:          filepath.Join(dir, "ca.pem"),
+		})
+		require.NoError(t, err)
+		server, err := logserver.NewgRPCServer(&logserver.LogImplConfig{
+			CommitLog:  clog,
+			Authorizer: auth.NewAuthorizer(auth.ACLModelFile, policy),
+			Identity:   identity,
+		}, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+		require.NoError(t, err)
+
+		addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+		lis, err := net.Listen("tcp", addr)
+		require.NoError(t, err)
+		go server.Serve(lis)
+		t.Cleanup(func() {
+			server.Stop()
+			clog.Close()
+		})
+		return addr
+	}
+
+	appendRecord := func(t *testing.T, addr string) error {
+		clientTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+			EnableMutualTLS: true,
+			CertFile:        filepath.Join(dir, "client.pem"),
+			KeyFile:         filepath.Join(dir, "client-key.pem"),
+			CAFile:          filepath.Join(dir, "ca.pem"),
+		})
+		require.NoError(t, err)
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = api.NewLogClient(conn).Append(context.Background(), &api.AppendRequest{
+			Record: &api.Record{Value: []byte("hello")},
+		})
+		return err
+	}
+
+	for name, subject := range map[string]string{
+		"cn":     "client",
+		"dns":    testDNSName,
+		"spiffe": testSPIFFEID,
+		"email":  testEmail,
+	} {
+		t.Run(name, func(t *testing.T) {
+			identity, err := logserver.ParseIdentity(name)
+			require.NoError(t, err)
+			require.NoError(t, appendRecord(t, serve(t, identity, subject, true)))
+		})
+	}
+
+	t.Run("no verified client certificate", func(t *testing.T) {
+		err := appendRecord(t, serve(t, logserver.CommonNameIdentity, "client", false))
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("unknown identity", func(t *testing.T) {
+		_, err := logserver.ParseIdentity("serial")
+		require.Error(t, err)
+	})
+}
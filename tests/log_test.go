@@ -2,6 +2,7 @@ package tests
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 
@@ -202,3 +203,31 @@ func TestLogOperations(t *testing.T) {
 		// -------------------- TestCase 5 --------------------
 	})
 }
+
+func TestLogRecordLargerThanSegment(t *testing.T) {
+	dir := t.TempDir()
+	clog, err := dclslog.NewLog(dir, dclslog.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	_, err = clog.Append(&api.Record{Value: []byte("small")})
+	require.NoError(t, err)
+
+	// 比 segment 还大的记录无法写入，多次尝试也不会留下空的 segment
+	large := &api.Record{Value: make([]byte, 8<<10)}
+	for i := 0; i < 3; i++ {
+		_, err = clog.Append(large)
+		require.Error(t, err)
+	}
+	stores, err := filepath.Glob(filepath.Join(dir, "*.store"))
+	require.NoError(t, err)
+	require.Len(t, stores, 2)
+
+	// 之后的记录写入新的 segment
+	off, err := clog.Append(&api.Record{Value: []byte("after")})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, off)
+	stores, err = filepath.Glob(filepath.Join(dir, "*.store"))
+	require.NoError(t, err)
+	require.Len(t, stores, 2)
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/adminserver"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// 使用 observer 替换全局的 logger，测试结束后恢复
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+	return logs
+}
+
+func TestRequestLogging(t *testing.T) {
+	logs := observeLogs(t)
+	s := setupTestServer(t, nil)
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	readonly := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	ctx := context.Background()
+
+	var header metadata.MD
+	_, err := root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}}, grpc.Header(&header))
+	require.NoError(t, err)
+	requestID := header.Get(logserver.RequestIDKey)
+	require.Len(t, requestID, 1)
+
+	// 失败的请求也返回请求 ID
+	var denied metadata.MD
+	_, err = readonly.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}}, grpc.Header(&denied))
+	require.Error(t, err)
+	require.Len(t, denied.Get(logserver.RequestIDKey), 1)
+	require.NotEqual(t, requestID, denied.Get(logserver.RequestIDKey))
+
+	entries := logs.FilterMessage("handled request").AllUntimed()
+	require.Len(t, entries, 2)
+	fields := entries[0].ContextMap()
+	require.Equal(t, requestID[0], fields["request_id"])
+	require.Equal(t, "/log.v1.Log/Append", fields["method"])
+	require.Equal(t, "root user", fields["subject"])
+	require.Equal(t, "OK", fields["code"])
+	require.Contains(t, fields, "duration")
+	require.Contains(t, fields, "peer")
+
+	fields = entries[1].ContextMap()
+	require.Equal(t, "readonly user", fields["subject"])
+	require.Equal(t, "PermissionDenied", fields["code"])
+	require.Contains(t, fields, "error")
+}
+
+func TestStorageEventLogging(t *testing.T) {
+	logs := observeLogs(t)
+	dir := t.TempDir()
+	var c dclslog.Config
+	c.Segment.MaxIndexBytes = 12
+	l, err := dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := l.Append(&api.Record{Value: []byte("hello")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	rolled := logs.FilterMessage("rolled segment").AllUntimed()
+	require.Len(t, rolled, 2)
+	require.Equal(t, uint64(1), rolled[0].ContextMap()["segment"])
+
+	l, err = dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	recovered := logs.FilterMessage("recovered segments").AllUntimed()
+	require.Len(t, recovered, 1)
+	require.Equal(t, int64(3), recovered[0].ContextMap()["segments"])
+	require.Equal(t, uint64(2), recovered[0].ContextMap()["next_offset"])
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	admin, err := adminserver.New(adminserver.Config{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	admin.Handle("/log/level", level)
+	go admin.Serve()
+	defer admin.Shutdown(context.Background())
+
+	url := "http://" + admin.Addr() + "/log/level"
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"level":"debug"}`))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, zapcore.DebugLevel, level.Level())
+
+	resp, err = http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"level":"debug"}`, string(body))
+}
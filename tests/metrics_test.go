@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/adminserver"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogMetrics(t *testing.T) {
+	dir := t.TempDir()
+	registry := metrics.NewRegistry()
+	m := metrics.NewLogMetrics(registry)
+
+	var c dclslog.Config
+	c.Segment.MaxIndexBytes = 2 * 12
+	c.Metrics = m
+	l, err := dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(record)
+		require.NoError(t, err)
+	}
+	_, err = l.Read(1)
+	require.NoError(t, err)
+
+	require.Equal(t, float64(3), m.Appends.Value())
+	require.Greater(t, m.AppendedBytes.Value(), float64(3*len(record.Value)))
+	require.Equal(t, float64(1), m.Reads.Value())
+	require.Equal(t, uint64(4), m.LockWaitSeconds.Count())
+	// 每个 segment 保存两条记录，第三条记录写入第二个 segment
+	require.Equal(t, float64(2), m.SegmentCount.Value())
+	require.Equal(t, 0.5, m.ActiveFillRatio.Value())
+	require.Equal(t, float64(0), m.RecoveredSegments.Value())
+
+	// 重新打开时恢复两个 segment
+	require.NoError(t, l.Close())
+	l, err = dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, float64(2), m.RecoveredSegments.Value())
+}
+
+func TestRPCMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := metrics.NewRPCMetrics(registry)
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Metrics = m
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	readonly := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	ctx := context.Background()
+
+	_, err := root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	_, err = readonly.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = root.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.NoError(t, err)
+
+	const appendMethod = "/log.v1.Log/Append"
+	require.Equal(t, float64(1), m.Requests.Value(appendMethod, "OK", "root user"))
+	require.Equal(t, float64(1), m.Requests.Value(appendMethod, "PermissionDenied", "readonly user"))
+	require.Equal(t, uint64(2), m.DurationSeconds.Count(appendMethod))
+	require.Equal(t, uint64(1), m.DurationSeconds.Count("/log.v1.Log/Read"))
+
+	// 通过管理服务器以 Prometheus 文本格式导出
+	admin, err := adminserver.New(adminserver.Config{Addr: "127.0.0.1:0", Metrics: registry.Handler()})
+	require.NoError(t, err)
+	go admin.Serve()
+	defer admin.Shutdown(ctx)
+
+	resp, err := http.Get("http://" + admin.Addr() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "# TYPE dcls_rpc_requests_total counter\n")
+	require.Contains(t, string(body), `dcls_rpc_requests_total{method="/log.v1.Log/Append",code="OK",subject="root user"} 1`)
+	require.Contains(t, string(body), `dcls_rpc_duration_seconds_count{method="/log.v1.Log/Append"} 2`)
+	require.Contains(t, string(body), `dcls_rpc_duration_seconds_bucket{method="/log.v1.Log/Read",le="+Inf"} 1`)
+}
@@ -0,0 +1,280 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/mirror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMirror(t *testing.T) {
+	// 两个运行在本地回环地址上的集群
+	source := setupTestServer(t, nil).client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	target := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		checkpoints, err := mirror.NewFileCheckpoints(filepath.Join(t.TempDir(), "checkpoints.json"))
+		require.NoError(t, err)
+		c.Checkpoints = checkpoints
+	}).client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	appendTo := func(topic, value string) uint64 {
+		rsp, err := source.Append(ctx, &api.AppendRequest{Record: &api.Record{
+			Value:   []byte(value),
+			Topic:   topic,
+			Headers: map[string][]byte{"producer": []byte("test")},
+		}})
+		require.NoError(t, err)
+		return rsp.Offset
+	}
+
+	// 只镜像 orders 主题
+	run := func() (stop func()) {
+		m, err := mirror.New(mirror.Config{
+			SourceCluster:      "shanghai",
+			Source:             source,
+			Target:             target,
+			Topics:             []string{"orders"},
+			PollInterval:       50 * time.Millisecond,
+			CheckpointInterval: 2,
+		})
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- m.Run(ctx) }()
+		return func() {
+			cancel()
+			require.NoError(t, <-done)
+		}
+	}
+
+	// 读取目标集群中的所有记录，检查点不写入日志，所以它们都是被镜像的 orders 记录
+	mirrored := func() []*api.Record {
+		offsets, err := target.Offsets(ctx, &api.OffsetsRequest{})
+		require.NoError(t, err)
+		records := make([]*api.Record, 0)
+		for off := offsets.Lowest; off < offsets.NextOffset; off++ {
+			rsp, err := target.Read(ctx, &api.ReadRequest{Offset: off})
+			require.NoError(t, err)
+			require.Equal(t, "orders", rsp.Record.Topic)
+			records = append(records, rsp.Record)
+		}
+		return records
+	}
+
+	sourceOffsets := []uint64{
+		appendTo("orders", "order-1"),
+		appendTo("payments", "payment-1"),
+		appendTo("payments", "payment-2"),
+		appendTo("orders", "order-2"),
+	}
+	stop := run()
+	require.Eventually(t, func() bool {
+		return len(mirrored()) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// 保留原有的 header 并添加来源信息
+	records := mirrored()
+	for i, record := range records {
+		require.Equal(t, []byte("test"), record.Headers["producer"])
+		require.Equal(t, []byte("shanghai"), record.Headers[mirror.SourceClusterHeader])
+		require.Equal(t, []byte("order-"+strconv.Itoa(i+1)), record.Value)
+	}
+	require.Equal(t, []byte(strconv.FormatUint(sourceOffsets[0], 10)), records[0].Headers[mirror.SourceOffsetHeader])
+	require.Equal(t, []byte(strconv.FormatUint(sourceOffsets[3], 10)), records[1].Headers[mirror.SourceOffsetHeader])
+
+	// 停止时保存进度
+	stop()
+	checkpoint, err := target.GetMirrorCheckpoint(ctx, &api.GetMirrorCheckpointRequest{SourceCluster: "shanghai"})
+	require.NoError(t, err)
+	require.True(t, checkpoint.Found)
+	require.Equal(t, sourceOffsets[3]+1, checkpoint.NextOffset)
+
+	// 重启后从停止的地方继续，不会重复镜像
+	appendTo("payments", "payment-3")
+	appendTo("orders", "order-3")
+	stop = run()
+	defer stop()
+	require.Eventually(t, func() bool {
+		return len(mirrored()) == 3
+	}, 3*time.Second, 50*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	records = mirrored()
+	require.Len(t, records, 3)
+	require.Equal(t, []byte("order-3"), records[2].Value)
+}
+
+func TestMirrorCheckpointAuthorization(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "checkpoints.json")
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		checkpoints, err := mirror.NewFileCheckpoints(file)
+		require.NoError(t, err)
+		c.Checkpoints = checkpoints
+	})
+	ctx := context.Background()
+
+	// 只读用户可以读取进度但不能保存进度
+	readonly := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	rsp, err := readonly.GetMirrorCheckpoint(ctx, &api.GetMirrorCheckpointRequest{SourceCluster: "shanghai"})
+	require.NoError(t, err)
+	require.False(t, rsp.Found)
+	_, err = readonly.SetMirrorCheckpoint(ctx, &api.SetMirrorCheckpointRequest{SourceCluster: "shanghai", NextOffset: 1})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ordinary := s.client(t, auth.OrdinaryClientCertFile, auth.OrdinaryClientKeyFile)
+	_, err = ordinary.SetMirrorCheckpoint(ctx, &api.SetMirrorCheckpointRequest{SourceCluster: "shanghai", NextOffset: 7})
+	require.NoError(t, err)
+	_, err = ordinary.SetMirrorCheckpoint(ctx, &api.SetMirrorCheckpointRequest{NextOffset: 7})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	rsp, err = readonly.GetMirrorCheckpoint(ctx, &api.GetMirrorCheckpointRequest{SourceCluster: "shanghai"})
+	require.NoError(t, err)
+	require.True(t, rsp.Found)
+	require.Equal(t, uint64(7), rsp.NextOffset)
+
+	// 进度保存在文件中，重启后仍然存在，但不写入日志
+	reloaded, err := mirror.NewFileCheckpoints(file)
+	require.NoError(t, err)
+	next, ok, err := reloaded.Checkpoint("shanghai")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(7), next)
+	offsets, err := readonly.Offsets(ctx, &api.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, offsets.Lowest, offsets.NextOffset)
+}
+
+func TestMirrorSourceReset(t *testing.T) {
+	source := setupTestServer(t, nil).client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	target := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		checkpoints, err := mirror.NewFileCheckpoints(filepath.Join(t.TempDir(), "checkpoints.json"))
+		require.NoError(t, err)
+		c.Checkpoints = checkpoints
+	}).client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	appendTo := func(value string) {
+		_, err := source.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+	mirrored := func() []string {
+		offsets, err := target.Offsets(ctx, &api.OffsetsRequest{})
+		require.NoError(t, err)
+		values := make([]string, 0)
+		for off := offsets.Lowest; off < offsets.NextOffset; off++ {
+			rsp, err := target.Read(ctx, &api.ReadRequest{Offset: off})
+			require.NoError(t, err)
+			values = append(values, string(rsp.Record.Value))
+		}
+		return values
+	}
+
+	m, err := mirror.New(mirror.Config{
+		SourceCluster: "shanghai",
+		Source:        source,
+		Target:        target,
+		PollInterval:  20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+
+	for i := 0; i < 5; i++ {
+		appendTo("old-" + strconv.Itoa(i))
+	}
+	require.Eventually(t, func() bool {
+		return len(mirrored()) == 5
+	}, 3*time.Second, 20*time.Millisecond)
+
+	// 重置之后源集群的日志从零开始，新的记录都要被镜像
+	reset, err := source.Reset(ctx, &api.ResetRequest{})
+	require.NoError(t, err)
+	_, err = source.Reset(ctx, &api.ResetRequest{ConfirmationToken: reset.ConfirmationToken})
+	require.NoError(t, err)
+	appendTo("new-0")
+	require.Eventually(t, func() bool {
+		return len(mirrored()) == 6
+	}, 3*time.Second, 20*time.Millisecond)
+	for i := 1; i < 7; i++ {
+		appendTo("new-" + strconv.Itoa(i))
+	}
+	require.Eventually(t, func() bool {
+		return len(mirrored()) == 12
+	}, 3*time.Second, 20*time.Millisecond)
+	values := mirrored()
+	for i := 0; i < 7; i++ {
+		require.Equal(t, "new-"+strconv.Itoa(i), values[5+i])
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestMirrorSkipsUnreadableTopics(t *testing.T) {
+	// 只读用户只能读取 orders 主题
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	b, err := os.ReadFile(auth.ACLPolicyFile)
+	require.NoError(t, err)
+	b = bytes.Replace(b, []byte("g, readonly user, reader"), []byte("p, readonly user, orders, read"), 1)
+	require.NoError(t, os.WriteFile(policyFile, b, 0644))
+	sourceServer := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Authorizer = auth.NewAuthorizer(auth.ACLModelFile, policyFile)
+	})
+	source := sourceServer.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	target := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		checkpoints, err := mirror.NewFileCheckpoints(filepath.Join(t.TempDir(), "checkpoints.json"))
+		require.NoError(t, err)
+		c.Checkpoints = checkpoints
+	}).client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, r := range []struct{ topic, value string }{
+		{"orders", "order-1"},
+		{"payments", "payment-1"},
+		{"orders", "order-2"},
+	} {
+		_, err := source.Append(ctx, &api.AppendRequest{Record: &api.Record{Topic: r.topic, Value: []byte(r.value)}})
+		require.NoError(t, err)
+	}
+
+	m, err := mirror.New(mirror.Config{
+		SourceCluster:      "shanghai",
+		Source:             sourceServer.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile),
+		Target:             target,
+		Topics:             []string{"orders"},
+		PollInterval:       20 * time.Millisecond,
+		CheckpointInterval: 1,
+	})
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+
+	// 没有权限读取的 payments 记录被跳过，镜像不会停止
+	require.Eventually(t, func() bool {
+		checkpoint, err := target.GetMirrorCheckpoint(ctx, &api.GetMirrorCheckpointRequest{SourceCluster: "shanghai"})
+		return err == nil && checkpoint.NextOffset == 3
+	}, 3*time.Second, 20*time.Millisecond)
+	offsets, err := target.Offsets(ctx, &api.OffsetsRequest{})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, offsets.NextOffset-offsets.Lowest)
+	select {
+	case err := <-done:
+		t.Fatalf("mirror stopped: %v", err)
+	default:
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
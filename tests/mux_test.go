@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestMultiplexPeerAndClientTraffic(t *testing.T) {
+	addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+	lis, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+
+	ldir, err := os.MkdirTemp("", "mux-log-services")
+	require.NoError(t, err)
+	defer os.RemoveAll(ldir)
+	clog, err := dclslog.NewLog(ldir, dclslog.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+		ServerName:      addr,
+	})
+	require.NoError(t, err)
+	peerTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+
+	m := mux.New(lis)
+	peers, err := mux.NewStreamLayer(m.Match(mux.PeerStream), serverTLSConfig, peerTLSConfig)
+	require.NoError(t, err)
+
+	server, err := logserver.NewgRPCServer(
+		&logserver.LogImplConfig{
+			CommitLog:  clog,
+			Authorizer: auth.NewAuthorizer(auth.ACLModelFile, auth.ACLPolicyFile),
+		},
+		grpc.Creds(credentials.NewTLS(serverTLSConfig)),
+	)
+	require.NoError(t, err)
+	go server.Serve(m.Default())
+	go m.Serve()
+	defer func() {
+		server.Stop()
+		m.Close()
+	}()
+
+	// 节点之间的连接被交给复制层
+	go func() {
+		conn, err := peers.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	peerConn, err := peers.Dial(addr, time.Second)
+	require.NoError(t, err)
+	defer peerConn.Close()
+	_, err = peerConn.Write([]byte("replicate"))
+	require.NoError(t, err)
+	b := make([]byte, len("replicate"))
+	_, err = io.ReadFull(peerConn, b)
+	require.NoError(t, err)
+	require.Equal(t, "replicate", string(b))
+
+	// 同一个端口上的其他连接被交给 gRPC 服务器
+	clientTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        auth.RootClientCertFile,
+		KeyFile:         auth.RootClientKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api.NewLogClient(conn)
+	appendRsp, err := client.Append(context.Background(), &api.AppendRequest{
+		Record: &api.Record{Value: []byte("hello")},
+	})
+	require.NoError(t, err)
+	readRsp, err := client.Read(context.Background(), &api.ReadRequest{Offset: appendRsp.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), readRsp.Record.Value)
+
+	// 没有证书的节点无法建立复制连接
+	noCertTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig: false,
+		CAFile:         auth.CAFile,
+	})
+	require.NoError(t, err)
+	_, err = mux.NewStreamLayer(m.Match(mux.PeerStream), serverTLSConfig, noCertTLSConfig)
+	require.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPolicyManagement(t *testing.T) {
+	// 修改的是策略文件的副本
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	b, err := os.ReadFile(auth.ACLPolicyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(policyFile, b, 0644))
+	authorizer := auth.NewAuthorizer(auth.ACLModelFile, policyFile)
+
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Authorizer = authorizer
+		c.Policies = authorizer
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	readonly := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	ctx := context.Background()
+
+	// 只有拥有 admin 权限的用户可以管理策略
+	_, err = readonly.ListPolicies(ctx, &api.ListPoliciesRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	list, err := root.ListPolicies(ctx, &api.ListPoliciesRequest{})
+	require.NoError(t, err)
+	require.Contains(t, list.Roles, &api.RoleAssignment{Subject: "readonly user", Role: "reader"})
+
+	appendRecord := func() error {
+		_, err := readonly.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}})
+		return err
+	}
+	require.Equal(t, codes.PermissionDenied, status.Code(appendRecord()))
+
+	// 分配角色之后立即生效
+	role := &api.RoleRequest{Role: &api.RoleAssignment{Subject: "readonly user", Role: "writer"}}
+	rsp, err := root.AddRoleForSubject(ctx, role)
+	require.NoError(t, err)
+	require.True(t, rsp.Changed)
+	rsp, err = root.AddRoleForSubject(ctx, role)
+	require.NoError(t, err)
+	require.False(t, rsp.Changed)
+	require.NoError(t, appendRecord())
+
+	// 删除 writer 的追加权限
+	policy := &api.PolicyRequest{Policy: &api.Policy{Subject: "writer", Object: "*", Action: "append"}}
+	rsp, err = root.RemovePolicy(ctx, policy)
+	require.NoError(t, err)
+	require.True(t, rsp.Changed)
+	require.Equal(t, codes.PermissionDenied, status.Code(appendRecord()))
+
+	// 只允许追加到 orders 开头的主题
+	rsp, err = root.AddPolicy(ctx, &api.PolicyRequest{Policy: &api.Policy{Subject: "writer", Object: "orders.*", Action: "append"}})
+	require.NoError(t, err)
+	require.True(t, rsp.Changed)
+	_, err = readonly.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello"), Topic: "orders.created"}})
+	require.NoError(t, err)
+
+	_, err = root.AddPolicy(ctx, &api.PolicyRequest{Policy: &api.Policy{Subject: "writer", Action: "append"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = root.AddPolicy(ctx, &api.PolicyRequest{Policy: &api.Policy{Subject: "writer", Object: "a,b", Action: "append"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 修改被写回了策略文件
+	reloaded := auth.NewAuthorizer(auth.ACLModelFile, policyFile)
+	require.NoError(t, reloaded.Authorize("readonly user", "orders.created", "append"))
+	require.Error(t, reloaded.Authorize("readonly user", "all logs", "append"))
+	require.NoError(t, reloaded.Authorize("root user", "policies", "admin"))
+
+	// 原来的注释被保留，新的规则在同类规则的最后
+	b, err = os.ReadFile(policyFile)
+	require.NoError(t, err)
+	content := string(b)
+	require.Contains(t, content, "# 角色的权限\n")
+	require.Contains(t, content, "# 只能访问部分主题的角色，比如\n")
+	require.NotContains(t, content, "p, writer, *, append")
+	require.Less(t, strings.Index(content, "p, writer, orders.*, append"), strings.Index(content, "# 角色的继承关系"))
+	require.Greater(t, strings.Index(content, "g, readonly user, writer"), strings.Index(content, "g, readonly user, reader"))
+}
+
+// 修改没有被所有节点确认的策略存储
+type partiallyReplicatedPolicies struct {
+	*auth.Authorizer
+}
+
+func (p partiallyReplicatedPolicies) AddPolicy(policy auth.Policy) (bool, error) {
+	changed, err := p.Authorizer.AddPolicy(policy)
+	if err != nil {
+		return changed, err
+	}
+	return changed, &logserver.ReplicationError{Failed: map[string]error{"node-2": errors.New("no response before timeout")}}
+}
+
+func TestPolicyReplicationFailure(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	b, err := os.ReadFile(auth.ACLPolicyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(policyFile, b, 0644))
+	authorizer := auth.NewAuthorizer(auth.ACLModelFile, policyFile)
+
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Authorizer = authorizer
+		c.Policies = partiallyReplicatedPolicies{authorizer}
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+
+	// 修改在本节点生效，没有确认的节点在响应中返回
+	rsp, err := root.AddPolicy(context.Background(), &api.PolicyRequest{Policy: &api.Policy{Subject: "writer", Object: "orders.*", Action: "reset"}})
+	require.NoError(t, err)
+	require.True(t, rsp.Changed)
+	require.Equal(t, map[string]string{"node-2": "no response before timeout"}, rsp.FailedNodes)
+	require.NoError(t, authorizer.Authorize("ordinary user", "orders.created", "reset"))
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/quota"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuotas(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"default": {"reads_per_second": 1},
+		"roles": {"writer": {"appends_per_second": 2, "bytes_per_second": 1000}},
+		"subjects": {"root user": {}}
+	}`), 0644))
+
+	authorizer := auth.NewAuthorizer(auth.ACLModelFile, auth.ACLPolicyFile)
+	quotas, err := quota.NewManager(file, authorizer.RolesForSubject)
+	require.NoError(t, err)
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Authorizer = authorizer
+		c.Quotas = quotas
+	})
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ordinary := s.client(t, auth.OrdinaryClientCertFile, auth.OrdinaryClientKeyFile)
+	readonly := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	ctx := context.Background()
+
+	appendRecord := func(c api.LogClient) error {
+		_, err := c.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}})
+		return err
+	}
+
+	// writer 的限额是每秒追加两条
+	require.NoError(t, appendRecord(ordinary))
+	require.NoError(t, appendRecord(ordinary))
+	err = appendRecord(ordinary)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	var retry *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	require.NotNil(t, retry)
+	require.Greater(t, retry.RetryDelay.AsDuration(), time.Duration(0))
+	require.LessOrEqual(t, retry.RetryDelay.AsDuration(), time.Second)
+
+	// 每个用户有自己的令牌桶，root user 不受限制
+	for i := 0; i < 10; i++ {
+		require.NoError(t, appendRecord(root))
+	}
+
+	// readonly user 使用 reader 角色，reader 没有配置限额时使用 default
+	_, err = readonly.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = readonly.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 运行时修改限额
+	require.NoError(t, os.WriteFile(file, []byte(`{"roles": {"reader": {"max_streams": 1}}}`), 0644))
+	require.NoError(t, quotas.Reload())
+	_, err = readonly.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.NoError(t, err)
+
+	// 同时进行的请求数量
+	release, err := quotas.Acquire("readonly user")
+	require.NoError(t, err)
+	_, err = readonly.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	release()
+	_, err = readonly.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.NoError(t, err)
+}
+
+func TestQuotaChangeKeepsTokens(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"default": {"appends_per_second": 1}}`), 0644))
+	quotas, err := quota.NewManager(file, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, quotas.AllowAppend("ordinary user", 5))
+	require.Equal(t, codes.ResourceExhausted, status.Code(quotas.AllowAppend("ordinary user", 5)))
+
+	// 调高限额不会补满令牌桶，用完的令牌仍然需要按照速率补充
+	require.NoError(t, os.WriteFile(file, []byte(`{"default": {"appends_per_second": 2}}`), 0644))
+	require.NoError(t, quotas.Reload())
+	require.Equal(t, codes.ResourceExhausted, status.Code(quotas.AllowAppend("ordinary user", 5)))
+
+	// 没有用过的用户仍然从满的令牌桶开始
+	require.NoError(t, quotas.AllowAppend("root user", 5))
+	require.NoError(t, quotas.AllowAppend("root user", 5))
+	require.Equal(t, codes.ResourceExhausted, status.Code(quotas.AllowAppend("root user", 5)))
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRoleBasedAccessControl(t *testing.T) {
+	type check struct {
+		subject, object, action string
+		allowed                 bool
+	}
+	run := func(t *testing.T, a *auth.Authorizer, checks []check) {
+		for _, c := range checks {
+			err := a.Authorize(c.subject, c.object, c.action)
+			if c.allowed {
+				require.NoError(t, err, "%s %s %s", c.subject, c.action, c.object)
+			} else {
+				require.Equal(t, codes.PermissionDenied, status.Code(err), "%s %s %s", c.subject, c.action, c.object)
+			}
+		}
+	}
+
+	t.Run("shipped policy", func(t *testing.T) {
+		a := auth.NewAuthorizer(auth.ACLModelFile, auth.ACLPolicyFile)
+		run(t, a, []check{
+			{"root user", "all logs", "reset", true},
+			{"root user", "orders", "append", true},
+			{"root user", "cluster", "keyring", true},
+			{"ordinary user", "orders", "append", true},
+			{"ordinary user", "all logs", "read", true},
+			{"ordinary user", "all logs", "reset", false},
+			{"ordinary user", "cluster", "query", false},
+			{"readonly user", "orders", "read", true},
+			{"readonly user", "orders", "append", false},
+			{"nobody", "all logs", "read", false},
+		})
+	})
+
+	t.Run("topic patterns and role inheritance", func(t *testing.T) {
+		policy := filepath.Join(t.TempDir(), "policy.csv")
+		require.NoError(t, os.WriteFile(policy, []byte(
+			"p, reader, all logs, read\n"+
+				"p, orders writer, orders.*, append\n"+
+				"p, orders writer, orders.*, read\n"+
+				"g, orders writer, reader\n"+
+				"g, order service, orders writer\n",
+		), 0644))
+		a := auth.NewAuthorizer(auth.ACLModelFile, policy)
+		run(t, a, []check{
+			{"order service", "orders.created", "append", true},
+			{"order service", "orders.shipped", "read", true},
+			{"order service", "all logs", "read", true},
+			{"order service", "orders", "append", false},
+			{"order service", "payments.created", "append", false},
+			{"order service", "all logs", "append", false},
+			{"orders writer", "orders.created", "append", true},
+			{"reader", "orders.created", "read", false},
+		})
+	})
+}
+
+func TestReadAuthorization(t *testing.T) {
+	// 只读用户只能读取 orders 主题，普通用户没有任何角色
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(
+		"p, admin, *, read\n"+
+			"p, admin, *, append\n"+
+			"p, orders reader, orders.*, read\n"+
+			"g, root user, admin\n"+
+			"g, readonly user, orders reader\n",
+	), 0644))
+	// 副本的 commit index 超过日志的末尾，这样可以读取不存在的记录
+	replica := &laggingReplica{commitIndex: 10}
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Authorizer = auth.NewAuthorizer(auth.ACLModelFile, policy)
+		c.Replica = replica
+	})
+	ctx := context.Background()
+
+	root := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	for _, topic := range []string{"orders.created", "payments.created"} {
+		_, err := root.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte(topic), Topic: topic}})
+		require.NoError(t, err)
+	}
+
+	// 没有任何读取权限的用户在访问存储之前就被拒绝
+	nobody := s.client(t, auth.OrdinaryClientCertFile, auth.OrdinaryClientKeyFile)
+	for _, offset := range []uint64{0, 5, 99} {
+		_, err := nobody.Read(ctx, &api.ReadRequest{Offset: offset})
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+	_, err := nobody.Offsets(ctx, &api.OffsetsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// 只能读取部分主题的用户无法区分不存在的记录和没有权限读取的记录
+	orders := s.client(t, auth.ReadOnlyClientCertFile, auth.ReadOnlyClientKeyFile)
+	readRsp, err := orders.Read(ctx, &api.ReadRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, "orders.created", readRsp.Record.Topic)
+	_, forbidden := orders.Read(ctx, &api.ReadRequest{Offset: 1})
+	require.Equal(t, codes.PermissionDenied, status.Code(forbidden))
+	_, missing := orders.Read(ctx, &api.ReadRequest{Offset: 5})
+	require.Equal(t, codes.PermissionDenied, status.Code(missing))
+	require.Equal(t, strings.Replace(status.Convert(forbidden).Message(), "1", "5", 1), status.Convert(missing).Message())
+	_, err = orders.Offsets(ctx, &api.OffsetsRequest{})
+	require.NoError(t, err)
+
+	// 可以读取所有主题的用户仍然可以知道记录不存在
+	_, err = root.Read(ctx, &api.ReadRequest{Offset: 5})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/youngfr/dcls/internal/auth"
+)
+
+func TestReloadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCerts(t, dir, 1)
+
+	serverCerts, err := auth.NewCertReloader(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        filepath.Join(dir, "server.pem"),
+		KeyFile:         filepath.Join(dir, "server-key.pem"),
+		CAFile:          filepath.Join(dir, "ca.pem"),
+	})
+	require.NoError(t, err)
+	clientCerts, err := auth.NewCertReloader(auth.TLSConfig{
+		EnableMutualTLS: true,
+		CertFile:        filepath.Join(dir, "client.pem"),
+		KeyFile:         filepath.Join(dir, "client-key.pem"),
+		CAFile:          filepath.Join(dir, "ca.pem"),
+	})
+	require.NoError(t, err)
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverCerts.Config())
+	require.NoError(t, err)
+	defer lis.Close()
+	// 服务端把客户端证书的序列号发回给客户端
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err == nil {
+				serial := tlsConn.ConnectionState().PeerCertificates[0].SerialNumber
+				tlsConn.Write(serial.Bytes())
+			}
+			tlsConn.Close()
+		}
+	}()
+
+	// 返回握手时双方使用的证书的序列号
+	serials := func() (server, client int64, err error) {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), clientCerts.Config())
+		if err != nil {
+			return 0, 0, err
+		}
+		defer conn.Close()
+		b := make([]byte, 8)
+		n, err := conn.Read(b)
+		if err != nil {
+			return 0, 0, err
+		}
+		server = conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+		return server, new(big.Int).SetBytes(b[:n]).Int64(), nil
+	}
+
+	server, client, err := serials()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), server)
+	require.Equal(t, int64(1), client)
+
+	// 所有证书都换成由新的根证书签发的证书
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go auth.NewWatcher(10*time.Millisecond, serverCerts, clientCerts).Run(ctx)
+	writeTestCerts(t, dir, 2)
+
+	require.Eventually(t, func() bool {
+		server, client, err := serials()
+		return err == nil && server == 2 && client == 2
+	}, 3*time.Second, 20*time.Millisecond)
+}
+
+func TestReloadPolicy(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("p, reader, *, read\n"), 0644))
+	authorizer := auth.NewAuthorizer(auth.ACLModelFile, policy)
+	require.Error(t, authorizer.Authorize("alice", "orders", "read"))
+
+	watcher := auth.NewWatcher(time.Hour, authorizer)
+	require.NoError(t, os.WriteFile(policy, []byte("p, reader, *, read\ng, alice, reader\n"), 0644))
+	require.NoError(t, watcher.ReloadAll())
+	require.NoError(t, authorizer.Authorize("alice", "orders", "read"))
+
+	// 策略文件有错误时继续使用原来的策略
+	require.NoError(t, os.Remove(policy))
+	require.Error(t, watcher.ReloadAll())
+	require.NoError(t, authorizer.Authorize("alice", "orders", "read"))
+}
@@ -0,0 +1,417 @@
+package tests
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/mux"
+	"github.com/youngfr/dcls/internal/replication"
+	"github.com/youngfr/dcls/internal/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// 复制流量和 gRPC 流量共用一个端口的节点
+type replicationNode struct {
+	*testServer
+	peers *mux.StreamLayer
+	spans *trace.MemoryExporter
+}
+
+// leader 为空时节点是 leader，否则复制 leader 的日志
+func setupReplicationNode(t *testing.T, leader string) *replicationNode {
+	t.Helper()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+	lis, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	dir, err := os.MkdirTemp("", "replication-log-services")
+	require.NoError(t, err)
+	clog, err := dclslog.NewLog(dir, dclslog.Config{})
+	require.NoError(t, err)
+
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+		ServerName:      addr,
+	})
+	require.NoError(t, err)
+	peerTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	m := mux.New(lis)
+	peers, err := mux.NewStreamLayer(m.Match(mux.PeerStream), serverTLSConfig, peerTLSConfig)
+	require.NoError(t, err)
+
+	spans := &trace.MemoryExporter{}
+	traced := replication.NewTracedLog(clog)
+	c := &logserver.LogImplConfig{
+		CommitLog:  traced,
+		Authorizer: auth.NewAuthorizer(auth.ACLModelFile, auth.ACLPolicyFile),
+		Archives:   traced,
+		Tracer:     trace.NewTracer(spans),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	following := make(chan struct{})
+	if leader != "" {
+		follower, err := replication.NewFollower(replication.Config{
+			Log:           traced,
+			Leader:        leader,
+			Dialer:        peers,
+			Timeout:       time.Second,
+			RetryInterval: 20 * time.Millisecond,
+			Tracer:        c.Tracer,
+		})
+		require.NoError(t, err)
+		c.Replica = follower
+		go func() {
+			defer close(following)
+			follower.Run(ctx)
+		}()
+	} else {
+		close(following)
+	}
+	replicator, err := replication.NewServer(replication.Config{
+		Log:          traced,
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      time.Second,
+	})
+	require.NoError(t, err)
+	server, err := logserver.NewgRPCServer(c, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	grpcLis := m.Default()
+	go replicator.Serve(peers)
+	go server.Serve(grpcLis)
+	go m.Serve()
+
+	t.Cleanup(func() {
+		cancel()
+		<-following
+		replicator.Close()
+		server.Stop()
+		m.Close()
+		clog.Close()
+		os.RemoveAll(dir)
+		os.RemoveAll(dir + ".archive")
+	})
+	return &replicationNode{
+		testServer: &testServer{Addr: addr, Dir: dir, Log: clog, Config: c},
+		peers:      peers,
+		spans:      spans,
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leader := setupReplicationNode(t, "")
+	follower := setupReplicationNode(t, leader.Addr)
+	leaderClient := leader.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	followerClient := follower.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	for _, v := range []string{"first", "second", "third"} {
+		_, err := leaderClient.Append(ctx, &api.AppendRequest{
+			Record: &api.Record{Value: []byte(v), Topic: "orders"},
+		})
+		require.NoError(t, err)
+	}
+
+	// 线性一致读等待 follower 追上 leader
+	rsp, err := followerClient.Read(ctx, &api.ReadRequest{Offset: 2, Consistency: api.Consistency_LINEARIZABLE})
+	require.NoError(t, err)
+	require.Equal(t, []byte("third"), rsp.Record.Value)
+	require.Equal(t, "orders", rsp.Record.Topic)
+	require.EqualValues(t, 2, rsp.CommitIndex)
+
+	// follower 不接受写入
+	_, err = followerClient.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("nope")}})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = followerClient.Reset(ctx, &api.ResetRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// leader 重置之后 follower 也重置并复制新的日志
+	reset, err := leaderClient.Reset(ctx, &api.ResetRequest{})
+	require.NoError(t, err)
+	_, err = leaderClient.Reset(ctx, &api.ResetRequest{ConfirmationToken: reset.ConfirmationToken})
+	require.NoError(t, err)
+	_, err = leaderClient.Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("new")}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		next, err := follower.Log.NextOffset()
+		return err == nil && next == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	rsp, err = followerClient.Read(ctx, &api.ReadRequest{Offset: 0, Consistency: api.Consistency_LINEARIZABLE})
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), rsp.Record.Value)
+	archives, err := follower.Log.ListArchives()
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+
+	// 追上之后 follower 不再落后
+	rsp, err = followerClient.Read(ctx, &api.ReadRequest{
+		Offset:        0,
+		Consistency:   api.Consistency_BOUNDED,
+		MaxLagOffsets: 1,
+		MaxLagMs:      1000,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), rsp.Record.Value)
+}
+
+func TestReplicationContinuesTrace(t *testing.T) {
+	leader := setupReplicationNode(t, "")
+	follower := setupReplicationNode(t, leader.Addr)
+	client := leader.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+
+	rsp, err := client.Append(context.Background(), &api.AppendRequest{Record: &api.Record{Value: []byte("traced")}})
+	require.NoError(t, err)
+	var request trace.SpanData
+	for _, span := range leader.spans.Spans() {
+		if span.Name == "/log.v1.Log/Append" {
+			request = span
+		}
+	}
+	require.NotEmpty(t, request.TraceID)
+
+	// follower 追加这条记录的 span 是 leader 上追加请求的 span 的子 span
+	var replicated, appended trace.SpanData
+	require.Eventually(t, func() bool {
+		for _, span := range follower.spans.Spans() {
+			switch span.Name {
+			case "replication.Append":
+				replicated = span
+			case "log.Append":
+				appended = span
+			}
+		}
+		return replicated.SpanID != "" && appended.SpanID != ""
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, request.TraceID, replicated.TraceID)
+	require.Equal(t, request.SpanID, replicated.ParentID)
+	require.EqualValues(t, rsp.Offset, replicated.Attributes["offset"])
+	require.Equal(t, request.TraceID, appended.TraceID)
+	require.Equal(t, replicated.SpanID, appended.ParentID)
+}
+
+func TestReplicationRejectsCertificatesWithoutServerAuth(t *testing.T) {
+	leader := setupReplicationNode(t, "")
+	root := leader.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	_, err := root.Append(context.Background(), &api.AppendRequest{Record: &api.Record{Value: []byte("secret")}})
+	require.NoError(t, err)
+
+	// 节点的证书可以复制日志
+	conn, err := leader.peers.Dial(leader.Addr, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, json.NewEncoder(conn).Encode(map[string]uint64{"next": 0}))
+	var batch struct {
+		Records [][]byte `json:"records"`
+	}
+	require.NoError(t, json.NewDecoder(conn).Decode(&batch))
+	require.Len(t, batch.Records, 1)
+
+	// 同一个根证书签发的客户端证书可以完成 TLS 握手，但它只有 ClientAuth 用途，不是节点的证书
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	clientTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        auth.RootClientCertFile,
+		KeyFile:         auth.RootClientKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(clientTLSConfig.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	require.NotContains(t, leaf.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	client, err := mux.NewStreamLayer(leader.peers, serverTLSConfig, clientTLSConfig)
+	require.NoError(t, err)
+
+	conn, err = client.Dial(leader.Addr, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, json.NewEncoder(conn).Encode(map[string]uint64{"next": 0}))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+// 按分区保存日志的节点，只运行复制层
+type partitionNode struct {
+	addr       string
+	peers      *mux.StreamLayer
+	partitions *replication.PartitionLogs
+}
+
+func setupPartitionNode(t *testing.T) *partitionNode {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  true,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	peerTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:  false,
+		EnableMutualTLS: true,
+		CertFile:        auth.ServerCertFile,
+		KeyFile:         auth.ServerKeyFile,
+		CAFile:          auth.CAFile,
+	})
+	require.NoError(t, err)
+	m := mux.New(lis)
+	peers, err := mux.NewStreamLayer(m.Match(mux.PeerStream), serverTLSConfig, peerTLSConfig)
+	require.NoError(t, err)
+
+	clog, err := dclslog.NewLog(t.TempDir(), dclslog.Config{})
+	require.NoError(t, err)
+	partitions, err := replication.NewPartitionLogs(t.TempDir(), dclslog.Config{})
+	require.NoError(t, err)
+	replicator, err := replication.NewServer(replication.Config{
+		Log:          clog,
+		Partitions:   partitions,
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      time.Second,
+	})
+	require.NoError(t, err)
+	m.Default()
+	go replicator.Serve(peers)
+	go m.Serve()
+
+	t.Cleanup(func() {
+		replicator.Close()
+		m.Close()
+		partitions.Close()
+		clog.Close()
+	})
+	return &partitionNode{addr: lis.Addr().String(), peers: peers, partitions: partitions}
+}
+
+type nodeAddrs map[string]string
+
+func (n nodeAddrs) Addr(node string) (string, error) {
+	addr, ok := n[node]
+	if !ok {
+		return "", fmt.Errorf("unknown node %s", node)
+	}
+	return addr, nil
+}
+
+func TestMoverMovesPartitionWhileWriting(t *testing.T) {
+	a := setupPartitionNode(t)
+	b := setupPartitionNode(t)
+	addrs := nodeAddrs{"a": a.addr, "b": b.addr}
+
+	src, err := a.partitions.PartitionLog("orders", 0)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err := src.Append(&api.Record{Value: []byte(fmt.Sprintf("before-%d", i)), Topic: "orders"})
+		require.NoError(t, err)
+	}
+	// 移动期间 a 上的分区继续写入
+	stop := make(chan struct{})
+	written := make(chan uint64)
+	go func() {
+		var n uint64
+		defer func() { written <- n }()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := src.Append(&api.Record{Value: []byte(fmt.Sprintf("during-%d", n)), Topic: "orders"}); err != nil {
+				return
+			}
+			n++
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// 每个节点的控制器都对同一次移动调用 Move
+	movers := make(map[string]*replication.Mover)
+	for name, node := range map[string]*partitionNode{"a": a, "b": b} {
+		mover, err := replication.NewMover(replication.MoverConfig{
+			Config: replication.Config{
+				Dialer:        node.peers,
+				PollInterval:  10 * time.Millisecond,
+				Timeout:       time.Second,
+				RetryInterval: 20 * time.Millisecond,
+			},
+			NodeName:   name,
+			Partitions: node.partitions,
+			Resolver:   addrs,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { mover.Close() })
+		movers[name] = mover
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errs := make(chan error, len(movers))
+	for _, mover := range movers {
+		go func() { errs <- mover.Move(ctx, "orders", 0, "a", "b") }()
+	}
+	for range movers {
+		require.NoError(t, <-errs)
+	}
+	close(stop)
+	require.NotZero(t, <-written)
+
+	// Move 返回之后 b 继续复制，最终和 a 完全相同
+	dst, err := b.partitions.PartitionLog("orders", 0)
+	require.NoError(t, err)
+	next, err := src.NextOffset()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		got, err := dst.NextOffset()
+		return err == nil && got == next
+	}, 3*time.Second, 10*time.Millisecond)
+	for off := uint64(0); off < next; off++ {
+		want, err := src.Read(off)
+		require.NoError(t, err)
+		got, err := dst.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want.Value, got.Value)
+	}
+
+	// 其他节点上的分区不受影响
+	other, err := b.partitions.PartitionLog("orders", 1)
+	require.NoError(t, err)
+	empty, err := other.NextOffset()
+	require.NoError(t, err)
+	require.Zero(t, empty)
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/sign"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecordSignatures(t *testing.T) {
+	dir := t.TempDir()
+	// 返回签名密钥，trusted 为真时把公钥加入信任列表
+	newSigner := func(name string, trusted bool) *sign.Signer {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		privPEM, pubPEM, err := sign.EncodeKeyPair(name, key)
+		require.NoError(t, err)
+		keyFile := filepath.Join(dir, name+".pem")
+		require.NoError(t, os.WriteFile(keyFile, privPEM, 0600))
+		if trusted {
+			f, err := os.OpenFile(filepath.Join(dir, "trusted-keys.pem"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			require.NoError(t, err)
+			_, err = f.Write(pubPEM)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		}
+		signer, err := sign.LoadSigner(keyFile)
+		require.NoError(t, err)
+		require.Equal(t, name, signer.KeyID)
+		return signer
+	}
+	producer := newSigner("orders-producer", true)
+	stranger := newSigner("stranger", false)
+
+	registry, err := sign.NewRegistry(filepath.Join(dir, "trusted-keys.pem"))
+	require.NoError(t, err)
+	policy := &sign.Policy{Registry: registry, Topics: []string{"audit.*"}}
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Signatures = policy
+	})
+
+	signing := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile,
+		grpc.WithUnaryInterceptor(sign.UnaryClientInterceptor(producer, policy)))
+	plain := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile)
+	ctx := context.Background()
+
+	record := &api.Record{
+		Topic:   "audit.orders",
+		Key:     []byte("order-1"),
+		Value:   []byte("created"),
+		Headers: map[string][]byte{"trace": []byte("abc")},
+	}
+	appendRsp, err := signing.Append(ctx, &api.AppendRequest{Record: record})
+	require.NoError(t, err)
+	// 拦截器不修改调用者的记录
+	require.Empty(t, record.Signature)
+
+	readRsp, err := signing.Read(ctx, &api.ReadRequest{Offset: appendRsp.Offset})
+	require.NoError(t, err)
+	require.Equal(t, "orders-producer", readRsp.Record.KeyId)
+	require.NoError(t, registry.Verify(readRsp.Record))
+
+	// 以 dcls- 开头的 header 不参与签名
+	mirrored := readRsp.Record
+	mirrored.Headers["dcls-source-cluster"] = []byte("east")
+	require.NoError(t, registry.Verify(mirrored))
+	_, err = plain.Append(ctx, &api.AppendRequest{Record: mirrored})
+	require.NoError(t, err)
+
+	// 要求签名的主题拒绝没有签名的记录
+	_, err = plain.Append(ctx, &api.AppendRequest{Record: &api.Record{Topic: "audit.orders", Value: []byte("x")}})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// 不信任的密钥和被篡改的记录
+	untrusted := &api.Record{Topic: "audit.orders", Value: []byte("x")}
+	stranger.Sign(untrusted)
+	_, err = plain.Append(ctx, &api.AppendRequest{Record: untrusted})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	tampered := &api.Record{Topic: "audit.orders", Value: []byte("x"), Headers: map[string][]byte{"trace": []byte("abc")}}
+	producer.Sign(tampered)
+	tampered.Headers["trace"] = []byte("abd")
+	_, err = plain.Append(ctx, &api.AppendRequest{Record: tampered})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 其他主题接受没有签名的记录，消费者读取时也不要求签名
+	appendRsp, err = plain.Append(ctx, &api.AppendRequest{Record: &api.Record{Topic: "orders", Value: []byte("y")}})
+	require.NoError(t, err)
+	_, err = signing.Read(ctx, &api.ReadRequest{Offset: appendRsp.Offset})
+	require.NoError(t, err)
+}
@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"os/signal"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	dclslog "github.com/youngfr/dcls/internal/log"
+)
+
+// 限制进程能写入的文件大小，让存储文件的同步失败
+func TestSyncOnAppendFailureIsNotIndexed(t *testing.T) {
+	dir := t.TempDir()
+	clog, err := dclslog.NewLog(dir, dclslog.Config{SyncOnAppend: true})
+	require.NoError(t, err)
+	off, err := clog.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	require.Zero(t, off)
+
+	// 超过限制时进程默认被 SIGXFSZ 杀死，忽略它之后 write 返回 EFBIG
+	signal.Ignore(syscall.SIGXFSZ)
+	defer signal.Reset(syscall.SIGXFSZ)
+	var limit syscall.Rlimit
+	require.NoError(t, syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit))
+	require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: 1, Max: limit.Max}))
+	_, err = clog.Append(&api.Record{Value: []byte("second")})
+	// 磁盘的问题没有解决之前健康检查一直失败
+	checkErr := clog.Check()
+	require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit))
+	require.Error(t, err)
+	require.Error(t, checkErr)
+
+	// 同步失败的记录没有被索引，读不到
+	next, err := clog.NextOffset()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, next)
+	_, err = clog.Read(1)
+	require.Error(t, err)
+
+	// 探测文件和活跃的 segment 都可以写回磁盘之后存储恢复，之后的记录使用同一个下标
+	require.NoError(t, clog.Check())
+	off, err = clog.Append(&api.Record{Value: []byte("third")})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, off)
+	require.NoError(t, clog.Close())
+
+	clog, err = dclslog.NewLog(dir, dclslog.Config{SyncOnAppend: true})
+	require.NoError(t, err)
+	defer clog.Close()
+	for off, want := range []string{"first", "third"} {
+		record, err := clog.Read(uint64(off))
+		require.NoError(t, err)
+		require.Equal(t, []byte(want), record.Value)
+	}
+}
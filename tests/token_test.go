@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestTokenAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCerts(t, dir, 1)
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	keyFile := filepath.Join(dir, "token.key")
+	require.NoError(t, os.WriteFile(keyFile, secret, 0600))
+	tokens, err := auth.NewTokenAuthenticator(keyFile, "dcls")
+	require.NoError(t, err)
+
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("p, orders service, *, append\np, client, *, read\n"), 0644))
+
+	// 服务器同时接受客户端证书和令牌
+	clog, err := dclslog.NewLog(t.TempDir(), dclslog.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	serverTLSConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+		IsServerConfig:     true,
+		EnableMutualTLS:    true,
+		ClientCertOptional: true,
+		CertFile:           filepath.Join(dir, "server.pem"),
+		KeyFile:            filepath.Join(dir, "server-key.pem"),
+		CAFile:             filepath.Join(dir, "ca.pem"),
+	})
+	require.NoError(t, err)
+	server, err := logserver.NewgRPCServer(&logserver.LogImplConfig{
+		CommitLog:     clog,
+		Authorizer:    auth.NewAuthorizer(auth.ACLModelFile, policy),
+		Authenticator: logserver.ChainAuthenticator{logserver.MTLSAuthenticator{}, tokens},
+	}, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+	lis, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// 参数 token 为空时使用客户端证书，否则不提供客户端证书而使用令牌
+	connect := func(token string) api.LogClient {
+		tlsConfig, err := auth.SetupTLSConfig(auth.TLSConfig{
+			EnableMutualTLS: token == "",
+			CertFile:        filepath.Join(dir, "client.pem"),
+			KeyFile:         filepath.Join(dir, "client-key.pem"),
+			CAFile:          filepath.Join(dir, "ca.pem"),
+		})
+		require.NoError(t, err)
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+		if token != "" {
+			opts = append(opts, grpc.WithPerRPCCredentials(auth.TokenCredentials(token)))
+		}
+		conn, err := grpc.Dial(addr, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return api.NewLogClient(conn)
+	}
+	sign := func(key any, claims auth.TokenClaims) string {
+		token, err := auth.SignToken(key, claims)
+		require.NoError(t, err)
+		return token
+	}
+	valid := auth.TokenClaims{
+		Subject:   "orders service",
+		Audience:  auth.Audience{"dcls"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	ctx := context.Background()
+	appendWith := func(token string) error {
+		_, err := connect(token).Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}})
+		return err
+	}
+
+	// 令牌中的身份和证书中的身份一样用于访问控制
+	require.NoError(t, appendWith(sign(secret, valid)))
+	_, err = connect("").Read(ctx, &api.ReadRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = connect("").Append(ctx, &api.AppendRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	wrongAudience := valid
+	wrongAudience.Audience = auth.Audience{"other"}
+	noExpiry := valid
+	noExpiry.ExpiresAt = 0
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	for name, token := range map[string]string{
+		"expired":        sign(secret, expired),
+		"wrong audience": sign(secret, wrongAudience),
+		"no expiry":      sign(secret, noExpiry),
+		"wrong key":      sign([]byte("fedcba9876543210fedcba9876543210"), valid),
+		"wrong alg":      sign(edKey, valid),
+		"malformed":      "not-a-token",
+	} {
+		require.Equal(t, codes.Unauthenticated, status.Code(appendWith(token)), name)
+	}
+	// 提供了令牌时返回令牌的错误，而不是没有客户端证书
+	err = appendWith(sign(secret, expired))
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "token expired")
+	// 没有提供任何凭据时返回第一个认证方式的错误
+	anonymousTLS, err := auth.SetupTLSConfig(auth.TLSConfig{CAFile: filepath.Join(dir, "ca.pem")})
+	require.NoError(t, err)
+	anonymous, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(anonymousTLS)))
+	require.NoError(t, err)
+	defer anonymous.Close()
+	_, err = api.NewLogClient(anonymous).Read(ctx, &api.ReadRequest{Offset: 0})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "no verified client certificate")
+
+	// 换成 Ed25519 公钥之后只接受 EdDSA 令牌
+	der, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	require.NoError(t, tokens.Reload())
+	require.NoError(t, appendWith(sign(edKey, valid)))
+	require.Equal(t, codes.Unauthenticated, status.Code(appendWith(sign(secret, valid))))
+}
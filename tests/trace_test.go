@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	api "github.com/youngfr/dcls/api/v1"
+	"github.com/youngfr/dcls/internal/auth"
+	dclslog "github.com/youngfr/dcls/internal/log"
+	"github.com/youngfr/dcls/internal/logserver"
+	"github.com/youngfr/dcls/internal/trace"
+	"google.golang.org/grpc"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := &trace.MemoryExporter{}
+	tracer := trace.NewTracer(exporter)
+	s := setupTestServer(t, func(c *logserver.LogImplConfig) {
+		c.Tracer = tracer
+	})
+	client := s.client(t, auth.RootClientCertFile, auth.RootClientKeyFile,
+		grpc.WithChainUnaryInterceptor(trace.UnaryClientInterceptor(tracer)))
+	ctx := context.Background()
+
+	_, err := client.Append(ctx, &api.AppendRequest{Record: &api.Record{Topic: "orders", Value: []byte("hello")}})
+	require.NoError(t, err)
+	resp, err := client.Append(ctx, &api.AppendRequest{Record: &api.Record{Topic: "orders", Value: []byte("world")}})
+	require.NoError(t, err)
+
+	// 子 span 先于父 span 结束
+	spans := exporter.Spans()
+	require.Len(t, spans, 12)
+	last := spans[6:]
+	names := make([]string, len(last))
+	for i, span := range last {
+		names[i] = span.Name
+	}
+	require.Equal(t, []string{
+		"store.Append",
+		"index.Write",
+		"segment.Append",
+		"log.Append",
+		"/log.v1.Log/Append",
+		"/log.v1.Log/Append",
+	}, names)
+
+	// 客户端、服务器和存储层的 span 属于同一个 trace，并且逐层嵌套
+	for i := 0; i < len(last); i++ {
+		require.Equal(t, last[5].TraceID, last[i].TraceID)
+	}
+	require.Equal(t, last[2].SpanID, last[0].ParentID)
+	for i := 1; i < len(last)-1; i++ {
+		require.Equal(t, last[i+1].SpanID, last[i].ParentID)
+	}
+	require.Empty(t, last[5].ParentID)
+	require.NotEqual(t, spans[0].TraceID, last[0].TraceID)
+
+	require.EqualValues(t, resp.Offset, last[1].Attributes["relative_offset"])
+	require.EqualValues(t, resp.Offset, last[2].Attributes["offset"])
+	require.EqualValues(t, 0, last[2].Attributes["segment"])
+	require.EqualValues(t, resp.Offset, last[3].Attributes["offset"])
+	require.Equal(t, "orders", last[4].Attributes["topic"])
+	require.Equal(t, "OK", last[5].Attributes["rpc.code"])
+}
+
+func TestTracingSyncOnAppend(t *testing.T) {
+	dir := t.TempDir()
+	var c dclslog.Config
+	c.SyncOnAppend = true
+	clog, err := dclslog.NewLog(dir, c)
+	require.NoError(t, err)
+	defer clog.Close()
+
+	exporter := &trace.MemoryExporter{}
+	ctx, span := trace.NewTracer(exporter).Start(context.Background(), "test")
+	_, err = clog.AppendContext(ctx, &api.Record{Value: []byte("hello")})
+	require.NoError(t, err)
+	span.End()
+
+	names := make([]string, 0, 6)
+	for _, s := range exporter.Spans() {
+		names = append(names, s.Name)
+	}
+	require.Equal(t, []string{"store.Append", "store.Sync", "index.Write", "index.Sync", "segment.Append", "log.Append", "test"}, names)
+
+	// 同步之后记录已经在存储文件中，不需要等到读取或者关闭
+	info, err := os.Stat(filepath.Join(dir, "0.store"))
+	require.NoError(t, err)
+	require.Greater(t, info.Size(), int64(0))
+}
+
+func TestTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := trace.ParseTraceparent(tp)
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	require.Equal(t, tp, sc.Traceparent())
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := trace.ParseTraceparent(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := trace.NewFileExporter(file)
+	require.NoError(t, err)
+	tracer := trace.NewTracer(exporter)
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := trace.Start(ctx, "child")
+	child.SetAttribute("offset", 7)
+	child.End()
+	parent.End()
+	// 重复调用 End 不会再次导出
+	parent.End()
+	require.NoError(t, exporter.Close())
+
+	// context 中没有 span 时不追踪
+	_, span := trace.Start(context.Background(), "untraced")
+	require.Nil(t, span)
+	span.SetAttribute("ignored", true)
+	span.End()
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var spans []trace.SpanData
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var s trace.SpanData
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &s))
+		spans = append(spans, s)
+	}
+	require.Len(t, spans, 2)
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, spans[1].SpanID, spans[0].ParentID)
+	require.Equal(t, float64(7), spans[0].Attributes["offset"])
+}